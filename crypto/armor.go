@@ -0,0 +1,198 @@
+package crypto
+
+/* Armored key and signature files. A raw key file is whatever
+   abstract.Write produced, with nothing to say what it is, so a
+   public key loaded as a private key (or a key for another suite)
+   fails with a decode error at best and gives a wrong key at worst.
+   The armored form is PEM with headers saying what is inside:
+
+       -----BEGIN SCHNORR PUBLIC KEY-----
+       Format-Version: 1
+       Scheme: schnorr
+       Suite: Ed25519
+
+       fVlkaL4qDNaVWJBENuniTrUK0bqi6lKlqM42rcdxyms=
+       -----END SCHNORR PUBLIC KEY-----
+
+   The body is exactly what the raw file would hold. Everything
+   that loads keys checks the block type and headers, and still
+   reads raw files and the JSON of encoding.go, so old keys keep
+   working. Encrypted private keys (keyfile.go) are armored as
+   ENCRYPTED SCHNORR PRIVATE KEY and so on. */
+
+import (
+    "bytes"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Bump if the body of any armored file changes.
+const ArmorFormatVersion = "1"
+
+const (
+    armorSchnorrPrivateKey  = "SCHNORR PRIVATE KEY"
+    armorSchnorrPublicKey   = "SCHNORR PUBLIC KEY"
+    armorEd25519PrivateKey  = "ED25519 PRIVATE KEY"
+    armorEd25519PublicKey   = "ED25519 PUBLIC KEY"
+    armorDetachedSignature  = "DETACHED SIGNATURE"
+    armorEncrypted          = "ENCRYPTED "
+)
+
+// What RFC 8032 keys give as their suite; they don't use a 
+// dedis/crypto suite but the curve is the same.
+const ed25519SuiteName = "Ed25519"
+
+// The forms a key file can take.
+type KeyFileFormat string
+
+const (
+    // bare abstract.Write output, or the 32 RFC 8032 bytes
+    KeyFormatRaw    KeyFileFormat = "raw"
+    // armored, the default
+    KeyFormatPEM    KeyFileFormat = "pem"
+    // as encoding.go writes it
+    KeyFormatJSON   KeyFileFormat = "json"
+)
+
+// Checks a key format name given on the command line.
+func ParseKeyFileFormat(name string) (KeyFileFormat, error) {
+    switch KeyFileFormat(name) {
+    case KeyFormatRaw, KeyFormatPEM, KeyFormatJSON:
+        return KeyFileFormat(name), nil
+    }
+    return "", fmt.Errorf("unknown key file format %s", name)
+}
+
+// Reports whether data is an armored file.
+func IsArmored(data []byte) bool {
+    return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN "))
+}
+
+func isJSONFile(data []byte) bool {
+    return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// Wraps body in a PEM block with the standard headers. suiteName
+// may be empty for files that don't depend on one.
+func armor(blockType string, scheme SignatureScheme, suiteName string, 
+           body []byte, extra map[string]string) []byte {
+    headers := map[string]string{
+        "Format-Version": ArmorFormatVersion,
+        "Scheme":         string(scheme),
+    }
+    if suiteName != "" {
+        headers["Suite"] = suiteName
+    }
+    for k, v := range extra {
+        headers[k] = v
+    }
+    return pem.EncodeToMemory(&pem.Block{Type: blockType, Headers: headers, Bytes: body})
+}
+
+// Decodes a PEM file and checks that it is one of the given block
+// types with the right headers. An empty scheme or suite name
+// means any will do.
+func unarmor(data []byte, scheme SignatureScheme, suiteName string, types ...string) (*pem.Block, error) {
+    block, rest := pem.Decode(data)
+    if block == nil {
+        return nil, errors.New("not a PEM file")
+    }
+    if len(bytes.TrimSpace(rest)) != 0 {
+        return nil, errors.New("trailing data after the PEM block")
+    }
+
+    found := false
+    for _, t := range types {
+        found = found || block.Type == t
+    }
+    if !found {
+        return nil, fmt.Errorf("this is a %s, not a %s", block.Type, types[0])
+    }
+    if v := block.Headers["Format-Version"]; v != ArmorFormatVersion {
+        return nil, fmt.Errorf("%s format version %q is not supported", block.Type, v)
+    }
+    if s := block.Headers["Scheme"]; scheme != "" && s != string(scheme) {
+        return nil, fmt.Errorf("%s is for scheme %q, not %s", block.Type, s, scheme)
+    }
+    if s := block.Headers["Suite"]; suiteName != "" && !strings.EqualFold(s, suiteName) {
+        return nil, fmt.Errorf("%s is for suite %q, not %s", block.Type, s, suiteName)
+    }
+    return block, nil
+}
+
+// Gets the unencrypted contents of a private key file: if it is
+// encrypted, raw or armored, we ask for the passphrase and return 
+// the raw key; anything else comes back as it is.
+func decryptPrivateKey(path string, data []byte, scheme SignatureScheme, 
+                       suiteName string, blockType string) ([]byte, error) {
+    plaintext, _, err := openPrivateKey(path, data, scheme, suiteName, blockType)
+    return plaintext, err
+}
+
+// As decryptPrivateKey, also returning the passphrase it took, nil
+// if the file wasn't encrypted.
+func openPrivateKey(path string, data []byte, scheme SignatureScheme, 
+                    suiteName string, blockType string) ([]byte, []byte, error) {
+    sealed := data
+    if IsArmored(data) {
+        block, err := unarmor(data, scheme, suiteName, blockType, armorEncrypted + blockType)
+        if err != nil {
+            return nil, nil, fmt.Errorf("%s: %s", path, err.Error())
+        }
+        if block.Type == blockType {
+            return data, nil, nil
+        }
+        sealed = block.Bytes
+    }
+    if !IsEncryptedKey(sealed) {
+        return data, nil, nil
+    }
+
+    passphrase, err := KeyPassphrase(path, false)
+    if err != nil {
+        return nil, nil, err
+    }
+    plaintext, err := DecryptKeyData(sealed, passphrase)
+    if err != nil {
+        return nil, nil, fmt.Errorf("%s: %s", path, err.Error())
+    }
+    return plaintext, passphrase, nil
+}
+
+// Armors an encrypted key, see keyfile.go.
+func armorEncryptedKey(blockType string, scheme SignatureScheme, suiteName string, sealed []byte) []byte {
+    return armor(armorEncrypted + blockType, scheme, suiteName, sealed, 
+                 map[string]string{"Encryption": "scrypt-chacha20poly1305"})
+}
+
+// Writes data to path with exactly the given mode, whatever mode a
+// file already there had. It goes to a new file beside path which is
+// then renamed over it, so a failed write leaves the old one as it was.
+func writeKeyFile(path string, data []byte, mode os.FileMode) error {
+    f, err := os.CreateTemp(filepath.Dir(path), "." + filepath.Base(path) + ".*")
+    if err != nil { return err }
+    tmpPath := f.Name()
+
+    err = f.Chmod(mode)
+    if err == nil {
+        _, err = f.Write(data)
+    }
+    if err == nil {
+        err = f.Sync()
+    }
+    closeErr := f.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(tmpPath, path)
+    }
+    if err != nil {
+        os.Remove(tmpPath)
+    }
+    return err
+}
@@ -0,0 +1,162 @@
+package crypto
+
+import (
+    "bytes"
+    "encoding/json"
+    "github.com/dedis/crypto/abstract"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestSchnorrKeyFormats(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        pk := SchnorrExtractPubkey(kv)
+
+        for _, format := range []KeyFileFormat{KeyFormatRaw, KeyFormatPEM, KeyFormatJSON} {
+            data, err := SchnorrEncodeKeypair(suite, kv, format)
+            if err != nil { t.Fatal(err.Error()) }
+            decoded, err := SchnorrDecodeKeypair(suite, data)
+            if err != nil {
+                t.Error("Key pair as", format, "did not decode:", err.Error())
+            } else if !decoded.X.Equal(kv.X) || !decoded.Y.Equal(kv.Y) {
+                t.Error("Key pair as", format, "changed")
+            }
+
+            pdata, err := SchnorrEncodePubkey(suite, pk, format)
+            if err != nil { t.Fatal(err.Error()) }
+            pdecoded, err := SchnorrDecodePubkey(suite, pdata)
+            if err != nil {
+                t.Error("Public key as", format, "did not decode:", err.Error())
+            } else if !pdecoded.Y.Equal(pk.Y) {
+                t.Error("Public key as", format, "changed")
+            }
+
+            // the wrong kind of key has to be refused, not misread
+            _, err = SchnorrDecodePubkey(suite, data)
+            if err == nil {
+                t.Error("Key pair as", format, "decoded as a public key")
+            }
+            _, err = SchnorrDecodeKeypair(suite, pdata)
+            if err == nil {
+                t.Error("Public key as", format, "decoded as a key pair")
+            }
+        }
+
+        data, _ := SchnorrEncodePubkey(suite, pk, KeyFormatPEM)
+        if !strings.Contains(string(data), "-----BEGIN SCHNORR PUBLIC KEY-----") ||
+           !strings.Contains(string(data), "Suite: " + suite.String()) ||
+           !strings.Contains(string(data), "Format-Version: 1") {
+            t.Error("Unexpected armor", string(data))
+        }
+        for _, change := range [][2]string{
+            {"Suite: " + suite.String(), "Suite: Curve41417"},
+            {"Format-Version: 1", "Format-Version: 2"},
+            {"Scheme: schnorr", "Scheme: ed25519"},
+        } {
+            _, err = SchnorrDecodePubkey(suite, []byte(strings.Replace(string(data), change[0], change[1], 1)))
+            if err == nil {
+                t.Error("Decoded a public key with", change[1])
+            }
+        }
+    })
+}
+
+func TestEd25519KeyFormats(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := Ed25519GenerateKeypair()
+        if err != nil { t.Fatal(err.Error()) }
+
+        for _, format := range []KeyFileFormat{KeyFormatRaw, KeyFormatPEM, KeyFormatJSON} {
+            data, err := Ed25519EncodeKeypair(kv, format)
+            if err != nil { t.Fatal(err.Error()) }
+            decoded, err := Ed25519DecodeKeypair(data)
+            if err != nil {
+                t.Error("Key pair as", format, "did not decode:", err.Error())
+            } else if !bytes.Equal(decoded.Seed, kv.Seed) || !bytes.Equal(decoded.Public, kv.Public) {
+                t.Error("Key pair as", format, "changed")
+            }
+
+            pdata, err := Ed25519EncodePubkey(kv.Public, format)
+            if err != nil { t.Fatal(err.Error()) }
+            public, err := Ed25519DecodePubkey(pdata)
+            if err != nil {
+                t.Error("Public key as", format, "did not decode:", err.Error())
+            } else if !bytes.Equal(public, kv.Public) {
+                t.Error("Public key as", format, "changed")
+            }
+
+            if format != KeyFormatRaw {
+                _, err = Ed25519DecodePubkey(data)
+                if err == nil {
+                    t.Error("Key pair as", format, "decoded as a public key")
+                }
+            }
+        }
+
+        // a Schnorr key in an Ed25519 slot
+        skv, _ := SchnorrGenerateKeypair(suite)
+        data, _ := SchnorrEncodePubkey(suite, SchnorrExtractPubkey(skv), KeyFormatPEM)
+        _, err = Ed25519DecodePubkey(data)
+        if err == nil {
+            t.Error("Schnorr public key decoded as an Ed25519 one")
+        }
+    })
+}
+
+func TestDetachedSignatureFormats(t *testing.T) {
+    sig := DetachedSignature{
+        Scheme:        SchemeEd25519,
+        HashAlgorithm: HashSHA256,
+        Digest:        bytes.Repeat([]byte{0xab}, 32),
+        Signature:     bytes.Repeat([]byte{0xcd}, 64),
+    }
+
+    armored := EncodeDetachedSignature(sig)
+    legacy, _ := json.Marshal(sig)
+    for _, data := range [][]byte{armored, legacy} {
+        decoded, err := DecodeDetachedSignature(data)
+        if err != nil { t.Fatal(err.Error()) }
+        if decoded.Scheme != sig.Scheme || decoded.HashAlgorithm != sig.HashAlgorithm ||
+           !bytes.Equal(decoded.Digest, sig.Digest) || !bytes.Equal(decoded.Signature, sig.Signature) {
+            t.Error("Detached signature changed:", string(data))
+        }
+    }
+}
+
+// Saving a key over a file anyone could read must leave only the
+// owner able to read it, and no temporary files behind.
+func TestKeyFileMode(t *testing.T) {
+    suite := DefaultSuite()
+    kv, err := SchnorrGenerateKeypair(suite)
+    if err != nil { t.Fatal(err.Error()) }
+
+    dir := t.TempDir()
+    path := filepath.Join(dir, "key.pri")
+    err = ioutil.WriteFile(path, []byte("old contents"), 0644)
+    if err != nil { t.Fatal(err.Error()) }
+    err = os.Chmod(path, 0644)
+    if err != nil { t.Fatal(err.Error()) }
+
+    err = SchnorrSaveKeypair(path, suite, kv)
+    if err != nil { t.Fatal(err.Error()) }
+    info, err := os.Stat(path)
+    if err != nil { t.Fatal(err.Error()) }
+    if info.Mode().Perm() != 0600 {
+        t.Error("Key file left with mode", info.Mode().Perm())
+    }
+    loaded, err := SchnorrLoadKeypair(path, suite)
+    if err != nil || !loaded.X.Equal(kv.X) {
+        t.Error("Key file does not hold the new key")
+    }
+
+    entries, err := ioutil.ReadDir(dir)
+    if err != nil { t.Fatal(err.Error()) }
+    if len(entries) != 1 {
+        t.Error("Expected only the key file, found", len(entries), "files")
+    }
+}
@@ -0,0 +1,127 @@
+package crypto
+
+import (
+    "github.com/dedis/crypto/abstract"
+    "reflect"
+    "strconv"
+    "testing"
+)
+
+// Signs count messages in (R, S) form with a few different keys.
+func makeBatch(t *testing.T, suite abstract.Suite, count int) ([]SchnorrPublicKey, [][]byte, [][]byte) {
+    var keys []SchnorrKeyset
+    for i := 0; i < 5; i++ {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        keys = append(keys, kv)
+    }
+
+    pkeys := make([]SchnorrPublicKey, count)
+    msgs := make([][]byte, count)
+    sigs := make([][]byte, count)
+    for i := 0; i < count; i++ {
+        kv := keys[i % len(keys)]
+        pkeys[i] = SchnorrExtractPubkey(kv)
+        msgs[i] = []byte("batch message " + strconv.Itoa(i))
+        sig, err := SchnorrSignWithOptions(suite, kv, msgs[i], SchnorrSignOptions{Format: SchnorrFormatRS})
+        if err != nil { t.Fatal(err.Error()) }
+        sigs[i] = sig
+    }
+    return pkeys, msgs, sigs
+}
+
+func TestSchnorrRSignature(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        pk := SchnorrExtractPubkey(kv)
+        message := []byte("This is a test")
+
+        rsig, err := SchnorrSignWithOptions(suite, kv, message, SchnorrSignOptions{Format: SchnorrFormatRS})
+        if err != nil { t.Fatal(err.Error()) }
+        v, err := SchnorrVerifyRS(suite, pk, message, rsig)
+        if err != nil || v == false {
+            t.Error("(R, S) signature did not verify")
+        }
+        v, err = SchnorrVerifyRS(suite, pk, []byte("Clearly this shouldn't work"), rsig)
+        if err != nil || v == true {
+            t.Error("(R, S) signature verified for the wrong message")
+        }
+
+        // the same nonce gives the same signature in either form
+        sig, err := SchnorrSignWithOptions(suite, kv, message, SchnorrSignOptions{Nonce: SchnorrNonceDeterministic})
+        if err != nil { t.Fatal(err.Error()) }
+        rsig, err = SchnorrSignWithOptions(suite, kv, message, 
+                                           SchnorrSignOptions{Nonce: SchnorrNonceDeterministic, Format: SchnorrFormatRS})
+        if err != nil { t.Fatal(err.Error()) }
+        converted, err := SchnorrSignatureToRS(suite, pk, message, sig, nil)
+        if err != nil { t.Fatal(err.Error()) }
+        if !reflect.DeepEqual(converted, rsig) {
+            t.Error("Converted signature differs from the (R, S) one")
+        }
+        _, err = SchnorrSignatureToRS(suite, pk, []byte("Clearly this shouldn't work"), sig, nil)
+        if err == nil {
+            t.Error("Converted a signature that does not verify")
+        }
+    })
+}
+
+func TestSchnorrBatchVerify(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        pkeys, msgs, sigs := makeBatch(t, suite, 64)
+
+        bad, err := SchnorrBatchVerify(suite, pkeys, msgs, sigs)
+        if err != nil { t.Fatal(err.Error()) }
+        if len(bad) != 0 {
+            t.Error("Valid batch reported bad signatures", bad)
+        }
+
+        // break a few: wrong message, signature from another key,
+        // garbage that won't decode.
+        msgs[3] = []byte("Clearly this shouldn't work")
+        sigs[17], sigs[18] = sigs[18], sigs[17]
+        sigs[40] = []byte{1, 2, 3}
+
+        bad, err = SchnorrBatchVerify(suite, pkeys, msgs, sigs)
+        if err != nil { t.Fatal(err.Error()) }
+        if !reflect.DeepEqual(bad, []int{3, 17, 18, 40}) {
+            t.Error("Wrong signatures reported bad", bad)
+        }
+
+        // each one has to agree with verifying it on its own
+        for i := range sigs {
+            v, _ := SchnorrVerifyRS(suite, pkeys[i], msgs[i], sigs[i])
+            found := false
+            for _, j := range bad {
+                if i == j { found = true }
+            }
+            if v == found {
+                t.Error("Batch and single verification disagree on", i)
+            }
+        }
+
+        _, err = SchnorrBatchVerify(suite, pkeys[1:], msgs, sigs)
+        if err == nil {
+            t.Error("Accepted lists of different lengths")
+        }
+    })
+}
+
+func TestSchnorrMultiScalarMul(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        var scalars []abstract.Secret
+        var points []abstract.Point
+        expected := suite.Point().Null()
+        for i := 0; i < 7; i++ {
+            s, err := schnorrTRandomSecret(suite)
+            if err != nil { t.Fatal(err.Error()) }
+            p := suite.Point().Mul(nil, suite.Secret().SetInt64(int64(i + 2)))
+            scalars = append(scalars, s)
+            points = append(points, p)
+            expected.Add(expected, suite.Point().Mul(p, s))
+        }
+        if !schnorrMultiScalarMul(suite, scalars, points).Equal(expected) {
+            t.Error("Multi-scalar multiplication gave the wrong answer")
+        }
+    })
+}
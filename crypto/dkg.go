@@ -0,0 +1,591 @@
+package crypto
+
+/* This file implements Pedersen's distributed key generation
+   (joint Feldman VSS) for threshold groups, as an alternative to
+   the trusted dealer in threshold.go.
+
+   Every member i acts as a dealer: it picks a random polynomial f_i
+   of degree t-1, publishes Feldman commitments A_ik = g^a_ik to its
+   coefficients and sends s_ij = f_i(j) to each member j. Member j
+   checks g^s_ij == prod_k A_ik^(j^k) for every dealer, then keeps
+   x_j = sum_i s_ij. The group key is prod_i A_i0 and member j's
+   verification share g^x_j can be computed by anyone from the
+   commitments. Nobody ever holds sum_i f_i(0).
+
+   The members talk through a coordinator (keytool dkg), so the
+   shares are encrypted to the recipient's long-term key with
+   AES-GCM under a key derived from the Diffie-Hellman point between
+   the dealer's and the recipient's long-term keys. The coordinator
+   sees only ciphertexts and commitments.
+
+   There is no complaint round: if any share fails to verify the
+   member refuses to finish and names the dealer, and the run has
+   to be started again without them.
+
+   A dealer (or the coordinator) could show different members
+   different commitments, and each would happily finish with a share
+   of a different group key. So once it has finished, every member
+   signs a hash of the whole set of commitments it was given with its
+   long-term key (its echo), and the coordinator passes everyone's
+   echo to every member. A member keeps its share only if all the
+   echoes verify and all the hashes are the same as its own.
+*/
+
+import (
+    "bytes"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "golang.org/x/crypto/sha3"
+    "github.com/dedis/crypto/abstract"
+)
+
+// What echoes are signed under, so they can't be passed off as
+// anything else.
+var schnorrDKGEchoContext = []byte("vennard.ch/crypto dkg echo v1")
+
+// What the coordinator tells each member to start a run. Members
+// are the long-term public keys, in group order, and Self is the
+// position of the member receiving this.
+type SchnorrDKGSetup struct {
+    Session     []byte
+    Threshold   int
+    Self        int
+    Members     []SchnorrPublicKey
+}
+
+// One dealer's output: commitments to its polynomial and an
+// encrypted share for every member (including itself).
+type SchnorrDKGDeal struct {
+    Commitments []abstract.Point
+    Shares      [][]byte
+}
+
+// The part of a deal meant for one member.
+type SchnorrDKGDealing struct {
+    Commitments []abstract.Point
+    Share       []byte
+}
+
+// What a member ends up with. Share is its secret share as a
+// keyset (X = x_j, Y = g^x_j), and CommitmentHash the hash of 
+// the commitments it was given, see SchnorrDKGCommitmentHash.
+type SchnorrDKGResult struct {
+    Share              SchnorrKeyset
+    GroupKey           SchnorrPublicKey
+    VerificationShares []SchnorrPublicKey
+    CommitmentHash     []byte
+}
+
+// A member's word on which commitments it was given: the hash of 
+// them, signed with its long-term key.
+type SchnorrDKGEcho struct {
+    Hash        []byte
+    Signature   []byte
+}
+
+// What a member sends back once it has finished, for the coordinator
+// to check against the commitments: the group key and its
+// verification share as it sees them, a proof of possession for its
+// share and its echo.
+type SchnorrDKGReport struct {
+    GroupKey            SchnorrPublicKey
+    VerificationShare   SchnorrPublicKey
+    Proof               []byte
+    Echo                SchnorrDKGEcho
+}
+
+// One member's state during a run.
+type SchnorrDKG struct {
+    suite       abstract.Suite
+    kv          SchnorrKeyset
+    setup       SchnorrDKGSetup
+    poly        []abstract.Secret
+}
+
+// Returns the deal addressed to member j.
+func (this * SchnorrDKGDeal) For (j int) SchnorrDKGDealing {
+    return SchnorrDKGDealing{this.Commitments, this.Shares[j]}
+}
+
+// Starts a run for the member holding kv, which must be the long-term
+// key listed at setup.Self.
+func NewSchnorrDKG (suite abstract.Suite, kv SchnorrKeyset, setup SchnorrDKGSetup) (*SchnorrDKG, error) {
+    err := schnorrTCheckThreshold(setup.Threshold, len(setup.Members))
+    if err != nil {
+        return nil, err
+    }
+    if setup.Self < 0 || setup.Self >= len(setup.Members) {
+        return nil, fmt.Errorf("no member %d in the group", setup.Self)
+    }
+    if !setup.Members[setup.Self].Y.Equal(kv.Y) {
+        return nil, errors.New("our key is not the one listed for our position")
+    }
+    if len(setup.Session) == 0 {
+        return nil, errors.New("missing session identifier")
+    }
+
+    secret, err := schnorrTRandomSecret(suite)
+    if err != nil {
+        return nil, err
+    }
+    poly, err := schnorrTRandomPolynomial(suite, secret, setup.Threshold)
+    if err != nil {
+        return nil, err
+    }
+    return &SchnorrDKG{suite, kv, setup, poly}, nil
+}
+
+// Derives the AES key protecting the share dealer sends to recipient.
+// Both ends compute the same DH point from their own private key and
+// the other's public key.
+func schnorrDKGShareKey (suite abstract.Suite, session []byte, dh abstract.Point, dealer int, recipient int) []byte {
+    dh_bin, _ := dh.MarshalBinary()
+    indices := make([]byte, 8)
+    binary.BigEndian.PutUint32(indices[0:4], uint32(dealer))
+    binary.BigEndian.PutUint32(indices[4:8], uint32(recipient))
+
+    hasher := sha3.New256()
+    hasher.Write([]byte("schnorr-dkg-share-v1"))
+    hasher.Write(session)
+    hasher.Write(dh_bin)
+    hasher.Write(indices)
+    return hasher.Sum(nil)
+}
+
+func schnorrDKGAEAD (key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+// Produces our commitments and encrypted shares for every member.
+func (this * SchnorrDKG) Deal () (SchnorrDKGDeal, error) {
+    suite := this.suite
+    deal := SchnorrDKGDeal{}
+
+    for _, a := range this.poly {
+        deal.Commitments = append(deal.Commitments, suite.Point().Mul(nil, a))
+    }
+
+    for j, member := range this.setup.Members {
+        share := schnorrTEvaluate(suite, this.poly, j + 1)
+        share_bin, _ := share.MarshalBinary()
+
+        dh := suite.Point().Mul(member.Y, this.kv.X)
+        aead, err := schnorrDKGAEAD(schnorrDKGShareKey(suite, this.setup.Session, dh, this.setup.Self, j))
+        if err != nil {
+            return SchnorrDKGDeal{}, err
+        }
+        nonce := make([]byte, aead.NonceSize())
+        _, err = rand.Read(nonce)
+        if err != nil {
+            return SchnorrDKGDeal{}, err
+        }
+        deal.Shares = append(deal.Shares, aead.Seal(nonce, nonce, share_bin, this.setup.Session))
+    }
+    return deal, nil
+}
+
+// Evaluates prod_k A_k^(index^k), i.e. g^f(index) from the commitments.
+func schnorrDKGEvaluateCommitments (suite abstract.Suite, commitments []abstract.Point, index int) abstract.Point {
+    xs := suite.Secret().SetInt64(int64(index))
+    result := suite.Point().Null()
+    for k := len(commitments) - 1; k >= 0; k-- {
+        result = suite.Point().Mul(result, xs)
+        result.Add(result, commitments[k])
+    }
+    return result
+}
+
+// Computes the group key and every member's verification share from
+// the commitments of all n dealers. Needs no secrets, so the
+// coordinator uses this to check what the members report.
+func SchnorrDKGPublicKeys (suite abstract.Suite, commitments [][]abstract.Point, n int) (SchnorrPublicKey, []SchnorrPublicKey) {
+    groupKey := suite.Point().Null()
+    for _, dealerCommitments := range commitments {
+        groupKey.Add(groupKey, dealerCommitments[0])
+    }
+
+    shares := make([]SchnorrPublicKey, n)
+    for j := range shares {
+        y := suite.Point().Null()
+        for _, dealerCommitments := range commitments {
+            y.Add(y, schnorrDKGEvaluateCommitments(suite, dealerCommitments, j + 1))
+        }
+        shares[j] = SchnorrPublicKey{y}
+    }
+    return SchnorrPublicKey{groupKey}, shares
+}
+
+// The hash every member must agree on: the session, the members in
+// order and every dealer's commitments in order.
+func SchnorrDKGCommitmentHash (suite abstract.Suite, session []byte, members []SchnorrPublicKey, 
+                               commitments [][]abstract.Point) []byte {
+    t := newSchnorrTranscript(schnorrLabelDKGCommitments)
+    t.Append("session", session)
+    for _, member := range members {
+        t.AppendPoint("member", member.Y)
+    }
+    for _, dealerCommitments := range commitments {
+        count := make([]byte, 4)
+        binary.BigEndian.PutUint32(count, uint32(len(dealerCommitments)))
+        t.Append("dealer", count)
+        for _, a := range dealerCommitments {
+            t.AppendPoint("commitment", a)
+        }
+    }
+    return t.Sum()
+}
+
+// Checks that there is an echo from every member, in group order,
+// that each is signed by that member's long-term key and that they
+// all carry hash. The coordinator can check this too, as it needs
+// no secrets.
+func SchnorrDKGCheckEchoes (suite abstract.Suite, members []SchnorrPublicKey, hash []byte, echoes []SchnorrDKGEcho) error {
+    if len(echoes) != len(members) {
+        return fmt.Errorf("expected %d echoes, got %d", len(members), len(echoes))
+    }
+    for i, echo := range echoes {
+        v, err := SchnorrVerifyWithContext(suite, members[i], echo.Hash, echo.Signature, schnorrDKGEchoContext)
+        if err != nil || !v {
+            return fmt.Errorf("echo from member %d is not signed by it", i)
+        }
+        if !bytes.Equal(echo.Hash, hash) {
+            return fmt.Errorf("member %d was given different commitments", i)
+        }
+    }
+    return nil
+}
+
+// Takes the dealings addressed to us, one per dealer in group order,
+// decrypts and checks every share and combines them into our share
+// of the group key.
+func (this * SchnorrDKG) Finish (dealings []SchnorrDKGDealing) (SchnorrDKGResult, error) {
+    suite := this.suite
+    n := len(this.setup.Members)
+    self := this.setup.Self
+
+    if len(dealings) != n {
+        return SchnorrDKGResult{}, fmt.Errorf("expected %d dealings, got %d", n, len(dealings))
+    }
+
+    x := suite.Secret().Zero()
+    var commitments [][]abstract.Point
+
+    for i, dealing := range dealings {
+        if len(dealing.Commitments) != this.setup.Threshold {
+            return SchnorrDKGResult{}, fmt.Errorf("dealer %d committed to the wrong number of coefficients", i)
+        }
+
+        dh := suite.Point().Mul(this.setup.Members[i].Y, this.kv.X)
+        aead, err := schnorrDKGAEAD(schnorrDKGShareKey(suite, this.setup.Session, dh, i, self))
+        if err != nil {
+            return SchnorrDKGResult{}, err
+        }
+        if len(dealing.Share) < aead.NonceSize() {
+            return SchnorrDKGResult{}, fmt.Errorf("share from dealer %d is truncated", i)
+        }
+        nonce := dealing.Share[:aead.NonceSize()]
+        share_bin, err := aead.Open(nil, nonce, dealing.Share[aead.NonceSize():], this.setup.Session)
+        if err != nil {
+            return SchnorrDKGResult{}, fmt.Errorf("could not decrypt share from dealer %d", i)
+        }
+        share := suite.Secret()
+        err = share.UnmarshalBinary(share_bin)
+        if err != nil {
+            return SchnorrDKGResult{}, fmt.Errorf("could not decode share from dealer %d", i)
+        }
+
+        // the Feldman check: g^s_ij == prod_k A_ik^(j^k)
+        expected := schnorrDKGEvaluateCommitments(suite, dealing.Commitments, self + 1)
+        if !suite.Point().Mul(nil, share).Equal(expected) {
+            return SchnorrDKGResult{}, fmt.Errorf("share from dealer %d does not match its commitments", i)
+        }
+
+        x.Add(x, share)
+        commitments = append(commitments, dealing.Commitments)
+    }
+
+    groupKey, verificationShares := SchnorrDKGPublicKeys(suite, commitments, n)
+    keyset := SchnorrKeyset{x, suite.Point().Mul(nil, x)}
+    hash := SchnorrDKGCommitmentHash(suite, this.setup.Session, this.setup.Members, commitments)
+
+    return SchnorrDKGResult{keyset, groupKey, verificationShares, hash}, nil
+}
+
+// Signs our echo for result.
+func (this * SchnorrDKG) Echo (result SchnorrDKGResult) (SchnorrDKGEcho, error) {
+    sig, err := SchnorrSign(this.suite, this.kv, result.CommitmentHash, schnorrDKGEchoContext)
+    if err != nil {
+        return SchnorrDKGEcho{}, err
+    }
+    return SchnorrDKGEcho{result.CommitmentHash, sig}, nil
+}
+
+// Our report on result, with our echo.
+func (this * SchnorrDKG) Report (result SchnorrDKGResult) (SchnorrDKGReport, error) {
+    proof, err := SchnorrProvePossession(this.suite, result.Share)
+    if err != nil {
+        return SchnorrDKGReport{}, err
+    }
+    echo, err := this.Echo(result)
+    if err != nil {
+        return SchnorrDKGReport{}, err
+    }
+    return SchnorrDKGReport{result.GroupKey, SchnorrExtractPubkey(result.Share), proof, echo}, nil
+}
+
+// Checks everyone's echoes against result: only if this succeeds
+// does everybody hold a share of the same group key.
+func (this * SchnorrDKG) CheckEchoes (result SchnorrDKGResult, echoes []SchnorrDKGEcho) error {
+    return SchnorrDKGCheckEchoes(this.suite, this.setup.Members, result.CommitmentHash, echoes)
+}
+
+// The rest of this file is the wire encoding for the messages above.
+// Counts and lengths are 32-bit big endian, points and secrets use
+// their own binary encoding.
+
+func schnorrDKGWriteInt (buf *bytes.Buffer, v int) {
+    b := make([]byte, 4)
+    binary.BigEndian.PutUint32(b, uint32(v))
+    buf.Write(b)
+}
+
+func schnorrDKGReadInt (buf *bytes.Buffer) (int, error) {
+    b := buf.Next(4)
+    if len(b) != 4 {
+        return 0, errors.New("message truncated")
+    }
+    return int(binary.BigEndian.Uint32(b)), nil
+}
+
+func schnorrDKGWriteBytes (buf *bytes.Buffer, data []byte) {
+    schnorrDKGWriteInt(buf, len(data))
+    buf.Write(data)
+}
+
+func schnorrDKGReadBytes (buf *bytes.Buffer) ([]byte, error) {
+    n, err := schnorrDKGReadInt(buf)
+    if err != nil {
+        return nil, err
+    }
+    if n > buf.Len() {
+        return nil, errors.New("message truncated")
+    }
+    return append([]byte{}, buf.Next(n)...), nil
+}
+
+func schnorrDKGWritePoints (buf *bytes.Buffer, suite abstract.Suite, points []abstract.Point) {
+    schnorrDKGWriteInt(buf, len(points))
+    for _, p := range points {
+        p_bin, _ := p.MarshalBinary()
+        buf.Write(p_bin)
+    }
+}
+
+func schnorrDKGReadPoints (buf *bytes.Buffer, suite abstract.Suite) ([]abstract.Point, error) {
+    n, err := schnorrDKGReadInt(buf)
+    if err != nil {
+        return nil, err
+    }
+    if n > buf.Len() / suite.PointLen() {
+        return nil, errors.New("message truncated")
+    }
+    points := make([]abstract.Point, n)
+    for i := range points {
+        points[i] = suite.Point()
+        err = points[i].UnmarshalBinary(buf.Next(suite.PointLen()))
+        if err != nil {
+            return nil, err
+        }
+    }
+    return points, nil
+}
+
+func SchnorrDKGEncodeSetup (suite abstract.Suite, setup SchnorrDKGSetup) []byte {
+    buf := bytes.Buffer{}
+    schnorrDKGWriteBytes(&buf, setup.Session)
+    schnorrDKGWriteInt(&buf, setup.Threshold)
+    schnorrDKGWriteInt(&buf, setup.Self)
+    var points []abstract.Point
+    for _, member := range setup.Members {
+        points = append(points, member.Y)
+    }
+    schnorrDKGWritePoints(&buf, suite, points)
+    return buf.Bytes()
+}
+
+func SchnorrDKGDecodeSetup (suite abstract.Suite, data []byte) (SchnorrDKGSetup, error) {
+    setup := SchnorrDKGSetup{}
+    buf := bytes.NewBuffer(data)
+    var err error
+
+    setup.Session, err = schnorrDKGReadBytes(buf)
+    if err != nil {
+        return setup, err
+    }
+    setup.Threshold, err = schnorrDKGReadInt(buf)
+    if err != nil {
+        return setup, err
+    }
+    setup.Self, err = schnorrDKGReadInt(buf)
+    if err != nil {
+        return setup, err
+    }
+    points, err := schnorrDKGReadPoints(buf, suite)
+    if err != nil {
+        return setup, err
+    }
+    for _, p := range points {
+        setup.Members = append(setup.Members, SchnorrPublicKey{p})
+    }
+    return setup, nil
+}
+
+func SchnorrDKGEncodeDeal (suite abstract.Suite, deal SchnorrDKGDeal) []byte {
+    buf := bytes.Buffer{}
+    schnorrDKGWritePoints(&buf, suite, deal.Commitments)
+    schnorrDKGWriteInt(&buf, len(deal.Shares))
+    for _, share := range deal.Shares {
+        schnorrDKGWriteBytes(&buf, share)
+    }
+    return buf.Bytes()
+}
+
+func SchnorrDKGDecodeDeal (suite abstract.Suite, data []byte) (SchnorrDKGDeal, error) {
+    deal := SchnorrDKGDeal{}
+    buf := bytes.NewBuffer(data)
+    var err error
+
+    deal.Commitments, err = schnorrDKGReadPoints(buf, suite)
+    if err != nil {
+        return deal, err
+    }
+    n, err := schnorrDKGReadInt(buf)
+    if err != nil {
+        return deal, err
+    }
+    if n > buf.Len() / 4 {
+        return deal, errors.New("message truncated")
+    }
+    for i := 0; i < n; i++ {
+        share, err := schnorrDKGReadBytes(buf)
+        if err != nil {
+            return deal, err
+        }
+        deal.Shares = append(deal.Shares, share)
+    }
+    return deal, nil
+}
+
+func SchnorrDKGEncodeDealings (suite abstract.Suite, dealings []SchnorrDKGDealing) []byte {
+    buf := bytes.Buffer{}
+    schnorrDKGWriteInt(&buf, len(dealings))
+    for _, dealing := range dealings {
+        schnorrDKGWritePoints(&buf, suite, dealing.Commitments)
+        schnorrDKGWriteBytes(&buf, dealing.Share)
+    }
+    return buf.Bytes()
+}
+
+func SchnorrDKGDecodeDealings (suite abstract.Suite, data []byte) ([]SchnorrDKGDealing, error) {
+    buf := bytes.NewBuffer(data)
+    n, err := schnorrDKGReadInt(buf)
+    if err != nil {
+        return nil, err
+    }
+    if n > buf.Len() / 8 {
+        return nil, errors.New("message truncated")
+    }
+    dealings := make([]SchnorrDKGDealing, n)
+    for i := range dealings {
+        dealings[i].Commitments, err = schnorrDKGReadPoints(buf, suite)
+        if err != nil {
+            return nil, err
+        }
+        dealings[i].Share, err = schnorrDKGReadBytes(buf)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return dealings, nil
+}
+
+func schnorrDKGWriteEcho (buf *bytes.Buffer, echo SchnorrDKGEcho) {
+    schnorrDKGWriteBytes(buf, echo.Hash)
+    schnorrDKGWriteBytes(buf, echo.Signature)
+}
+
+func schnorrDKGReadEcho (buf *bytes.Buffer) (SchnorrDKGEcho, error) {
+    echo := SchnorrDKGEcho{}
+    var err error
+    echo.Hash, err = schnorrDKGReadBytes(buf)
+    if err != nil {
+        return echo, err
+    }
+    echo.Signature, err = schnorrDKGReadBytes(buf)
+    return echo, err
+}
+
+func SchnorrDKGEncodeReport (suite abstract.Suite, report SchnorrDKGReport) []byte {
+    buf := bytes.Buffer{}
+    schnorrDKGWritePoints(&buf, suite, []abstract.Point{report.GroupKey.Y, report.VerificationShare.Y})
+    schnorrDKGWriteBytes(&buf, report.Proof)
+    schnorrDKGWriteEcho(&buf, report.Echo)
+    return buf.Bytes()
+}
+
+func SchnorrDKGDecodeReport (suite abstract.Suite, data []byte) (SchnorrDKGReport, error) {
+    report := SchnorrDKGReport{}
+    buf := bytes.NewBuffer(data)
+
+    points, err := schnorrDKGReadPoints(buf, suite)
+    if err != nil {
+        return report, err
+    }
+    if len(points) != 2 {
+        return report, errors.New("expected a group key and a verification share")
+    }
+    report.GroupKey = SchnorrPublicKey{points[0]}
+    report.VerificationShare = SchnorrPublicKey{points[1]}
+    report.Proof, err = schnorrDKGReadBytes(buf)
+    if err != nil {
+        return report, err
+    }
+    report.Echo, err = schnorrDKGReadEcho(buf)
+    return report, err
+}
+
+func SchnorrDKGEncodeEchoes (echoes []SchnorrDKGEcho) []byte {
+    buf := bytes.Buffer{}
+    schnorrDKGWriteInt(&buf, len(echoes))
+    for _, echo := range echoes {
+        schnorrDKGWriteEcho(&buf, echo)
+    }
+    return buf.Bytes()
+}
+
+func SchnorrDKGDecodeEchoes (data []byte) ([]SchnorrDKGEcho, error) {
+    buf := bytes.NewBuffer(data)
+    n, err := schnorrDKGReadInt(buf)
+    if err != nil {
+        return nil, err
+    }
+    if n > buf.Len() / 8 {
+        return nil, errors.New("message truncated")
+    }
+    echoes := make([]SchnorrDKGEcho, n)
+    for i := range echoes {
+        echoes[i], err = schnorrDKGReadEcho(buf)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return echoes, nil
+}
@@ -0,0 +1,241 @@
+package crypto
+
+import (
+    "bytes"
+    "github.com/dedis/crypto/abstract"
+    "testing"
+)
+
+// Runs a 2-of-3 DKG entirely in memory (going through the wire
+// encoding, as the coordinator would) and checks that everyone
+// agrees on the group key, that the shares match the verification
+// shares and that two of them can sign for the group.
+func TestDKG(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        var longterm []SchnorrKeyset
+        var members []SchnorrPublicKey
+        for i := 0; i < 3; i++ {
+            kv, err := SchnorrGenerateKeypair(suite)
+            if err != nil { t.Fatal(err.Error()) }
+            longterm = append(longterm, kv)
+            members = append(members, SchnorrExtractPubkey(kv))
+        }
+
+        session := []byte("test session")
+        var runs []*SchnorrDKG
+        var deals []SchnorrDKGDeal
+        for i, kv := range longterm {
+            setup := SchnorrDKGSetup{session, 2, i, members}
+            setup, err := SchnorrDKGDecodeSetup(suite, SchnorrDKGEncodeSetup(suite, setup))
+            if err != nil { t.Fatal(err.Error()) }
+
+            run, err := NewSchnorrDKG(suite, kv, setup)
+            if err != nil { t.Fatal(err.Error()) }
+            deal, err := run.Deal()
+            if err != nil { t.Fatal(err.Error()) }
+            deal, err = SchnorrDKGDecodeDeal(suite, SchnorrDKGEncodeDeal(suite, deal))
+            if err != nil { t.Fatal(err.Error()) }
+
+            runs = append(runs, run)
+            deals = append(deals, deal)
+        }
+
+        var results []SchnorrDKGResult
+        for j, run := range runs {
+            var dealings []SchnorrDKGDealing
+            for _, deal := range deals {
+                dealings = append(dealings, deal.For(j))
+            }
+            dealings, err := SchnorrDKGDecodeDealings(suite, SchnorrDKGEncodeDealings(suite, dealings))
+            if err != nil { t.Fatal(err.Error()) }
+
+            result, err := run.Finish(dealings)
+            if err != nil { t.Fatal(err.Error()) }
+            results = append(results, result)
+        }
+
+        var commitments [][]abstract.Point
+        for _, deal := range deals {
+            commitments = append(commitments, deal.Commitments)
+        }
+        groupKey, verificationShares := SchnorrDKGPublicKeys(suite, commitments, 3)
+
+        hash := SchnorrDKGCommitmentHash(suite, session, members, commitments)
+
+        var echoes []SchnorrDKGEcho
+        for j, result := range results {
+            if !result.GroupKey.Y.Equal(groupKey.Y) {
+                t.Error("Member", j, "disagrees on the group key")
+            }
+            if !result.Share.Y.Equal(verificationShares[j].Y) {
+                t.Error("Member", j, "share does not match its verification share")
+            }
+
+            report, err := runs[j].Report(result)
+            if err != nil { t.Fatal(err.Error()) }
+            report, err = SchnorrDKGDecodeReport(suite, SchnorrDKGEncodeReport(suite, report))
+            if err != nil { t.Fatal(err.Error()) }
+            if !report.VerificationShare.Y.Equal(verificationShares[j].Y) || !bytes.Equal(report.Echo.Hash, hash) {
+                t.Error("Member", j, "reported something else")
+            }
+            v, err := SchnorrVerifyPossession(suite, report.VerificationShare, report.Proof)
+            if err != nil || v == false {
+                t.Error("Member", j, "proof of possession failed to verify")
+            }
+            echoes = append(echoes, report.Echo)
+        }
+
+        echoes, err := SchnorrDKGDecodeEchoes(SchnorrDKGEncodeEchoes(echoes))
+        if err != nil { t.Fatal(err.Error()) }
+        err = SchnorrDKGCheckEchoes(suite, members, hash, echoes)
+        if err != nil { t.Error(err.Error()) }
+        for j, run := range runs {
+            err = run.CheckEchoes(results[j], echoes)
+            if err != nil { t.Error("Member", j, err.Error()) }
+        }
+
+        // an echo can't be moved to another member
+        echoes[0], echoes[1] = echoes[1], echoes[0]
+        err = SchnorrDKGCheckEchoes(suite, members, hash, echoes)
+        if err == nil {
+            t.Error("Echoes in the wrong order were accepted")
+        }
+
+        // members 0 and 2 sign together
+        config := SchnorrMGroupConfig{
+            Suite:       suite.String(),
+            JointKey:    groupKey,
+            Aggregation: SchnorrMAggregationThreshold,
+            Threshold:   2,
+        }
+        for _, pkey := range verificationShares {
+            config.Members = append(config.Members, SchnorrMMember{HostName: "localhost", PKey: pkey})
+        }
+        signers := []int{0, 2}
+        message := []byte("This is a test")
+
+        var privateCommits []SchnorrMPrivateCommitment
+        var commits []SchnorrMPublicCommitment
+        for _ = range signers {
+            commit, err := SchnorrMGenerateCommitment(suite)
+            if err != nil { t.Fatal(err.Error()) }
+            privateCommits = append(privateCommits, commit)
+            commits = append(commits, commit.PublicCommitment())
+        }
+        cc := SchnorrMComputeCollectiveChallenge(suite, groupKey, message, nil, SchnorrMComputeAggregateCommitment(suite, commits))
+
+        var responses []SchnorrMResponse
+        for k, i := range signers {
+            coeff, err := config.Coefficient(suite, i, signers)
+            if err != nil { t.Fatal(err.Error()) }
+            responses = append(responses, SchnorrMUnmarshallCCComputeResponse(suite, results[i].Share, coeff, privateCommits[k], cc))
+        }
+        sig := SchnorrMComputeSignatureFromResponses(suite, cc, responses)
+        buf := bytes.Buffer{} 
+        abstract.Write(&buf, &sig, suite)
+
+        verified, err := SchnorrVerify(suite, groupKey, message, buf.Bytes())
+        if err != nil { t.Error(err.Error()) }
+        if verified == false {
+            t.Error("Signature by DKG shares failed to verify")
+        }
+    })
+}
+
+// A dealer that hands out a share that does not match its 
+// commitments must be caught and named.
+func TestDKGBadShare(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        var longterm []SchnorrKeyset
+        var members []SchnorrPublicKey
+        for i := 0; i < 2; i++ {
+            kv, err := SchnorrGenerateKeypair(suite)
+            if err != nil { t.Fatal(err.Error()) }
+            longterm = append(longterm, kv)
+            members = append(members, SchnorrExtractPubkey(kv))
+        }
+
+        session := []byte("test session")
+        run0, err := NewSchnorrDKG(suite, longterm[0], SchnorrDKGSetup{session, 2, 0, members})
+        if err != nil { t.Fatal(err.Error()) }
+        run1, err := NewSchnorrDKG(suite, longterm[1], SchnorrDKGSetup{session, 2, 1, members})
+        if err != nil { t.Fatal(err.Error()) }
+
+        deal0, err := run0.Deal()
+        if err != nil { t.Fatal(err.Error()) }
+        deal1, err := run1.Deal()
+        if err != nil { t.Fatal(err.Error()) }
+
+        // dealer 1 swaps in commitments to some other polynomial
+        other, err := run0.Deal()
+        if err != nil { t.Fatal(err.Error()) }
+        deal1.Commitments = other.Commitments
+
+        _, err = run0.Finish([]SchnorrDKGDealing{deal0.For(0), deal1.For(0)})
+        if err == nil {
+            t.Error("Bad share from dealer 1 was accepted")
+        }
+
+        // and nobody else can open a share meant for member 0
+        _, err = run1.Finish([]SchnorrDKGDealing{deal0.For(0), deal1.For(1)})
+        if err == nil {
+            t.Error("Member 1 decrypted a share meant for member 0")
+        }
+    })
+}
+
+// A dealer that deals consistently to each member, but from a
+// different polynomial for member 0 than for the others, gets past
+// every share check; the echoes must catch it.
+func TestDKGEquivocation(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        var longterm []SchnorrKeyset
+        var members []SchnorrPublicKey
+        for i := 0; i < 3; i++ {
+            kv, err := SchnorrGenerateKeypair(suite)
+            if err != nil { t.Fatal(err.Error()) }
+            longterm = append(longterm, kv)
+            members = append(members, SchnorrExtractPubkey(kv))
+        }
+
+        session := []byte("test session")
+        var runs []*SchnorrDKG
+        var deals []SchnorrDKGDeal
+        for i, kv := range longterm {
+            run, err := NewSchnorrDKG(suite, kv, SchnorrDKGSetup{session, 2, i, members})
+            if err != nil { t.Fatal(err.Error()) }
+            deal, err := run.Deal()
+            if err != nil { t.Fatal(err.Error()) }
+            runs = append(runs, run)
+            deals = append(deals, deal)
+        }
+
+        // dealer 1 again, with another polynomial
+        other, err := NewSchnorrDKG(suite, longterm[1], SchnorrDKGSetup{session, 2, 1, members})
+        if err != nil { t.Fatal(err.Error()) }
+        otherDeal, err := other.Deal()
+        if err != nil { t.Fatal(err.Error()) }
+
+        var results []SchnorrDKGResult
+        var echoes []SchnorrDKGEcho
+        for j, run := range runs {
+            dealings := []SchnorrDKGDealing{deals[0].For(j), deals[1].For(j), deals[2].For(j)}
+            if j == 0 {
+                dealings[1] = otherDeal.For(j)
+            }
+            result, err := run.Finish(dealings)
+            if err != nil { t.Fatal(err.Error()) }
+            echo, err := run.Echo(result)
+            if err != nil { t.Fatal(err.Error()) }
+            results = append(results, result)
+            echoes = append(echoes, echo)
+        }
+
+        for j, run := range runs {
+            err = run.CheckEchoes(results[j], echoes)
+            if err == nil {
+                t.Error("Member", j, "accepted a run where dealer 1 equivocated")
+            }
+        }
+    })
+}
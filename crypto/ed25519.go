@@ -0,0 +1,253 @@
+package crypto
+
+/* Standard Ed25519 signatures as in RFC 8032, for when somebody
+   outside this repository has to check what we sign. Our own
+   Schnorr signatures hash with SHA3, are (S, E) pairs and are
+   written with abstract.Write, so only this code can read them.
+
+   The keys and signatures here are exactly the RFC ones: a 32 byte
+   private key (the seed), a 32 byte public key and a 64 byte
+   signature, so Go's crypto/ed25519 or OpenSSL can verify them
+   (keytool convert --to raw gets the bare key out of a PEM file).
+   For that, what is signed is standard too: a message as it is, and
+   a file as Ed25519ph (RFC 8032 section 5.1), which signs the file's
+   SHA-512 digest under a domain of its own, so the two can't be
+   confused. The actual work is done by the standard library. */
+
+import (
+    stdcrypto "crypto"
+    stded25519 "crypto/ed25519"
+    "crypto/rand"
+    "crypto/sha512"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+)
+
+// Which signature scheme a key or signature belongs to.
+type SignatureScheme string
+
+const (
+    // the SHA3 based Schnorr signatures in schnorr.go
+    SchemeSchnorr       SignatureScheme = "schnorr"
+    // RFC 8032 Ed25519
+    SchemeEd25519       SignatureScheme = "ed25519"
+)
+
+// Checks a scheme name given on the command line.
+func ParseSignatureScheme(name string) (SignatureScheme, error) {
+    switch SignatureScheme(name) {
+    case SchemeSchnorr, SchemeEd25519:
+        return SignatureScheme(name), nil
+    }
+    return "", fmt.Errorf("unknown signature scheme %s", name)
+}
+
+// An RFC 8032 key pair. Only the seed is secret; the standard
+// library expands it into the 64 byte form it wants.
+type Ed25519Keyset struct {
+    Seed    []byte
+    Public  []byte
+}
+
+// Generates a fresh RFC 8032 key pair.
+func Ed25519GenerateKeypair() (Ed25519Keyset, error) {
+    public, private, err := stded25519.GenerateKey(rand.Reader)
+    if err != nil {
+        return Ed25519Keyset{}, err
+    }
+    return Ed25519Keyset{Seed: private.Seed(), Public: public}, nil
+}
+
+// Rebuilds the key pair from a 32 byte private key.
+func Ed25519KeypairFromSeed(seed []byte) (Ed25519Keyset, error) {
+    if len(seed) != stded25519.SeedSize {
+        return Ed25519Keyset{}, fmt.Errorf("an Ed25519 private key is %d bytes, not %d",
+                                           stded25519.SeedSize, len(seed))
+    }
+    private := stded25519.NewKeyFromSeed(seed)
+    public := private.Public().(stded25519.PublicKey)
+    return Ed25519Keyset{Seed: private.Seed(), Public: public}, nil
+}
+
+// Signs the message. Ed25519 is deterministic, so there is
+// no randomness involved and nothing to go wrong here.
+func Ed25519Sign(kv Ed25519Keyset, msg []byte) []byte {
+    return stded25519.Sign(stded25519.NewKeyFromSeed(kv.Seed), msg)
+}
+
+// The hash algorithm Ed25519ph takes a digest of.
+const Ed25519PrehashAlgorithm = HashSHA512
+
+var ed25519PrehashOptions = &stded25519.Options{Hash: stdcrypto.SHA512}
+
+func checkEd25519Prehash(algorithm string, digest []byte) error {
+    if algorithm != Ed25519PrehashAlgorithm {
+        return fmt.Errorf("Ed25519ph signs %s digests, not %s", Ed25519PrehashAlgorithm, algorithm)
+    }
+    if len(digest) != sha512.Size {
+        return fmt.Errorf("a %s digest is %d bytes, not %d", algorithm, sha512.Size, len(digest))
+    }
+    return nil
+}
+
+// Signs a file's digest as Ed25519ph. The algorithm has to be
+// Ed25519PrehashAlgorithm.
+func Ed25519SignPrehash(kv Ed25519Keyset, algorithm string, digest []byte) ([]byte, error) {
+    err := checkEd25519Prehash(algorithm, digest)
+    if err != nil {
+        return nil, err
+    }
+    return stded25519.NewKeyFromSeed(kv.Seed).Sign(nil, digest, ed25519PrehashOptions)
+}
+
+// Checks an Ed25519ph signature on a file's digest.
+func Ed25519VerifyPrehash(public []byte, algorithm string, digest []byte, sig []byte) (bool, error) {
+    err := checkEd25519Prehash(algorithm, digest)
+    if err != nil {
+        return false, err
+    }
+    if len(public) != stded25519.PublicKeySize {
+        return false, fmt.Errorf("an Ed25519 public key is %d bytes, not %d",
+                                 stded25519.PublicKeySize, len(public))
+    }
+    err = stded25519.VerifyWithOptions(stded25519.PublicKey(public), digest, sig, ed25519PrehashOptions)
+    return err == nil, nil
+}
+
+// Checks an RFC 8032 signature.
+func Ed25519Verify(public []byte, msg []byte, sig []byte) (bool, error) {
+    if len(public) != stded25519.PublicKeySize {
+        return false, fmt.Errorf("an Ed25519 public key is %d bytes, not %d",
+                                 stded25519.PublicKeySize, len(public))
+    }
+    if len(sig) != stded25519.SignatureSize {
+        return false, errors.New("an Ed25519 signature is 64 bytes")
+    }
+    return stded25519.Verify(stded25519.PublicKey(public), msg, sig), nil
+}
+
+// The raw key files are the 32 bytes the RFC defines, so they can
+// be handed to anything else that speaks Ed25519. By default we 
+// write them armored, see armor.go.
+func Ed25519EncodeKeypair(kv Ed25519Keyset, format KeyFileFormat) ([]byte, error) {
+    switch format {
+    case KeyFormatRaw:
+        return kv.Seed, nil
+    case KeyFormatPEM:
+        return armor(armorEd25519PrivateKey, SchemeEd25519, ed25519SuiteName, kv.Seed, nil), nil
+    case KeyFormatJSON:
+        return json.MarshalIndent(kv, "", "    ")
+    }
+    return nil, fmt.Errorf("unknown key file format %s", format)
+}
+
+// Decodes a key pair in any of the formats. The public key is
+// always worked out again from the seed.
+func Ed25519DecodeKeypair(data []byte) (Ed25519Keyset, error) {
+    if isJSONFile(data) {
+        var kv Ed25519Keyset
+        err := json.Unmarshal(data, &kv)
+        if err != nil {
+            return kv, err
+        }
+        data = kv.Seed
+    } else if IsArmored(data) {
+        block, err := unarmor(data, SchemeEd25519, ed25519SuiteName, armorEd25519PrivateKey)
+        if err != nil {
+            return Ed25519Keyset{}, err
+        }
+        data = block.Bytes
+    }
+    return Ed25519KeypairFromSeed(data)
+}
+
+func Ed25519EncodePubkey(public []byte, format KeyFileFormat) ([]byte, error) {
+    switch format {
+    case KeyFormatRaw:
+        return public, nil
+    case KeyFormatPEM:
+        return armor(armorEd25519PublicKey, SchemeEd25519, ed25519SuiteName, public, nil), nil
+    case KeyFormatJSON:
+        return json.MarshalIndent(struct{ Public []byte }{public}, "", "    ")
+    }
+    return nil, fmt.Errorf("unknown key file format %s", format)
+}
+
+func Ed25519DecodePubkey(data []byte) ([]byte, error) {
+    if isJSONFile(data) {
+        var encoded struct{ Seed, Public []byte }
+        err := json.Unmarshal(data, &encoded)
+        if err != nil {
+            return nil, err
+        }
+        if encoded.Seed != nil {
+            return nil, errors.New("this is a key pair, not a public key")
+        }
+        data = encoded.Public
+    } else if IsArmored(data) {
+        block, err := unarmor(data, SchemeEd25519, ed25519SuiteName, armorEd25519PublicKey)
+        if err != nil {
+            return nil, err
+        }
+        data = block.Bytes
+    }
+    if len(data) != stded25519.PublicKeySize {
+        return nil, errors.New("not an Ed25519 public key")
+    }
+    return data, nil
+}
+
+func Ed25519SaveKeypair(path string, kv Ed25519Keyset) error {
+    return Ed25519SaveKeypairAs(path, kv, KeyFormatPEM)
+}
+
+func Ed25519SaveKeypairAs(path string, kv Ed25519Keyset, format KeyFileFormat) error {
+    data, err := Ed25519EncodeKeypair(kv, format)
+    if err != nil {
+        return err
+    }
+    return writeKeyFile(path, data, 0600)
+}
+
+// Takes encrypted files too, as SchnorrLoadKeypair does.
+func Ed25519LoadKeypair(path string) (Ed25519Keyset, error) {
+    fcontents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return Ed25519Keyset{}, err
+    }
+    fcontents, err = decryptPrivateKey(path, fcontents, SchemeEd25519, ed25519SuiteName, armorEd25519PrivateKey)
+    if err != nil {
+        return Ed25519Keyset{}, err
+    }
+    kv, err := Ed25519DecodeKeypair(fcontents)
+    if err != nil {
+        return kv, fmt.Errorf("%s: %s", path, err.Error())
+    }
+    return kv, nil
+}
+
+func Ed25519SavePubkey(path string, public []byte) error {
+    return Ed25519SavePubkeyAs(path, public, KeyFormatPEM)
+}
+
+func Ed25519SavePubkeyAs(path string, public []byte, format KeyFileFormat) error {
+    data, err := Ed25519EncodePubkey(public, format)
+    if err != nil {
+        return err
+    }
+    return writeKeyFile(path, data, 0644)
+}
+
+func Ed25519LoadPubkey(path string) ([]byte, error) {
+    fcontents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    public, err := Ed25519DecodePubkey(fcontents)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %s", path, err.Error())
+    }
+    return public, nil
+}
@@ -0,0 +1,387 @@
+package crypto
+
+/* JSON and text encodings for the types in this package, so that
+   keys, configurations and whole protocol runs can be written out
+   readably and read back.
+
+   dedis/crypto doesn't give points and secrets any JSON encoding
+   of their own, so the structs here go field by field:
+
+     - a Point or Secret becomes the hex of its MarshalBinary,
+       as SchnorrPublicKey has always been written in group
+       configurations: {"Y":"7d59...ca6b"},
+     - a []Point becomes a list of those,
+     - anything else is left to encoding/json.
+
+   The text encoding is the hex of what abstract.Write sends over
+   the wire, so a message can be copied out of a protocol trace
+   and decoded as it stands. It is only defined for the types that
+   abstract.Write can handle, i.e. without slices.
+
+   Nothing in an encoding says which suite it belongs to. The
+   UnmarshalJSON and UnmarshalText methods use the default suite
+   (see suites.go); DecodeJSON and DecodeText take the suite to use,
+   and DecodeJSON also works for structs that only hold these types,
+   such as the group configuration.
+
+   The types holding secrets (a key pair, the nonce of a cosigning
+   commitment, the blind signer's private parameters and the client's
+   blinding factors) refuse to be encoded, so that handing one to a
+   logger or json.Marshal can't write the secret out. A SchnorrKeyset
+   in particular has no JSON or text encoding like the public types
+   have, though it still decodes them: to store or send a key pair,
+   use SchnorrEncodeKeypair, which writes it raw, as PEM or as JSON
+   (see armor.go), or SchnorrSaveKeypair and
+   SchnorrSaveEncryptedKeypair for a key file. */
+
+import (
+    "bytes"
+    "encoding"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "reflect"
+    "strings"
+    "github.com/dedis/crypto/abstract"
+)
+
+var (
+    encodingPointType   = reflect.TypeOf((*abstract.Point)(nil)).Elem()
+    encodingSecretType  = reflect.TypeOf((*abstract.Secret)(nil)).Elem()
+    encodingPointsType  = reflect.TypeOf([]abstract.Point(nil))
+)
+
+// Reports whether decoding a t takes a suite: it is a point or 
+// secret, or a struct or slice with one somewhere inside.
+func suiteDependent(t reflect.Type) bool {
+    switch t.Kind() {
+    case reflect.Interface:
+        return t == encodingPointType || t == encodingSecretType
+    case reflect.Slice, reflect.Array:
+        return suiteDependent(t.Elem())
+    case reflect.Struct:
+        for i := 0; i < t.NumField(); i++ {
+            if t.Field(i).PkgPath == "" && suiteDependent(t.Field(i).Type) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func marshalBinaryHex(m encoding.BinaryMarshaler) ([]byte, error) {
+    b, err := m.MarshalBinary()
+    if err != nil {
+        return nil, err
+    }
+    return json.Marshal(hex.EncodeToString(b))
+}
+
+func unmarshalBinaryHex(raw []byte, u encoding.BinaryUnmarshaler) error {
+    var encoded string
+    err := json.Unmarshal(raw, &encoded)
+    if err != nil {
+        return err
+    }
+    b, err := hex.DecodeString(encoded)
+    if err != nil {
+        return err
+    }
+    return u.UnmarshalBinary(b)
+}
+
+func marshalFieldJSON(fv reflect.Value) ([]byte, error) {
+    switch fv.Type() {
+    case encodingPointType, encodingSecretType:
+        if fv.IsNil() {
+            return []byte("null"), nil
+        }
+        return marshalBinaryHex(fv.Interface().(encoding.BinaryMarshaler))
+    case encodingPointsType:
+        if fv.IsNil() {
+            return []byte("null"), nil
+        }
+        list := make([]json.RawMessage, fv.Len())
+        for i := range list {
+            item, err := marshalFieldJSON(fv.Index(i))
+            if err != nil {
+                return nil, err
+            }
+            list[i] = item
+        }
+        return json.Marshal(list)
+    }
+    return json.Marshal(fv.Interface())
+}
+
+func unmarshalFieldJSON(suite abstract.Suite, raw []byte, fv reflect.Value) error {
+    switch {
+    case fv.Type() == encodingPointType || fv.Type() == encodingSecretType:
+        if string(raw) == "null" {
+            fv.Set(reflect.Zero(fv.Type()))
+            return nil
+        }
+        var v interface{}
+        if fv.Type() == encodingPointType {
+            v = suite.Point()
+        } else {
+            v = suite.Secret()
+        }
+        err := unmarshalBinaryHex(raw, v.(encoding.BinaryUnmarshaler))
+        if err != nil {
+            return err
+        }
+        fv.Set(reflect.ValueOf(v))
+        return nil
+    case fv.Kind() == reflect.Slice && suiteDependent(fv.Type()):
+        var list []json.RawMessage
+        err := json.Unmarshal(raw, &list)
+        if err != nil {
+            return err
+        }
+        if list == nil {
+            fv.Set(reflect.Zero(fv.Type()))
+            return nil
+        }
+        items := reflect.MakeSlice(fv.Type(), len(list), len(list))
+        for i, item := range list {
+            err = unmarshalFieldJSON(suite, item, items.Index(i))
+            if err != nil {
+                return err
+            }
+        }
+        fv.Set(items)
+        return nil
+    case fv.Kind() == reflect.Struct && suiteDependent(fv.Type()):
+        // the struct's own UnmarshalJSON would use the default suite
+        if string(raw) == "null" {
+            return nil
+        }
+        return unmarshalFieldsJSON(suite, raw, fv.Addr().Interface())
+    }
+    return json.Unmarshal(raw, fv.Addr().Interface())
+}
+
+// Writes the exported fields of the struct v as a JSON object,
+// in the order they are declared.
+func marshalFieldsJSON(v interface{}) ([]byte, error) {
+    rv := reflect.ValueOf(v)
+    rt := rv.Type()
+
+    buf := bytes.Buffer{}
+    buf.WriteByte('{')
+    for i := 0; i < rt.NumField(); i++ {
+        field := rt.Field(i)
+        if field.PkgPath != "" {
+            continue
+        }
+        value, err := marshalFieldJSON(rv.Field(i))
+        if err != nil {
+            return nil, fmt.Errorf("%s.%s: %s", rt.Name(), field.Name, err.Error())
+        }
+        name, _ := json.Marshal(field.Name)
+        if buf.Len() > 1 {
+            buf.WriteByte(',')
+        }
+        buf.Write(name)
+        buf.WriteByte(':')
+        buf.Write(value)
+    }
+    buf.WriteByte('}')
+    return buf.Bytes(), nil
+}
+
+// The reverse of marshalFieldsJSON; v points to the struct. Fields
+// missing from the object are left alone, as encoding/json does.
+func unmarshalFieldsJSON(suite abstract.Suite, b []byte, v interface{}) error {
+    var fields map[string]json.RawMessage
+    err := json.Unmarshal(b, &fields)
+    if err != nil {
+        return err
+    }
+    rv := reflect.ValueOf(v).Elem()
+    rt := rv.Type()
+    for i := 0; i < rt.NumField(); i++ {
+        field := rt.Field(i)
+        raw, ok := fields[field.Name]
+        for name, value := range fields {
+            // encoding/json matches names case insensitively
+            if !ok && strings.EqualFold(name, field.Name) {
+                raw, ok = value, true
+            }
+        }
+        if field.PkgPath != "" || !ok {
+            continue
+        }
+        err = unmarshalFieldJSON(suite, raw, rv.Field(i))
+        if err != nil {
+            return fmt.Errorf("%s.%s: %s", rt.Name(), field.Name, err.Error())
+        }
+    }
+    return nil
+}
+
+// v points to the struct.
+func marshalWireText(v interface{}) ([]byte, error) {
+    buf := bytes.Buffer{}
+    err := abstract.Write(&buf, v, DefaultSuite())
+    if err != nil {
+        return nil, err
+    }
+    return []byte(hex.EncodeToString(buf.Bytes())), nil
+}
+
+func unmarshalWireText(suite abstract.Suite, text []byte, v interface{}) error {
+    b, err := hex.DecodeString(string(text))
+    if err != nil {
+        return err
+    }
+    r := bytes.NewReader(b)
+    err = abstract.Read(r, v, suite)
+    if err != nil {
+        return err
+    }
+    if r.Len() != 0 {
+        return fmt.Errorf("%d bytes left over after decoding", r.Len())
+    }
+    return nil
+}
+
+// Decodes the JSON in data into the struct v points to, with the
+// points and secrets (at any depth) in the given suite.
+func DecodeJSON(suite abstract.Suite, data []byte, v interface{}) error {
+    rv := reflect.ValueOf(v)
+    if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+        return fmt.Errorf("can't decode JSON into a %s", rv.Type())
+    }
+    if !suiteDependent(rv.Elem().Type()) {
+        return json.Unmarshal(data, v)
+    }
+    return unmarshalFieldsJSON(suite, data, v)
+}
+
+// As DecodeJSON for the text encoding.
+func DecodeText(suite abstract.Suite, text []byte, v interface{}) error {
+    return unmarshalWireText(suite, text, v)
+}
+
+// What the types holding secrets give instead of an encoding.
+var errSecretEncoding = errors.New("refusing to encode a secret")
+
+// schnorr.go
+
+func (this SchnorrKeyset) MarshalJSON() ([]byte, error)         { return nil, errSecretEncoding }
+func (this * SchnorrKeyset) UnmarshalJSON(b []byte) error       { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this SchnorrKeyset) MarshalText() ([]byte, error)         { return nil, errSecretEncoding }
+func (this * SchnorrKeyset) UnmarshalText(b []byte) error       { return unmarshalWireText(DefaultSuite(), b, this) }
+
+func (this SchnorrPublicKey) MarshalJSON() ([]byte, error)      { return marshalFieldsJSON(this) }
+func (this * SchnorrPublicKey) UnmarshalJSON(b []byte) error    { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this SchnorrPublicKey) MarshalText() ([]byte, error)      { return marshalWireText(&this) }
+func (this * SchnorrPublicKey) UnmarshalText(b []byte) error    { return unmarshalWireText(DefaultSuite(), b, this) }
+
+func (this SchnorrSignature) MarshalJSON() ([]byte, error)      { return marshalFieldsJSON(this) }
+func (this * SchnorrSignature) UnmarshalJSON(b []byte) error    { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this SchnorrSignature) MarshalText() ([]byte, error)      { return marshalWireText(&this) }
+func (this * SchnorrSignature) UnmarshalText(b []byte) error    { return unmarshalWireText(DefaultSuite(), b, this) }
+
+// batch.go
+
+func (this SchnorrRSignature) MarshalJSON() ([]byte, error)     { return marshalFieldsJSON(this) }
+func (this * SchnorrRSignature) UnmarshalJSON(b []byte) error   { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this SchnorrRSignature) MarshalText() ([]byte, error)     { return marshalWireText(&this) }
+func (this * SchnorrRSignature) UnmarshalText(b []byte) error   { return unmarshalWireText(DefaultSuite(), b, this) }
+
+// multisignatures.go
+
+func (this SchnorrMultiSignaturePublicKey) MarshalJSON() ([]byte, error)    { return marshalFieldsJSON(this) }
+func (this * SchnorrMultiSignaturePublicKey) UnmarshalJSON(b []byte) error  { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this SchnorrMultiSignaturePublicKey) MarshalText() ([]byte, error)    { return marshalWireText(&this) }
+func (this * SchnorrMultiSignaturePublicKey) UnmarshalText(b []byte) error  { return unmarshalWireText(DefaultSuite(), b, this) }
+
+func (this SchnorrMPrivateCommitment) MarshalJSON() ([]byte, error)         { return nil, errSecretEncoding }
+func (this * SchnorrMPrivateCommitment) UnmarshalJSON(b []byte) error       { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this SchnorrMPrivateCommitment) MarshalText() ([]byte, error)         { return nil, errSecretEncoding }
+func (this * SchnorrMPrivateCommitment) UnmarshalText(b []byte) error       { return unmarshalWireText(DefaultSuite(), b, this) }
+
+func (this SchnorrMPublicCommitment) MarshalJSON() ([]byte, error)          { return marshalFieldsJSON(this) }
+func (this * SchnorrMPublicCommitment) UnmarshalJSON(b []byte) error        { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this SchnorrMPublicCommitment) MarshalText() ([]byte, error)          { return marshalWireText(&this) }
+func (this * SchnorrMPublicCommitment) UnmarshalText(b []byte) error        { return unmarshalWireText(DefaultSuite(), b, this) }
+
+func (this SchnorrMAggregateCommmitment) MarshalJSON() ([]byte, error)      { return marshalFieldsJSON(this) }
+func (this * SchnorrMAggregateCommmitment) UnmarshalJSON(b []byte) error    { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this SchnorrMAggregateCommmitment) MarshalText() ([]byte, error)      { return marshalWireText(&this) }
+func (this * SchnorrMAggregateCommmitment) UnmarshalText(b []byte) error    { return unmarshalWireText(DefaultSuite(), b, this) }
+
+func (this SchnorrMResponse) MarshalJSON() ([]byte, error)                  { return marshalFieldsJSON(this) }
+func (this * SchnorrMResponse) UnmarshalJSON(b []byte) error                { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this SchnorrMResponse) MarshalText() ([]byte, error)                  { return marshalWireText(&this) }
+func (this * SchnorrMResponse) UnmarshalText(b []byte) error                { return unmarshalWireText(DefaultSuite(), b, this) }
+
+// the hash is a byte array, which encoding/json would write as
+// 32 numbers; give it in hex like everything else.
+func (this SchnorrMCommitmentHash) MarshalJSON() ([]byte, error) {
+    return json.Marshal(struct{ H string }{hex.EncodeToString(this.H[:])})
+}
+
+func (this * SchnorrMCommitmentHash) UnmarshalJSON(b []byte) error {
+    var encoded struct{ H string }
+    err := json.Unmarshal(b, &encoded)
+    if err != nil {
+        return err
+    }
+    h, err := hex.DecodeString(encoded.H)
+    if err != nil {
+        return err
+    }
+    if len(h) != len(this.H) {
+        return fmt.Errorf("a commitment hash is %d bytes, not %d", len(this.H), len(h))
+    }
+    copy(this.H[:], h)
+    return nil
+}
+
+func (this SchnorrMCommitmentHash) MarshalText() ([]byte, error)    { return marshalWireText(&this) }
+func (this * SchnorrMCommitmentHash) UnmarshalText(b []byte) error  { return unmarshalWireText(DefaultSuite(), b, this) }
+
+// dkg.go. Deals hold slices, so there is no text encoding.
+
+func (this SchnorrDKGDeal) MarshalJSON() ([]byte, error)        { return marshalFieldsJSON(this) }
+func (this * SchnorrDKGDeal) UnmarshalJSON(b []byte) error      { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+
+func (this SchnorrDKGDealing) MarshalJSON() ([]byte, error)     { return marshalFieldsJSON(this) }
+func (this * SchnorrDKGDealing) UnmarshalJSON(b []byte) error   { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+
+// partialBlind.go
+
+func (this WISchnorrBlindPrivateParams) MarshalJSON() ([]byte, error)       { return nil, errSecretEncoding }
+func (this * WISchnorrBlindPrivateParams) UnmarshalJSON(b []byte) error     { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this WISchnorrBlindPrivateParams) MarshalText() ([]byte, error)       { return nil, errSecretEncoding }
+func (this * WISchnorrBlindPrivateParams) UnmarshalText(b []byte) error     { return unmarshalWireText(DefaultSuite(), b, this) }
+
+func (this WISchnorrPublicParams) MarshalJSON() ([]byte, error)             { return marshalFieldsJSON(this) }
+func (this * WISchnorrPublicParams) UnmarshalJSON(b []byte) error           { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this WISchnorrPublicParams) MarshalText() ([]byte, error)             { return marshalWireText(&this) }
+func (this * WISchnorrPublicParams) UnmarshalText(b []byte) error           { return unmarshalWireText(DefaultSuite(), b, this) }
+
+func (this WISchnorrChallengeMessage) MarshalJSON() ([]byte, error)         { return marshalFieldsJSON(this) }
+func (this * WISchnorrChallengeMessage) UnmarshalJSON(b []byte) error       { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this WISchnorrChallengeMessage) MarshalText() ([]byte, error)         { return marshalWireText(&this) }
+func (this * WISchnorrChallengeMessage) UnmarshalText(b []byte) error       { return unmarshalWireText(DefaultSuite(), b, this) }
+
+func (this WISchnorrClientParamersList) MarshalJSON() ([]byte, error)       { return nil, errSecretEncoding }
+func (this * WISchnorrClientParamersList) UnmarshalJSON(b []byte) error     { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this WISchnorrClientParamersList) MarshalText() ([]byte, error)       { return nil, errSecretEncoding }
+func (this * WISchnorrClientParamersList) UnmarshalText(b []byte) error     { return unmarshalWireText(DefaultSuite(), b, this) }
+
+func (this WISchnorrResponseMessage) MarshalJSON() ([]byte, error)          { return marshalFieldsJSON(this) }
+func (this * WISchnorrResponseMessage) UnmarshalJSON(b []byte) error        { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this WISchnorrResponseMessage) MarshalText() ([]byte, error)          { return marshalWireText(&this) }
+func (this * WISchnorrResponseMessage) UnmarshalText(b []byte) error        { return unmarshalWireText(DefaultSuite(), b, this) }
+
+func (this WIBlindSignature) MarshalJSON() ([]byte, error)                  { return marshalFieldsJSON(this) }
+func (this * WIBlindSignature) UnmarshalJSON(b []byte) error                { return unmarshalFieldsJSON(DefaultSuite(), b, this) }
+func (this WIBlindSignature) MarshalText() ([]byte, error)                  { return marshalWireText(&this) }
+func (this * WIBlindSignature) UnmarshalText(b []byte) error                { return unmarshalWireText(DefaultSuite(), b, this) }
@@ -0,0 +1,197 @@
+package crypto
+
+import (
+    "bytes"
+    "encoding"
+    "encoding/hex"
+    "encoding/json"
+    "reflect"
+    "strings"
+    "testing"
+    "github.com/dedis/crypto/abstract"
+)
+
+// Encodes v, decodes it in suite into a fresh value of the same type
+// and checks that encoding that gives the same bytes again. A decoder
+// that dropped a field (or left a nil point) would show up here.
+func checkJSONRoundTrip(t *testing.T, suite abstract.Suite, v interface{}) {
+    name := reflect.TypeOf(v).Name()
+    encoded, err := json.Marshal(v)
+    if err != nil {
+        t.Error(name, "MarshalJSON:", err.Error())
+        return
+    }
+    decoded := reflect.New(reflect.TypeOf(v))
+    err = DecodeJSON(suite, encoded, decoded.Interface())
+    if err != nil {
+        t.Error(name, "DecodeJSON:", err.Error())
+        return
+    }
+    again, err := json.Marshal(decoded.Elem().Interface())
+    if err != nil {
+        t.Error(name, "MarshalJSON after decoding:", err.Error())
+        return
+    }
+    if string(again) != string(encoded) {
+        t.Error(name, "JSON changed on the way round:", string(encoded), string(again))
+    }
+    if strings.Contains(string(encoded), "null") {
+        t.Error(name, "JSON has a missing value:", string(encoded))
+    }
+}
+
+func checkTextRoundTrip(t *testing.T, suite abstract.Suite, v encoding.TextMarshaler) {
+    name := reflect.TypeOf(v).Name()
+    encoded, err := v.MarshalText()
+    if err != nil {
+        t.Error(name, "MarshalText:", err.Error())
+        return
+    }
+    decoded := reflect.New(reflect.TypeOf(v))
+    err = DecodeText(suite, encoded, decoded.Interface())
+    if err != nil {
+        t.Error(name, "DecodeText:", err.Error())
+        return
+    }
+    again, err := decoded.Elem().Interface().(encoding.TextMarshaler).MarshalText()
+    if err != nil {
+        t.Error(name, "MarshalText after decoding:", err.Error())
+        return
+    }
+    if string(again) != string(encoded) {
+        t.Error(name, "text changed on the way round:", string(encoded), string(again))
+    }
+
+    // one byte short, or one too many, must be refused
+    err = DecodeText(suite, encoded[:len(encoded) - 2], decoded.Interface())
+    if err == nil {
+        t.Error(name, "DecodeText accepted truncated text")
+    }
+    err = DecodeText(suite, append(append([]byte{}, encoded...), '0', '0'), decoded.Interface())
+    if err == nil {
+        t.Error(name, "DecodeText accepted trailing text")
+    }
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        pk := SchnorrExtractPubkey(kv)
+        message := []byte("round trip")
+
+        // plain signatures in both forms
+        var sig SchnorrSignature
+        var rsig SchnorrRSignature
+        sigbytes, err := SchnorrSign(suite, kv, message, nil)
+        if err != nil { t.Fatal(err.Error()) }
+        err = abstract.Read(bytes.NewBuffer(sigbytes), &sig, suite)
+        if err != nil { t.Fatal(err.Error()) }
+        rsigbytes, err := SchnorrSignatureToRS(suite, pk, message, sigbytes, nil)
+        if err != nil { t.Fatal(err.Error()) }
+        rsig, err = schnorrDecodeRSignature(suite, rsigbytes)
+        if err != nil { t.Fatal(err.Error()) }
+
+        // a multisignature round
+        commit, err := SchnorrMGenerateCommitment(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        pubCommit := commit.PublicCommitment()
+        aggregate := SchnorrMComputeAggregateCommitment(suite, []SchnorrMPublicCommitment{pubCommit})
+        joint := SchnorrMComputeSharedPublicKey(suite, []SchnorrPublicKey{pk})
+        challenge := SchnorrMComputeCollectiveChallenge(suite, joint.GetSchnorrPK(), message, nil, aggregate)
+        response := SchnorrMUnmarshallCCComputeResponse(suite, kv, suite.Secret().One(), commit, challenge)
+
+        // a DKG deal
+        dkg, err := NewSchnorrDKG(suite, kv, SchnorrDKGSetup{Session: []byte("s"), Threshold: 1, Self: 0, 
+                                                            Members: []SchnorrPublicKey{pk}})
+        if err != nil { t.Fatal(err.Error()) }
+        deal, err := dkg.Deal()
+        if err != nil { t.Fatal(err.Error()) }
+
+        // and a partially blind signature
+        info := []byte("info")
+        signerParams, err := NewPrivateParams(suite, info)
+        if err != nil { t.Fatal(err.Error()) }
+        publicParams := signerParams.DerivePubParams()
+        blindChallenge, clientParams, err := ClientGenerateChallenge(suite, publicParams, pk, info, message, nil)
+        if err != nil { t.Fatal(err.Error()) }
+        blindResponse := ServerGenerateResponse(suite, blindChallenge, signerParams, kv)
+        blindSig, worked := ClientSignBlindly(suite, clientParams, blindResponse, pk, message, nil)
+        if !worked { t.Fatal("blind signing failed") }
+
+        values := []encoding.TextMarshaler{
+            pk, sig, rsig,
+            joint, pubCommit, aggregate, response,
+            SchnorrMHashCommitment(suite, pubCommit),
+            publicParams, blindChallenge, blindResponse, blindSig,
+        }
+        for _, v := range values {
+            checkJSONRoundTrip(t, suite, v)
+            checkTextRoundTrip(t, suite, v)
+        }
+        checkJSONRoundTrip(t, suite, deal)
+        checkJSONRoundTrip(t, suite, deal.For(0))
+
+        // the secrets stay where they are, the blinding factors too
+        // as they would link the signature to the session
+        for _, v := range []encoding.TextMarshaler{kv, commit, signerParams, clientParams} {
+            _, err = json.Marshal(v)
+            if err == nil {
+                t.Error(reflect.TypeOf(v).Name(), "was encoded as JSON")
+            }
+            _, err = v.MarshalText()
+            if err == nil {
+                t.Error(reflect.TypeOf(v).Name(), "was encoded as text")
+            }
+        }
+    })
+}
+
+// The public key keeps the encoding group configurations have 
+// always had, so existing files still load.
+func TestPublicKeyJSONFormat(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        y, _ := kv.Y.MarshalBinary()
+
+        encoded, err := json.Marshal(SchnorrExtractPubkey(kv))
+        if err != nil { t.Fatal(err.Error()) }
+        if string(encoded) != `{"Y":"` + hex.EncodeToString(y) + `"}` {
+            t.Error("Unexpected public key encoding", string(encoded))
+        }
+
+        var pk SchnorrPublicKey
+        err = DecodeJSON(suite, []byte(`{"Y":"not hex"}`), &pk)
+        if err == nil {
+            t.Error("Decoded a public key that is not hex")
+        }
+        err = DecodeJSON(suite, []byte(`{"Y":"` + hex.EncodeToString(y[:len(y) - 1]) + `"}`), &pk)
+        if err == nil {
+            t.Error("Decoded a short public key")
+        }
+    })
+}
+
+// Decoded keys have to be usable, not just re-encodable.
+func TestDecodedKeysSign(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        x, _ := kv.X.MarshalBinary()
+        y, _ := kv.Y.MarshalBinary()
+        encoded := `{"X":"` + hex.EncodeToString(x) + `","Y":"` + hex.EncodeToString(y) + `"}`
+
+        var decoded SchnorrKeyset
+        err = DecodeJSON(suite, []byte(encoded), &decoded)
+        if err != nil { t.Fatal(err.Error()) }
+
+        sig, err := SchnorrSign(suite, decoded, []byte("m"), nil)
+        if err != nil { t.Fatal(err.Error()) }
+        v, err := SchnorrVerify(suite, SchnorrExtractPubkey(kv), []byte("m"), sig)
+        if err != nil { t.Fatal(err.Error()) }
+        if !v {
+            t.Error("Signature with a decoded key does not verify")
+        }
+    })
+}
@@ -0,0 +1,41 @@
+package crypto
+
+/* Short fingerprints for public keys, so that people can tell keys
+   apart and compare them by eye: the first 20 bytes of the SHA3-256
+   of the encoded public point, in lowercase base32 split into groups
+   of four, e.g.
+
+       sha3:5xqv-ogjm-2kdz-3b7n-yq4t-xldk-cafw-mhuz
+
+   A Schnorr key and an RFC 8032 key with the same point have the
+   same fingerprint, which is fine as they are the same key. */
+
+import (
+    "encoding/base32"
+    "strings"
+    "golang.org/x/crypto/sha3"
+)
+
+const fingerprintBytes = 20
+
+func fingerprintOf(encoded []byte) string {
+    h := sha3.Sum256(encoded)
+    b32 := strings.ToLower(base32.StdEncoding.EncodeToString(h[:fingerprintBytes]))
+    var groups []string
+    for len(b32) > 0 {
+        groups = append(groups, b32[:4])
+        b32 = b32[4:]
+    }
+    return "sha3:" + strings.Join(groups, "-")
+}
+
+// The fingerprint of a Schnorr public key.
+func SchnorrFingerprint(pk SchnorrPublicKey) string {
+    encoded, _ := pk.Y.MarshalBinary()
+    return fingerprintOf(encoded)
+}
+
+// The fingerprint of an RFC 8032 public key.
+func Ed25519Fingerprint(public []byte) string {
+    return fingerprintOf(public)
+}
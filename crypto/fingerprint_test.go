@@ -0,0 +1,65 @@
+package crypto
+
+import (
+    "github.com/dedis/crypto/abstract"
+    "regexp"
+    "testing"
+)
+
+func TestFingerprint(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv1, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        kv2, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+
+        f1 := SchnorrFingerprint(SchnorrExtractPubkey(kv1))
+        f2 := SchnorrFingerprint(SchnorrExtractPubkey(kv2))
+        if !regexp.MustCompile(`^sha3:([a-z2-7]{4}-){7}[a-z2-7]{4}$`).MatchString(f1) {
+            t.Error("Unexpected fingerprint format", f1)
+        }
+        if f1 == f2 {
+            t.Error("Two keys have the same fingerprint")
+        }
+        if f1 != SchnorrFingerprint(SchnorrExtractPubkey(kv1)) {
+            t.Error("Fingerprint is not deterministic")
+        }
+
+        // the same point is the same key, whichever scheme it's for
+        y, _ := kv1.Y.MarshalBinary()
+        if Ed25519Fingerprint(y) != f1 {
+            t.Error("Ed25519 fingerprint differs for the same point")
+        }
+    })
+}
+
+func TestGroupConfigFingerprints(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv1, _ := SchnorrGenerateKeypair(suite)
+        kv2, _ := SchnorrGenerateKeypair(suite)
+        pk1, pk2 := SchnorrExtractPubkey(kv1), SchnorrExtractPubkey(kv2)
+        config := SchnorrMGroupConfig{
+            Suite:       suite.String(),
+            JointKey:    SchnorrMComputeSharedPublicKey(suite, []SchnorrPublicKey{pk1, pk2}).GetSchnorrPK(),
+            Aggregation: SchnorrMAggregationWeighted,
+            Members:     []SchnorrMMember{
+                SchnorrMMember{HostName: "localhost", Port: 1111, PKey: pk1, Fingerprint: SchnorrFingerprint(pk1)},
+                SchnorrMMember{HostName: "localhost", Port: 1112, PKey: pk2, Fingerprint: SchnorrFingerprint(pk1)},
+            },
+        }
+        err := SchnorrMSaveGroupConfig("/tmp/gotests.fpgroup", config)
+        if err != nil { t.Fatal(err.Error()) }
+        _, err = SchnorrMLoadGroupConfig("/tmp/gotests.fpgroup")
+        if err == nil {
+            t.Error("Loaded a group whose fingerprint does not match the key")
+        }
+
+        config.Members[1].Fingerprint = SchnorrFingerprint(pk2)
+        err = SchnorrMSaveGroupConfig("/tmp/gotests.fpgroup", config)
+        if err != nil { t.Fatal(err.Error()) }
+        _, err = SchnorrMLoadGroupConfig("/tmp/gotests.fpgroup")
+        if err != nil {
+            t.Error(err.Error())
+        }
+    })
+}
@@ -0,0 +1,231 @@
+package crypto
+
+/* This file holds the group configuration shared by keytool 
+   (which writes it), sigcli2 (which drives the protocol from it)
+   and sigserv2 (which needs the member list to work out its 
+   key coefficient). It used to be copied into each binary. */
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "github.com/dedis/crypto/abstract"
+)
+
+// One member of a multisignature group: where to reach
+// the server, the public key it signs with and the proof
+// that whoever made the key holds its private key.
+// Fingerprint is only there for people reading the file,
+// but if it is given it has to match PKey.
+type SchnorrMMember struct {
+    HostName    string
+    Port        int
+    PKey        SchnorrPublicKey
+    Fingerprint string      `json:",omitempty"`
+    PoP         []byte
+}
+
+// The group configuration file. JointKey is the key signatures
+// verify against; Aggregation records how it was derived 
+// from the members' keys. Threshold is the number of members
+// needed to sign, which is all of them unless Aggregation is
+// SchnorrMAggregationThreshold. In that case member i's key is
+// its verification share g^f(i+1), see threshold.go. Suite names
+// the suite all the keys are in; it is the default suite if empty.
+type SchnorrMGroupConfig struct {
+    Suite       string      `json:",omitempty"`
+    JointKey    SchnorrPublicKey
+    Aggregation SchnorrMKeyAggregation
+    Threshold   int
+    Members     []SchnorrMMember
+}
+
+// Returns the suite the group's keys are in.
+func (this * SchnorrMGroupConfig) GetSuite () (abstract.Suite, error) {
+    return SuiteByName(this.Suite)
+}
+
+// Returns the member public keys in the order they are listed.
+func (this * SchnorrMGroupConfig) PublicKeys () []SchnorrPublicKey {
+    pkeys := make([]SchnorrPublicKey, len(this.Members))
+    for i, member := range this.Members {
+        pkeys[i] = member.PKey
+    }
+    return pkeys
+}
+
+// Returns the position of the member holding pkey, or -1.
+func (this * SchnorrMGroupConfig) MemberIndex (pkey SchnorrPublicKey) int {
+    for i, member := range this.Members {
+        if member.PKey.Y.Equal(pkey.Y) {
+            return i
+        }
+    }
+    return -1
+}
+
+// Checks that signers (positions in Members) is a set of members 
+// allowed to sign together: no repeats, and enough of them.
+func (this * SchnorrMGroupConfig) CheckSigners (signers []int) error {
+    seen := make(map[int]bool)
+    for _, i := range signers {
+        if i < 0 || i >= len(this.Members) {
+            return fmt.Errorf("no member %d in the group", i)
+        }
+        if seen[i] {
+            return fmt.Errorf("member %d appears twice in the signing set", i)
+        }
+        seen[i] = true
+    }
+    if this.Aggregation == SchnorrMAggregationThreshold {
+        if len(signers) < this.Threshold {
+            return fmt.Errorf("%d signers is below the threshold of %d", len(signers), this.Threshold)
+        }
+    } else if len(signers) != len(this.Members) {
+        return errors.New("every member must take part in the signature")
+    }
+    return nil
+}
+
+// Returns the coefficient that member must apply to its key
+// when signing together with signers (which must include it). 
+// For threshold groups this is the Lagrange coefficient for 
+// the signing set, otherwise the weight from SchnorrMKeyCoefficient.
+func (this * SchnorrMGroupConfig) Coefficient (suite abstract.Suite, member int, signers []int) (abstract.Secret, error) {
+    err := this.CheckSigners(signers)
+    if err != nil {
+        return nil, err
+    }
+
+    if this.Aggregation == SchnorrMAggregationThreshold {
+        indices := make([]int, len(signers))
+        for k, i := range signers {
+            indices[k] = i + 1
+        }
+        return SchnorrTLagrangeCoefficient(suite, member + 1, indices)
+    }
+
+    if member < 0 || member >= len(this.Members) {
+        return nil, fmt.Errorf("no member %d in the group", member)
+    }
+    return SchnorrMKeyCoefficient(suite, this.Aggregation, this.PublicKeys(), this.Members[member].PKey)
+}
+
+// Checks every member's proof of possession. Returns the position
+// of the first member whose proof is missing or does not verify, 
+// or -1 if they are all fine.
+func (this * SchnorrMGroupConfig) VerifyProofs (suite abstract.Suite) int {
+    for i, member := range this.Members {
+        v, err := SchnorrVerifyPossession(suite, member.PKey, member.PoP)
+        if err != nil || v == false {
+            return i
+        }
+    }
+    return -1
+}
+
+/* Checks that JointKey really is the members' keys combined as
+   Aggregation says, so that nobody can swap in a key of their own
+   by editing the file. For threshold groups every member's key must
+   lie on the same polynomial as JointKey: JointKey and the first
+   Threshold-1 members' keys fix it, so we interpolate each of the
+   others together with those and must get JointKey every time. */
+func (this * SchnorrMGroupConfig) CheckJointKey (suite abstract.Suite) error {
+    pkeys := this.PublicKeys()
+    if len(pkeys) == 0 {
+        return errors.New("the group has no members")
+    }
+    var joint abstract.Point
+    switch this.Aggregation {
+    case SchnorrMAggregationWeighted:
+        joint = SchnorrMComputeSharedPublicKey(suite, pkeys).P
+    case SchnorrMAggregationLegacySum:
+        joint = SchnorrMComputeSharedPublicKeyLegacySum(suite, pkeys).P
+    case SchnorrMAggregationThreshold:
+        t := this.Threshold
+        for j := t - 1; j < len(pkeys); j++ {
+            keys := append(append([]SchnorrPublicKey{}, pkeys[:t - 1]...), pkeys[j])
+            indices := make([]int, len(keys))
+            for k := range indices {
+                indices[k] = k + 1
+            }
+            indices[len(keys) - 1] = j + 1
+            recovered, err := SchnorrTRecoverPublic(suite, keys, indices)
+            if err != nil {
+                return err
+            }
+            if !recovered.Y.Equal(this.JointKey.Y) {
+                return fmt.Errorf("member %d key is not a share of the joint key", j)
+            }
+        }
+        return nil
+    default:
+        return fmt.Errorf("unknown key aggregation mode %q", this.Aggregation)
+    }
+    if !joint.Equal(this.JointKey.Y) {
+        return fmt.Errorf("the joint key is not the %s of the members' keys", this.Aggregation)
+    }
+    return nil
+}
+
+// Loads a group configuration from disk. The keys are read in the
+// suite the file names, see GetSuite, and the joint key must be the
+// one the members' keys give (CheckJointKey). Aggregation has to be
+// given; files from before it existed need "legacy-sum" adding, so
+// that nobody ends up on the plain sum without knowing it.
+func SchnorrMLoadGroupConfig(path string) (SchnorrMGroupConfig, error) {
+    var config SchnorrMGroupConfig
+
+    fcontents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return config, err
+    }
+    err = json.Unmarshal(fcontents, &struct{ Suite *string }{&config.Suite})
+    if err != nil {
+        return config, err
+    }
+    suite, err := config.GetSuite()
+    if err != nil {
+        return config, fmt.Errorf("%s: %s", path, err.Error())
+    }
+    err = DecodeJSON(suite, fcontents, &config)
+    if err != nil {
+        return config, err
+    }
+    if config.Aggregation == "" {
+        return config, fmt.Errorf("%s: no Aggregation given, set it to %q if the group predates it", path, SchnorrMAggregationLegacySum)
+    }
+    if config.Threshold == 0 {
+        config.Threshold = len(config.Members)
+    }
+    for i, member := range config.Members {
+        if member.Fingerprint != "" && member.Fingerprint != SchnorrFingerprint(member.PKey) {
+            return config, fmt.Errorf("member %d fingerprint does not match its key", i)
+        }
+    }
+    err = schnorrTCheckThreshold(config.Threshold, len(config.Members))
+    if err != nil {
+        return config, err
+    }
+    err = config.CheckJointKey(suite)
+    if err != nil {
+        return config, fmt.Errorf("%s: %s", path, err.Error())
+    }
+    return config, nil
+}
+
+// Writes the group configuration to disk as JSON, indented 
+// so that the member list can be read and checked by eye.
+func SchnorrMSaveGroupConfig(path string, config SchnorrMGroupConfig) error {
+    data, err := json.MarshalIndent(config, "", "    ")
+    if err != nil {
+        return err
+    }
+    f, err := os.OpenFile(path, os.O_CREATE | os.O_TRUNC | os.O_RDWR, 0644)
+    if err != nil { return err }
+    defer f.Close()
+    _, err = f.Write(data)
+    return err
+}
@@ -0,0 +1,118 @@
+package crypto
+
+import (
+    "github.com/dedis/crypto/abstract"
+    "testing"
+)
+
+// Writing a group configuration and reading it back should
+// give us usable keys, not nil points.
+func TestGroupConfigSaveLoad(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv_1, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+        kv_2, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+
+        pks := []SchnorrPublicKey{SchnorrExtractPubkey(kv_1), SchnorrExtractPubkey(kv_2)}
+        joint := SchnorrMComputeSharedPublicKey(suite, pks)
+
+        config := SchnorrMGroupConfig{
+            Suite:       suite.String(),
+            JointKey:    joint.GetSchnorrPK(),
+            Aggregation: SchnorrMAggregationWeighted,
+            Members:     []SchnorrMMember{
+                SchnorrMMember{HostName: "localhost", Port: 1111, PKey: pks[0]},
+                SchnorrMMember{HostName: "localhost", Port: 1112, PKey: pks[1]},
+            },
+        }
+
+        err = SchnorrMSaveGroupConfig("/tmp/gotests.group", config)
+        if err != nil { t.Error("Failed to write file") }
+
+        loaded, err := SchnorrMLoadGroupConfig("/tmp/gotests.group")
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+
+        if loaded.Aggregation != SchnorrMAggregationWeighted {
+            t.Error("Aggregation mode was not preserved")
+        }
+        if !loaded.JointKey.Y.Equal(joint.P) {
+            t.Error("Joint key was not preserved")
+        }
+        for i, member := range loaded.Members {
+            if !member.PKey.Y.Equal(pks[i].Y) {
+                t.Error("Member key was not preserved", i)
+            }
+        }
+    })
+}
+
+// The joint key must be what the members' keys give, whatever the
+// file says.
+func TestGroupConfigJointKey(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv_1, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        kv_2, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        pks := []SchnorrPublicKey{SchnorrExtractPubkey(kv_1), SchnorrExtractPubkey(kv_2)}
+
+        config := SchnorrMGroupConfig{
+            Suite:       suite.String(),
+            JointKey:    SchnorrMComputeSharedPublicKey(suite, pks).GetSchnorrPK(),
+            Aggregation: SchnorrMAggregationWeighted,
+            Members:     []SchnorrMMember{{HostName: "localhost", PKey: pks[0]}, {HostName: "localhost", PKey: pks[1]}},
+        }
+        load := func() error {
+            err := SchnorrMSaveGroupConfig("/tmp/gotests.jointgroup", config)
+            if err != nil { t.Fatal(err.Error()) }
+            _, err = SchnorrMLoadGroupConfig("/tmp/gotests.jointgroup")
+            return err
+        }
+        if err = load(); err != nil {
+            t.Error(err.Error())
+        }
+
+        // a key of our choosing, the sum under the weighted mode and
+        // no mode at all are all refused
+        config.JointKey = pks[0]
+        if load() == nil {
+            t.Error("Loaded a group with a joint key of our choosing")
+        }
+        config.JointKey = SchnorrMComputeSharedPublicKeyLegacySum(suite, pks).GetSchnorrPK()
+        if load() == nil {
+            t.Error("Loaded a weighted group whose joint key is the plain sum")
+        }
+        config.Aggregation = SchnorrMAggregationLegacySum
+        if err = load(); err != nil {
+            t.Error(err.Error())
+        }
+        config.Aggregation = ""
+        if load() == nil {
+            t.Error("Loaded a group without an aggregation mode")
+        }
+
+        // threshold groups: every share must fit the joint key
+        groupKey, keysets, err := SchnorrTDealKeys(suite, 2, 3)
+        if err != nil { t.Fatal(err.Error()) }
+        config = SchnorrMGroupConfig{Suite: suite.String(), JointKey: groupKey,
+                                     Aggregation: SchnorrMAggregationThreshold, Threshold: 2}
+        for _, kv := range keysets {
+            config.Members = append(config.Members, SchnorrMMember{HostName: "localhost", PKey: SchnorrExtractPubkey(kv)})
+        }
+        if err = load(); err != nil {
+            t.Error(err.Error())
+        }
+        config.Members[2].PKey = pks[0]
+        if load() == nil {
+            t.Error("Loaded a threshold group with a share that doesn't fit")
+        }
+        config.Members[2].PKey = SchnorrExtractPubkey(keysets[2])
+        config.JointKey = pks[0]
+        if load() == nil {
+            t.Error("Loaded a threshold group with a joint key of our choosing")
+        }
+    })
+}
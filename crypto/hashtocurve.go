@@ -0,0 +1,269 @@
+package crypto
+
+/* Hashing to the curve of a suite, following RFC 9380. For
+   edwards25519 (suite edwards25519_XMD:SHA-512_ELL2_RO_):
+
+     1. expand the input with expand_message_xmd and SHA-512 and
+        reduce it to two field elements u0, u1,
+     2. send each through the Elligator 2 map to Curve25519 and
+        the birational map to edwards25519,
+     3. add the two points and multiply by the cofactor 8.
+
+   For NIST P-256 (suite P256_XMD:SHA-256_SSWU_RO_) it is the same
+   with SHA-256 and the simplified SWU map, and there is no cofactor
+   to clear.
+
+   Nobody knows the discrete log of the result with respect to g,
+   which is what GenerateZ needs. The field arithmetic is done with
+   math/big because dedis/crypto does not give us access to it;
+   none of the inputs are secret so running time does not matter.
+   The result goes back into the suite through UnmarshalBinary. */
+
+import (
+    "crypto/sha256"
+    "crypto/sha512"
+    "errors"
+    "hash"
+    "math/big"
+    "github.com/dedis/crypto/abstract"
+)
+
+// Arithmetic modulo the prime p of a curve's base field.
+type h2cField struct {
+    p *big.Int
+}
+
+func (this h2cField) mod(x *big.Int) *big.Int {
+    return x.Mod(x, this.p)
+}
+
+func (this h2cField) mul(a, b *big.Int) *big.Int {
+    return this.mod(new(big.Int).Mul(a, b))
+}
+
+func (this h2cField) add(a, b *big.Int) *big.Int {
+    return this.mod(new(big.Int).Add(a, b))
+}
+
+func (this h2cField) neg(a *big.Int) *big.Int {
+    return this.mod(new(big.Int).Neg(a))
+}
+
+// inv0 in the RFC: the inverse, or 0 for 0.
+func (this h2cField) inv(a *big.Int) *big.Int {
+    if a.Sign() == 0 {
+        return new(big.Int)
+    }
+    return new(big.Int).ModInverse(a, this.p)
+}
+
+func (this h2cField) isSquare(a *big.Int) bool {
+    return big.Jacobi(a, this.p) >= 0
+}
+
+// The square root of a (which must be a square) with the given sgn0.
+func (this h2cField) sqrt(a *big.Int, sign uint) *big.Int {
+    y := new(big.Int).ModSqrt(a, this.p)
+    if y.Bit(0) != sign {
+        y = this.neg(y)
+    }
+    return y
+}
+
+// Writes x big endian in exactly size bytes.
+func h2cBytes(x *big.Int, size int) []byte {
+    b := make([]byte, size)
+    xb := x.Bytes()
+    copy(b[size - len(xb):], xb)
+    return b
+}
+
+var (
+    // p = 2^255 - 19
+    h2cEd25519 = h2cField{new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))}
+    // Curve25519 is v^2 = u^3 + J u^2 + u
+    h2cJ = big.NewInt(486662)
+    // Elligator 2 non-square
+    h2cZ = big.NewInt(2)
+    // sqrt(-486664) with sgn0 = 0, for the map to edwards25519
+    h2cC1 = h2cEd25519.sqrt(h2cEd25519.mod(big.NewInt(-486664)), 0)
+
+    // p = 2^256 - 2^224 + 2^192 + 2^96 - 1
+    h2cP256 = h2cField{h2cHexInt("ffffffff00000001000000000000000000000000ffffffffffffffffffffffff")}
+    // P-256 is y^2 = x^3 + A x + B
+    h2cP256A = h2cP256.mod(big.NewInt(-3))
+    h2cP256B = h2cHexInt("5ac635d8aa3a93e7b3ebbd55769886bc651d06b0cc53b0f63bce3c3e27d2604b")
+    // simplified SWU non-square
+    h2cP256Z = h2cP256.mod(big.NewInt(-10))
+)
+
+func h2cHexInt(s string) *big.Int {
+    x, _ := new(big.Int).SetString(s, 16)
+    return x
+}
+
+// length of each field element before reduction, L in the RFC.
+// It is 48 for both curves.
+const h2cFieldBytes = 48
+
+// expand_message_xmd from RFC 9380 section 5.3.1.
+func h2cExpandMessageXMD(newHash func() hash.Hash, msg []byte, dst []byte, length int) ([]byte, error) {
+    h := newHash()
+    bInBytes := h.Size()
+    rInBytes := h.BlockSize()
+
+    ell := (length + bInBytes - 1) / bInBytes
+    if ell > 255 || length > 65535 || len(dst) > 255 {
+        return nil, errors.New("expand_message_xmd: requested length or DST too long")
+    }
+    dstPrime := append(append([]byte{}, dst...), byte(len(dst)))
+
+    h.Write(make([]byte, rInBytes))
+    h.Write(msg)
+    h.Write([]byte{byte(length >> 8), byte(length)})
+    h.Write([]byte{0})
+    h.Write(dstPrime)
+    b0 := h.Sum(nil)
+
+    h.Reset()
+    h.Write(b0)
+    h.Write([]byte{1})
+    h.Write(dstPrime)
+    bi := h.Sum(nil)
+
+    uniform := append([]byte{}, bi...)
+    for i := 2; i <= ell; i++ {
+        mixed := make([]byte, bInBytes)
+        for k := range mixed {
+            mixed[k] = b0[k] ^ bi[k]
+        }
+        h.Reset()
+        h.Write(mixed)
+        h.Write([]byte{byte(i)})
+        h.Write(dstPrime)
+        bi = h.Sum(nil)
+        uniform = append(uniform, bi...)
+    }
+    return uniform[:length], nil
+}
+
+// hash_to_field with count field elements.
+func h2cHashToField(field h2cField, newHash func() hash.Hash,
+                    msg []byte, dst []byte, count int) ([]*big.Int, error) {
+    uniform, err := h2cExpandMessageXMD(newHash, msg, dst, count * h2cFieldBytes)
+    if err != nil {
+        return nil, err
+    }
+    u := make([]*big.Int, count)
+    for i := range u {
+        u[i] = field.mod(new(big.Int).SetBytes(uniform[i * h2cFieldBytes : (i + 1) * h2cFieldBytes]))
+    }
+    return u, nil
+}
+
+// Elligator 2 (RFC 9380 section 6.7.1) onto Curve25519, then the
+// map of appendix D.1 to edwards25519. Returns the point in the
+// usual 32 byte encoding.
+func h2cMapToEdwards25519(u *big.Int) []byte {
+    f := h2cEd25519
+    minusJ := f.neg(h2cJ)
+    g := func(x *big.Int) *big.Int {      // x^3 + J x^2 + x
+        x2 := f.mul(x, x)
+        return f.add(f.add(f.mul(x2, x), f.mul(h2cJ, x2)), x)
+    }
+
+    x1 := f.mul(minusJ, f.inv(f.add(big.NewInt(1), f.mul(h2cZ, f.mul(u, u)))))
+    if x1.Sign() == 0 {
+        x1 = minusJ
+    }
+    gx1 := g(x1)
+    var s, t *big.Int
+    if f.isSquare(gx1) {
+        s, t = x1, f.sqrt(gx1, 1)
+    } else {
+        x2 := f.add(f.neg(x1), minusJ)
+        s, t = x2, f.sqrt(g(x2), 0)
+    }
+
+    // (s, t) on Curve25519 to (x, y) on edwards25519
+    one := big.NewInt(1)
+    sPlusOne := f.add(s, one)
+    x, y := new(big.Int), big.NewInt(1)
+    if t.Sign() != 0 && sPlusOne.Sign() != 0 {
+        x = f.mul(h2cC1, f.mul(s, f.inv(t)))
+        y = f.mul(f.add(s, f.neg(one)), f.inv(sPlusOne))
+    }
+
+    // little endian y with the sign of x in the top bit
+    encoded := h2cBytes(y, 32)
+    for i, j := 0, 31; i < j; i, j = i + 1, j - 1 {
+        encoded[i], encoded[j] = encoded[j], encoded[i]
+    }
+    encoded[31] |= byte(x.Bit(0)) << 7
+    return encoded
+}
+
+// The simplified SWU map (RFC 9380 section 6.6.2) onto P-256,
+// written out straight from the RFC rather than the optimised
+// version. Returns the point uncompressed, 0x04 || x || y.
+func h2cMapToP256(u *big.Int) []byte {
+    f := h2cP256
+    g := func(x *big.Int) *big.Int {      // x^3 + A x + B
+        return f.add(f.add(f.mul(f.mul(x, x), x), f.mul(h2cP256A, x)), h2cP256B)
+    }
+
+    zu2 := f.mul(h2cP256Z, f.mul(u, u))
+    tv1 := f.inv(f.add(f.mul(zu2, zu2), zu2))
+    var x1 *big.Int
+    if tv1.Sign() == 0 {
+        x1 = f.mul(h2cP256B, f.inv(f.mul(h2cP256Z, h2cP256A)))
+    } else {
+        x1 = f.mul(f.mul(f.neg(h2cP256B), f.inv(h2cP256A)), f.add(big.NewInt(1), tv1))
+    }
+    x, y := x1, (*big.Int)(nil)
+    if gx1 := g(x1); f.isSquare(gx1) {
+        y = f.sqrt(gx1, u.Bit(0))
+    } else {
+        x = f.mul(zu2, x1)
+        y = f.sqrt(g(x), u.Bit(0))
+    }
+
+    encoded := []byte{4}
+    encoded = append(encoded, h2cBytes(x, 32)...)
+    return append(encoded, h2cBytes(y, 32)...)
+}
+
+// Hashes msg to a point of the prime order subgroup, using dst
+// as the domain separation tag. The suite must be one of those
+// in suites.go.
+func HashToPoint(suite abstract.Suite, msg []byte, dst []byte) (abstract.Point, error) {
+    var field h2cField
+    var newHash func() hash.Hash
+    var mapToCurve func(*big.Int) []byte
+    switch SuiteName(suite) {
+    case SuiteEd25519:
+        field, newHash, mapToCurve = h2cEd25519, sha512.New, h2cMapToEdwards25519
+    case SuiteP256:
+        field, newHash, mapToCurve = h2cP256, sha256.New, h2cMapToP256
+    default:
+        return nil, errors.New("no hash to curve for suite " + suite.String())
+    }
+
+    u, err := h2cHashToField(field, newHash, msg, dst, 2)
+    if err != nil {
+        return nil, err
+    }
+    q := suite.Point().Null()
+    for _, ui := range u {
+        p := suite.Point()
+        err = p.UnmarshalBinary(mapToCurve(ui))
+        if err != nil {
+            return nil, err
+        }
+        q.Add(q, p)
+    }
+    if SuiteName(suite) == SuiteP256 {
+        return q, nil       // prime order, no cofactor
+    }
+    return schnorrClearCofactor(suite, q), nil
+}
@@ -0,0 +1,127 @@
+package crypto
+
+import (
+    "encoding/hex"
+    "github.com/dedis/crypto/abstract"
+    "github.com/dedis/crypto/edwards/ed25519"
+    "golang.org/x/crypto/sha3"
+    "strconv"
+    "testing"
+)
+
+// RFC 9380 appendix J.5.1, edwards25519_XMD:SHA-512_ELL2_RO_. The
+// expected values are the standard encoding of P (y little endian,
+// sign of x in the top bit).
+func TestHashToPointVectors(t *testing.T) {
+    suite := ed25519.NewAES128SHA256Ed25519(true) 
+    dst := []byte("QUUX-V01-CS02-with-edwards25519_XMD:SHA-512_ELL2_RO_")
+
+    vectors := []struct {
+        msg, encoded string
+    }{
+        // P.x = 3c3da6925a3c3c268448dcabb47ccde5439559d9599646a8260e47b1e4822fc6
+        // P.y = 09a6c8561a0b22bef63124c588ce4c62ea83a3c899763af26d795302e115dc21
+        {"", "21dc15e10253796df23a7699c8a383ea624cce88c52431f6be220b1a56c8a609"},
+        // P.x = 608040b42285cc0d72cbb3985c6b04c935370c7361f4b7fbdb1ae7f8c1a8ecad
+        // P.y = 1a8395b88338f22e435bbd301183e7f20a5f9de643f11882fb237f88268a5531
+        {"abc", "31558a26887f23fb8218f143e69d5f0af2e7831130bd5b432ef23883b895839a"},
+    }
+    for _, vector := range vectors {
+        p, err := HashToPoint(suite, []byte(vector.msg), dst)
+        if err != nil { t.Fatal(err.Error()) }
+        b, _ := p.MarshalBinary()
+        if hex.EncodeToString(b) != vector.encoded {
+            t.Error("Wrong point for message", strconv.Quote(vector.msg))
+        }
+    }
+}
+
+// RFC 9380 appendix J.1.1, P256_XMD:SHA-256_SSWU_RO_. The expected
+// values are 04 || P.x || P.y, as the suite encodes points.
+func TestHashToPointP256Vectors(t *testing.T) {
+    suite, err := SuiteByName(SuiteP256)
+    if err != nil { t.Fatal(err.Error()) }
+    dst := []byte("QUUX-V01-CS02-with-P256_XMD:SHA-256_SSWU_RO_")
+
+    vectors := []struct {
+        msg, encoded string
+    }{
+        {"", "04" + 
+             "2c15230b26dbc6fc9a37051158c95b79656e17a1a920b11394ca91c44247d3e4" +
+             "8a7a74985cc5c776cdfe4b1f19884970453912e9d31528c060be9ab5c43e8415"},
+        {"abc", "04" +
+                "0bb8b87485551aa43ed54f009230450b492fead5f1cc91658775dac4a3388a0f" +
+                "5c41b3d0731a27a7b14bc0bf0ccded2d8751f83493404c84a88e71ffd424212e"},
+    }
+    for _, vector := range vectors {
+        p, err := HashToPoint(suite, []byte(vector.msg), dst)
+        if err != nil { t.Fatal(err.Error()) }
+        b, _ := p.MarshalBinary()
+        if hex.EncodeToString(b) != vector.encoded {
+            t.Error("Wrong point for message", strconv.Quote(vector.msg))
+        }
+    }
+}
+
+func TestGenerateZ(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        info := []byte("some agreed information")
+
+        z1, err := GenerateZ(suite, info)
+        if err != nil { t.Fatal(err.Error()) }
+        z2, err := GenerateZ(suite, info)
+        if err != nil { t.Fatal(err.Error()) }
+        if !z1.Equal(z2) {
+            t.Error("GenerateZ is not deterministic")
+        }
+
+        // L z = 0 only if z is in the prime order subgroup. Secrets are
+        // reduced mod L, so compute it as (L-1) z + z.
+        minusOne := suite.Secret().Neg(suite.Secret().One())
+        lz := suite.Point().Add(suite.Point().Mul(z1, minusOne), z1)
+        if !lz.Equal(suite.Point().Null()) {
+            t.Error("z is not in the prime order subgroup")
+        }
+        if z1.Equal(suite.Point().Null()) {
+            t.Error("z is the identity")
+        }
+
+        // it must no longer be g^H(info), the old construction
+        // whose discrete log anyone could work out.
+        hasher := sha3.New256()
+        hasher.Write(info)
+        old := suite.Point().Mul(nil, suite.Secret().Pick(suite.Cipher(hasher.Sum(nil))))
+        if z1.Equal(old) {
+            t.Error("z still has a known discrete log")
+        }
+    })
+}
+
+// The points we get for different info should look like random
+// points: no repeats, and each bit of the encoding set about half
+// the time.
+func TestGenerateZSpread(t *testing.T) {
+    suite := ed25519.NewAES128SHA256Ed25519(true) 
+
+    const samples = 512
+    seen := make(map[string]bool)
+    ones := make([]int, 256)
+    for i := 0; i < samples; i++ {
+        z, err := GenerateZ(suite, []byte("info " + strconv.Itoa(i)))
+        if err != nil { t.Fatal(err.Error()) }
+        b, _ := z.MarshalBinary()
+        if seen[string(b)] {
+            t.Fatal("Two infos hashed to the same point")
+        }
+        seen[string(b)] = true
+        for bit := range ones {
+            ones[bit] += int(b[bit / 8] >> uint(bit % 8)) & 1
+        }
+    }
+    // allow five standard deviations, about 57 for 512 samples.
+    for bit := range ones {
+        if ones[bit] < samples / 2 - 57 || ones[bit] > samples / 2 + 57 {
+            t.Error("Bit", bit, "set in", ones[bit], "of", samples, "points")
+        }
+    }
+}
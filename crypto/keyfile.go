@@ -0,0 +1,238 @@
+package crypto
+
+/* Encrypted private key files. Without this a .pri file is the
+   raw key with only the file mode protecting it.
+
+   The passphrase goes through scrypt to give a 256 bit key, which
+   seals the raw key file contents with ChaCha20-Poly1305:
+
+       "VNEK"              magic
+       1 byte              format version, 1
+       1 byte              KDF, 1 = scrypt
+       1 byte              log2 N
+       4 bytes             r, big endian
+       4 bytes             p, big endian
+       16 bytes            salt
+       12 bytes            nonce
+       the rest            ciphertext and tag
+
+   The header up to the nonce is authenticated as additional data,
+   so nobody can lower the scrypt cost of a file without it failing
+   to open. The result is armored (see armor.go) as an ENCRYPTED
+   ... PRIVATE KEY; the loaders also take it bare.
+
+   The passphrase comes from $SCHNORR_KEY_PASSPHRASE if it is set,
+   otherwise we ask on the terminal. */
+
+import (
+    "bytes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "golang.org/x/crypto/chacha20poly1305"
+    "golang.org/x/crypto/scrypt"
+    "golang.org/x/crypto/ssh/terminal"
+    "github.com/dedis/crypto/abstract"
+)
+
+// Where the passphrase is taken from before we try prompting.
+const KeyPassphraseEnv = "SCHNORR_KEY_PASSPHRASE"
+
+const (
+    keyFileMagic        = "VNEK"
+    keyFileVersion      = 1
+    keyFileKDFScrypt    = 1
+    keyFileSaltSize     = 16
+    keyFileHeaderSize   = 4 + 1 + 1 + 1 + 4 + 4 + keyFileSaltSize
+)
+
+// scrypt cost parameters, N = 2^LogN.
+type KeyEncryptionParams struct {
+    LogN    uint8
+    R       uint32
+    P       uint32
+}
+
+// What keytool uses: about 32MB and a tenth of a second or so.
+var DefaultKeyEncryptionParams = KeyEncryptionParams{LogN: 15, R: 8, P: 1}
+
+// Refuse anything dearer than this when opening a file, so a
+// doctored header can't make us allocate gigabytes.
+func (this KeyEncryptionParams) check() error {
+    if this.LogN < 10 || this.LogN > 22 || this.R < 1 || this.R > 32 || this.P < 1 || this.P > 16 {
+        return fmt.Errorf("unsupported scrypt parameters N=2^%d r=%d p=%d", this.LogN, this.R, this.P)
+    }
+    return nil
+}
+
+// Reports whether data is an encrypted key file.
+func IsEncryptedKey(data []byte) bool {
+    return bytes.HasPrefix(data, []byte(keyFileMagic))
+}
+
+func keyFileAEAD(passphrase []byte, salt []byte, params KeyEncryptionParams) (cipher.AEAD, error) {
+    key, err := scrypt.Key(passphrase, salt, 1 << params.LogN, int(params.R), int(params.P), chacha20poly1305.KeySize)
+    if err != nil {
+        return nil, err
+    }
+    return chacha20poly1305.New(key)
+}
+
+// Seals the contents of a key file under the passphrase.
+func EncryptKeyData(plaintext []byte, passphrase []byte, params KeyEncryptionParams) ([]byte, error) {
+    err := params.check()
+    if err != nil {
+        return nil, err
+    }
+    if len(passphrase) == 0 {
+        return nil, errors.New("empty passphrase")
+    }
+
+    header := bytes.Buffer{}
+    header.WriteString(keyFileMagic)
+    header.WriteByte(keyFileVersion)
+    header.WriteByte(keyFileKDFScrypt)
+    header.WriteByte(params.LogN)
+    binary.Write(&header, binary.BigEndian, params.R)
+    binary.Write(&header, binary.BigEndian, params.P)
+    salt := make([]byte, keyFileSaltSize)
+    _, err = rand.Read(salt)
+    if err != nil {
+        return nil, err
+    }
+    header.Write(salt)
+
+    aead, err := keyFileAEAD(passphrase, salt, params)
+    if err != nil {
+        return nil, err
+    }
+    nonce := make([]byte, aead.NonceSize())
+    _, err = rand.Read(nonce)
+    if err != nil {
+        return nil, err
+    }
+
+    ad := header.Bytes()
+    out := append(append([]byte{}, ad...), nonce...)
+    return aead.Seal(out, nonce, plaintext, ad), nil
+}
+
+// Opens an encrypted key file. A wrong passphrase and a damaged
+// file look the same.
+func DecryptKeyData(data []byte, passphrase []byte) ([]byte, error) {
+    if !IsEncryptedKey(data) {
+        return nil, errors.New("not an encrypted key file")
+    }
+    if len(data) < keyFileHeaderSize {
+        return nil, errors.New("encrypted key file is truncated")
+    }
+    if data[4] != keyFileVersion {
+        return nil, fmt.Errorf("encrypted key file version %d is not supported", data[4])
+    }
+    if data[5] != keyFileKDFScrypt {
+        return nil, fmt.Errorf("encrypted key file uses unknown KDF %d", data[5])
+    }
+    params := KeyEncryptionParams{
+        LogN: data[6],
+        R:    binary.BigEndian.Uint32(data[7:11]),
+        P:    binary.BigEndian.Uint32(data[11:15]),
+    }
+    err := params.check()
+    if err != nil {
+        return nil, err
+    }
+    salt := data[15:keyFileHeaderSize]
+
+    aead, err := keyFileAEAD(passphrase, salt, params)
+    if err != nil {
+        return nil, err
+    }
+    if len(data) < keyFileHeaderSize + aead.NonceSize() + aead.Overhead() {
+        return nil, errors.New("encrypted key file is truncated")
+    }
+    nonce := data[keyFileHeaderSize : keyFileHeaderSize + aead.NonceSize()]
+    plaintext, err := aead.Open(nil, nonce, data[keyFileHeaderSize + aead.NonceSize():], data[:keyFileHeaderSize])
+    if err != nil {
+        return nil, errors.New("wrong passphrase or damaged key file")
+    }
+    return plaintext, nil
+}
+
+// Gets the passphrase for the key file at path. With confirm set
+// (when creating a key) a prompted passphrase is asked for twice.
+// Programs that have some other way of getting it can replace this.
+var KeyPassphrase = func(path string, confirm bool) ([]byte, error) {
+    if env := os.Getenv(KeyPassphraseEnv); env != "" {
+        return []byte(env), nil
+    }
+    fd := int(os.Stdin.Fd())
+    if !terminal.IsTerminal(fd) {
+        return nil, fmt.Errorf("%s is encrypted: set %s or run from a terminal", path, KeyPassphraseEnv)
+    }
+
+    fmt.Fprintf(os.Stderr, "Passphrase for %s: ", path)
+    passphrase, err := terminal.ReadPassword(fd)
+    fmt.Fprintln(os.Stderr)
+    if err != nil {
+        return nil, err
+    }
+    if confirm {
+        fmt.Fprintf(os.Stderr, "Same again: ")
+        again, err := terminal.ReadPassword(fd)
+        fmt.Fprintln(os.Stderr)
+        if err != nil {
+            return nil, err
+        }
+        if !bytes.Equal(passphrase, again) {
+            return nil, errors.New("passphrases do not match")
+        }
+    }
+    return passphrase, nil
+}
+
+// As SchnorrLoadKeypair, also giving the passphrase if the file was
+// encrypted (nil if it wasn't), so that keys derived from this one
+// can be kept the same way.
+func SchnorrLoadKeypairAndPassphrase(path string, suite abstract.Suite) (SchnorrKeyset, []byte, error) {
+    fcontents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return SchnorrKeyset{}, nil, err
+    }
+    fcontents, passphrase, err := openPrivateKey(path, fcontents, SchemeSchnorr, suite.String(), armorSchnorrPrivateKey)
+    if err != nil {
+        return SchnorrKeyset{}, nil, err
+    }
+    kv, err := SchnorrDecodeKeypair(suite, fcontents)
+    if err != nil {
+        return kv, nil, fmt.Errorf("%s: %s", path, err.Error())
+    }
+    return kv, passphrase, nil
+}
+
+// As SchnorrSaveKeypair, encrypted under the passphrase.
+func SchnorrSaveEncryptedKeypair(path string, suite abstract.Suite, kv SchnorrKeyset, 
+                                 passphrase []byte, params KeyEncryptionParams) error {
+    raw, err := SchnorrEncodeKeypair(suite, kv, KeyFormatRaw)
+    if err != nil {
+        return err
+    }
+    sealed, err := EncryptKeyData(raw, passphrase, params)
+    if err != nil {
+        return err
+    }
+    return writeKeyFile(path, armorEncryptedKey(armorSchnorrPrivateKey, SchemeSchnorr, suite.String(), sealed), 0600)
+}
+
+// As Ed25519SaveKeypair, encrypted under the passphrase.
+func Ed25519SaveEncryptedKeypair(path string, kv Ed25519Keyset, 
+                                 passphrase []byte, params KeyEncryptionParams) error {
+    sealed, err := EncryptKeyData(kv.Seed, passphrase, params)
+    if err != nil {
+        return err
+    }
+    return writeKeyFile(path, armorEncryptedKey(armorEd25519PrivateKey, SchemeEd25519, ed25519SuiteName, sealed), 0600)
+}
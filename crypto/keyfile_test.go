@@ -0,0 +1,114 @@
+package crypto
+
+import (
+    "bytes"
+    "github.com/dedis/crypto/abstract"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// cheap enough for tests
+var testKeyParams = KeyEncryptionParams{LogN: 10, R: 8, P: 1}
+
+func TestEncryptKeyData(t *testing.T) {
+    secret := []byte("thirty two bytes of private key!")
+
+    sealed, err := EncryptKeyData(secret, []byte("correct horse"), testKeyParams)
+    if err != nil { t.Fatal(err.Error()) }
+    if !IsEncryptedKey(sealed) {
+        t.Error("Encrypted key not recognised")
+    }
+    if bytes.Contains(sealed, secret) {
+        t.Error("Key appears in the clear")
+    }
+
+    opened, err := DecryptKeyData(sealed, []byte("correct horse"))
+    if err != nil { t.Fatal(err.Error()) }
+    if !bytes.Equal(opened, secret) {
+        t.Error("Decrypted key differs")
+    }
+
+    _, err = DecryptKeyData(sealed, []byte("battery staple"))
+    if err == nil {
+        t.Error("Opened with the wrong passphrase")
+    }
+
+    // the scrypt parameters are authenticated
+    tampered := append([]byte{}, sealed...)
+    tampered[10] ^= 1
+    _, err = DecryptKeyData(tampered, []byte("correct horse"))
+    if err == nil {
+        t.Error("Opened with changed KDF parameters")
+    }
+
+    _, err = DecryptKeyData(sealed[:len(sealed) - 1], []byte("correct horse"))
+    if err == nil {
+        t.Error("Opened a truncated file")
+    }
+
+    // and must be sane before we run scrypt with them
+    _, err = EncryptKeyData(secret, []byte("x"), KeyEncryptionParams{LogN: 40, R: 8, P: 1})
+    if err == nil {
+        t.Error("Accepted absurd scrypt parameters")
+    }
+    _, err = EncryptKeyData(secret, nil, testKeyParams)
+    if err == nil {
+        t.Error("Accepted an empty passphrase")
+    }
+}
+
+func TestLoadEncryptedKeypairs(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        dir, err := ioutil.TempDir("", "keyfile")
+        if err != nil { t.Fatal(err.Error()) }
+        defer os.RemoveAll(dir)
+
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        ekv, err := Ed25519GenerateKeypair()
+        if err != nil { t.Fatal(err.Error()) }
+
+        spath := filepath.Join(dir, "schnorr.pri")
+        epath := filepath.Join(dir, "ed25519.pri")
+        err = SchnorrSaveEncryptedKeypair(spath, suite, kv, []byte("passphrase"), testKeyParams)
+        if err != nil { t.Fatal(err.Error()) }
+        err = Ed25519SaveEncryptedKeypair(epath, ekv, []byte("passphrase"), testKeyParams)
+        if err != nil { t.Fatal(err.Error()) }
+
+        defer os.Unsetenv(KeyPassphraseEnv)
+
+        os.Setenv(KeyPassphraseEnv, "wrong")
+        _, err = SchnorrLoadKeypair(spath, suite)
+        if err == nil {
+            t.Error("Schnorr key loaded with the wrong passphrase")
+        }
+        _, err = Ed25519LoadKeypair(epath)
+        if err == nil {
+            t.Error("Ed25519 key loaded with the wrong passphrase")
+        }
+
+        os.Setenv(KeyPassphraseEnv, "passphrase")
+        loaded, err := SchnorrLoadKeypair(spath, suite)
+        if err != nil { t.Fatal(err.Error()) }
+        if !loaded.X.Equal(kv.X) || !loaded.Y.Equal(kv.Y) {
+            t.Error("Schnorr key differs after decryption")
+        }
+        eloaded, err := Ed25519LoadKeypair(epath)
+        if err != nil { t.Fatal(err.Error()) }
+        if !bytes.Equal(eloaded.Seed, ekv.Seed) {
+            t.Error("Ed25519 key differs after decryption")
+        }
+
+        // plain files still load as they always did
+        ppath := filepath.Join(dir, "plain.pri")
+        err = SchnorrSaveKeypair(ppath, suite, kv)
+        if err != nil { t.Fatal(err.Error()) }
+        loaded, err = SchnorrLoadKeypair(ppath, suite)
+        if err != nil { t.Fatal(err.Error()) }
+        if !loaded.X.Equal(kv.X) {
+            t.Error("Plain key differs")
+        }
+    })
+}
@@ -8,7 +8,12 @@ package crypto
 */
 
 import (
+    "bytes"
     "crypto/rand"
+    "crypto/subtle"
+    "errors"
+    "fmt"
+    "sort"
     "golang.org/x/crypto/sha3"
     "github.com/dedis/crypto/abstract"
 )
@@ -78,72 +83,340 @@ func SchnorrMGenerateCommitment (suite abstract.Suite) (SchnorrMPrivateCommitmen
     return SchnorrMPrivateCommitment{T: t, V:v}, nil
 }
 
+// In the first round each party only publishes H(T), and T is
+// revealed once every hash is in. Otherwise whoever answers last
+// (or a malicious coordinator) could pick their T as a function 
+// of everyone else's.
+type SchnorrMCommitmentHash struct {
+    H         [32]byte
+}
+
+// Computes H(T) for a public commitment.
+func SchnorrMHashCommitment (suite abstract.Suite,
+                             pubCommit SchnorrMPublicCommitment) SchnorrMCommitmentHash {
+    t_bin, _ := pubCommit.T.MarshalBinary()
+    h := SchnorrMCommitmentHash{}
+    hasher := sha3.New256()
+    hasher.Write(t_bin)
+    copy(h.H[:], hasher.Sum(nil))
+    return h
+}
+
+// Checks a revealed commitment against the hash sent in the first round.
+func SchnorrMVerifyCommitmentHash (suite abstract.Suite,
+                                   pubCommit SchnorrMPublicCommitment,
+                                   hash SchnorrMCommitmentHash) bool {
+    h := SchnorrMHashCommitment(suite, pubCommit)
+    return subtle.ConstantTimeCompare(h.H[:], hash.H[:]) == 1
+}
+
+// Checks every revealed commitment against its hash, position by 
+// position. Returns the index of the first one that does not match,
+// or -1 if they all do.
+func SchnorrMVerifyRevealedCommitments (suite abstract.Suite,
+                                        pcommits []SchnorrMPublicCommitment,
+                                        hashes []SchnorrMCommitmentHash) (int, error) {
+    if len(pcommits) != len(hashes) {
+        return -1, errors.New("number of commitments does not match number of hashes")
+    }
+    for i, pcommit := range pcommits {
+        if !SchnorrMVerifyCommitmentHash(suite, pcommit, hashes[i]) {
+            return i, nil
+        }
+    }
+    return -1, nil
+}
+
+// The reveal and commitment rounds send every signer's hash or
+// commitment to every server. These are written as a 32-bit count 
+// followed by each item in abstract.Write form. The hashes go with
+// the member index of each signer, which tells the servers who is
+// taking part; the commitments that follow are in the same order.
+func SchnorrMEncodeCommitmentHashes (suite abstract.Suite,
+                                     signers []int,
+                                     hashes []SchnorrMCommitmentHash) []byte {
+    buf := bytes.Buffer{}
+    n := uint32(len(hashes))
+    abstract.Write(&buf, &n, suite)
+    for i := range hashes {
+        index := uint32(signers[i])
+        abstract.Write(&buf, &index, suite)
+        abstract.Write(&buf, &hashes[i], suite)
+    }
+    return buf.Bytes()
+}
+
+func SchnorrMDecodeCommitmentHashes (suite abstract.Suite,
+                                     data []byte) ([]int, []SchnorrMCommitmentHash, error) {
+    buf := bytes.NewBuffer(data)
+    var n uint32
+    err := abstract.Read(buf, &n, suite)
+    if err != nil {
+        return nil, nil, err
+    }
+    if int(n) > buf.Len() / 36 {
+        return nil, nil, errors.New("commitment hash count exceeds message length")
+    }
+    signers := make([]int, n)
+    hashes := make([]SchnorrMCommitmentHash, n)
+    for i := range hashes {
+        var index uint32
+        err = abstract.Read(buf, &index, suite)
+        if err != nil {
+            return nil, nil, err
+        }
+        signers[i] = int(index)
+        err = abstract.Read(buf, &hashes[i], suite)
+        if err != nil {
+            return nil, nil, err
+        }
+    }
+    return signers, hashes, nil
+}
+
+func SchnorrMEncodePublicCommitments (suite abstract.Suite,
+                                      pcommits []SchnorrMPublicCommitment) []byte {
+    buf := bytes.Buffer{}
+    n := uint32(len(pcommits))
+    abstract.Write(&buf, &n, suite)
+    for i := range pcommits {
+        abstract.Write(&buf, &pcommits[i], suite)
+    }
+    return buf.Bytes()
+}
+
+func SchnorrMDecodePublicCommitments (suite abstract.Suite,
+                                      data []byte) ([]SchnorrMPublicCommitment, error) {
+    buf := bytes.NewBuffer(data)
+    var n uint32
+    err := abstract.Read(buf, &n, suite)
+    if err != nil {
+        return nil, err
+    }
+    if int(n) > buf.Len() / suite.PointLen() {
+        return nil, errors.New("commitment count exceeds message length")
+    }
+    pcommits := make([]SchnorrMPublicCommitment, n)
+    for i := range pcommits {
+        err = abstract.Read(buf, &pcommits[i], suite)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return pcommits, nil
+}
+
+
+// Selects how the members' public keys are combined into the
+// group key. The weighted mode is what you want; the plain sum
+// is only kept so that groups created before the change still work.
+type SchnorrMKeyAggregation string
+
+const (
+    // Each key Y_i is weighted by a_i = H(L||Y_i) where L is the
+    // sorted list of all member keys (as in MuSig). A member who 
+    // picks their key after seeing everyone else's can no longer 
+    // cancel the others out, since doing so would change L and 
+    // therefore every coefficient.
+    SchnorrMAggregationWeighted  SchnorrMKeyAggregation = "weighted"
+
+    // The original scheme, Y = sum(Y_i). Vulnerable to rogue-key
+    // attacks: do not use for new groups.
+    SchnorrMAggregationLegacySum SchnorrMKeyAggregation = "legacy-sum"
+
+    // The group key is Shamir-shared and any Threshold members can
+    // sign, see threshold.go. The coefficients depend on who signs 
+    // so they come from SchnorrMGroupConfig.Coefficient.
+    SchnorrMAggregationThreshold SchnorrMKeyAggregation = "threshold"
+)
+
+// Encodes the member keys in a canonical (sorted) order and returns
+// the concatenation. This is L in the coefficient computation, 
+// and sorting it means every party agrees on it regardless of the
+// order the keys are listed in the group configuration.
+func schnorrMEncodeKeyList(pkeys []SchnorrPublicKey) []byte {
+    encoded := make([][]byte, len(pkeys))
+    for i, pkey := range pkeys {
+        encoded[i], _ = pkey.Y.MarshalBinary()
+    }
+    sort.Slice(encoded, func(i, j int) bool {
+        return bytes.Compare(encoded[i], encoded[j]) < 0
+    })
+    return bytes.Join(encoded, nil)
+}
+
+// Computes a_i = H(L||Y_i) as a secret given the encoded key list.
+func schnorrMWeight(suite abstract.Suite, keyList []byte, pkey SchnorrPublicKey) abstract.Secret {
+    y_bin, _ := pkey.Y.MarshalBinary()
+    hasher := sha3.New256()
+    hasher.Write(keyList)
+    hasher.Write(y_bin)
+    h := hasher.Sum(nil)
+
+    hct := suite.Cipher(h)
+    return suite.Secret().Pick(hct)
+}
+
+// (Either side) Returns the coefficient that the member holding pkey
+// must apply to their key in the given aggregation mode. For the 
+// legacy sum this is always 1. It is an error to ask for the 
+// coefficient of a key that is not in the group.
+func SchnorrMKeyCoefficient(suite abstract.Suite,
+                            mode SchnorrMKeyAggregation,
+                            pkeys []SchnorrPublicKey,
+                            pkey SchnorrPublicKey) (abstract.Secret, error) {
+
+    found := false
+    for _, member := range pkeys {
+        if member.Y.Equal(pkey.Y) {
+            found = true
+            break
+        }
+    }
+    if !found {
+        return nil, errors.New("public key is not a member of the group")
+    }
+
+    switch mode {
+    case SchnorrMAggregationWeighted:
+        return schnorrMWeight(suite, schnorrMEncodeKeyList(pkeys), pkey), nil
+    case SchnorrMAggregationLegacySum:
+        return suite.Secret().One(), nil
+    case SchnorrMAggregationThreshold:
+        return nil, errors.New("threshold coefficients depend on the signing set")
+    }
+    return nil, fmt.Errorf("unknown key aggregation mode %q", mode)
+}
 
 // (Either side) This function computes the shared public key 
-// by adding public key points over the curve group.
-// Since each public key is already g*k where g is the group 
-// generator this is all we need to do
+// as Y = sum(a_i Y_i) with a_i = H(L||Y_i), see SchnorrMKeyAggregation.
+// Each signer must apply the same a_i to their response, which 
+// they obtain from SchnorrMKeyCoefficient.
 func SchnorrMComputeSharedPublicKey(suite abstract.Suite,
                                     pkeys[] SchnorrPublicKey) SchnorrMultiSignaturePublicKey {
+
+    keyList := schnorrMEncodeKeyList(pkeys)
+    P := suite.Point().Null()
+
+    for _, pkey := range pkeys {
+        a := schnorrMWeight(suite, keyList, pkey)
+        P.Add(P, suite.Point().Mul(pkey.Y, a))
+    }
+    return SchnorrMultiSignaturePublicKey{P}
+}
+
+// (Either side) The legacy version of SchnorrMComputeSharedPublicKey
+// which simply adds the public key points over the curve group.
+// Since each public key is already g*k where g is the group 
+// generator this is all we need to do - but it means anyone 
+// can pick Y_n = g^x' - sum(other keys) and sign alone.
+func SchnorrMComputeSharedPublicKeyLegacySum(suite abstract.Suite,
+                                             pkeys[] SchnorrPublicKey) SchnorrMultiSignaturePublicKey {
     
-    var P abstract.Point = pkeys[0].Y
+    P := suite.Point().Null()
 
-    for _, pkey := range pkeys[1:] {
+    for _, pkey := range pkeys {
         P.Add(P, pkey.Y)
     }
     return SchnorrMultiSignaturePublicKey{P}
 }
 
-// (Client side) The client requiring the n-signature scheme
+// (Either side) The client requiring the n-signature scheme
 // performs the addition of points under the elliptic curve group
-// and returns the aggregate commitment as a raw point 
-// in bytes for transmission to the server
+// and returns the aggregate commitment. The servers do the same
+// from the revealed commitments rather than trusting the client's sum.
 func SchnorrMComputeAggregateCommitment(suite abstract.Suite,
                                     pcommits[] SchnorrMPublicCommitment) SchnorrMAggregateCommmitment {
-    var P abstract.Point = pcommits[0].T
+    // start from the identity rather than pcommits[0].T, since 
+    // Add would otherwise overwrite the first party's commitment.
+    P := suite.Point().Null()
 
-    for _, pcommit := range pcommits[1:] {
+    for _, pcommit := range pcommits {
         P.Add(P, pcommit.T)
     }
     k := SchnorrMAggregateCommmitment{P}
     return k
-
-    /*buf := bytes.Buffer{} 
-    abstract.Write(&buf, &k, suite)
-    return buf.Bytes()*/
 }
 
 
 // (Either side) This function takes the aggregate public commitment 
-// r and returns sha3(m||r) for a given message. 
+// r and returns the challenge hash for a given message. It is the 
+// same transcript SchnorrSign uses, with the group key as the signer's
+// key, so the result checks out with SchnorrVerifyWithContext(jointKey, 
+// msg, sig, context). context may be nil.
 func SchnorrMComputeCollectiveChallenge(suite abstract.Suite,
-                                        msg[] byte,
+                                        jointKey SchnorrPublicKey,
+                                        msg []byte,
+                                        context []byte,
                                         pubCommit SchnorrMAggregateCommmitment) []byte {
 
-    p_bin, _ := pubCommit.P.MarshalBinary()
-    msg_and_p := append(msg, p_bin...) 
-    hasher := sha3.New256()
-    hasher.Write(msg_and_p)
-    h := hasher.Sum(nil)
-    return h
+    return schnorrSignatureHash(jointKey.Y, context, msg, pubCommit.P)
 }
 
 
 // (Server side) This function reads the collective challenge 
 // from the wire, generates and serializes a response 
-// to that as a raw "secret"
+// to that as a raw "secret". The coefficient is this signer's 
+// weight from SchnorrMKeyCoefficient, so r = v - c*a*x.
 func SchnorrMUnmarshallCCComputeResponse (suite abstract.Suite,
                                           kv SchnorrKeyset,
+                                          coefficient abstract.Secret,
                                           privatecommit SchnorrMPrivateCommitment, 
                                           cc []byte) SchnorrMResponse {
     hct := suite.Cipher(cc)
     c := suite.Secret().Pick(hct)
+    ax := suite.Secret().Mul(coefficient, kv.X)
     r := suite.Secret()
-    r.Mul(c, kv.X).Sub(privatecommit.V, r)
+    r.Mul(c, ax).Sub(privatecommit.V, r)
 
     return SchnorrMResponse{r}
 }
 
+// (Client side) Checks a single server's response before it goes 
+// into the signature. Since r_i = v_i - c*a_i*x_i we should have
+// g^r_i * y_i^(c*a_i) == T_i, which lets the client tell exactly which
+// cosigner sent a bad response instead of ending up with a signature
+// that does not verify.
+func SchnorrMVerifyResponse(suite abstract.Suite,
+                            pkey SchnorrPublicKey,
+                            coefficient abstract.Secret,
+                            pubCommit SchnorrMPublicCommitment,
+                            cc []byte,
+                            response SchnorrMResponse) bool {
+    hct := suite.Cipher(cc)
+    c := suite.Secret().Pick(hct)
+    ca := suite.Secret().Mul(c, coefficient)
+
+    gr := suite.Point().Mul(nil, response.R)       // g^r_i
+    yca := suite.Point().Mul(pkey.Y, ca)           // y_i^(c*a_i)
+    t := suite.Point().Add(gr, yca)
+
+    return t.Equal(pubCommit.T)
+}
+
+// (Client side) Runs SchnorrMVerifyResponse over every signer and returns
+// the positions of those whose response does not check out. The slices
+// are all in the same order, one entry per signer, and coefficients
+// are the ones each signer should have used.
+func SchnorrMVerifyResponses(suite abstract.Suite,
+                             pkeys []SchnorrPublicKey,
+                             coefficients []abstract.Secret,
+                             pcommits []SchnorrMPublicCommitment,
+                             cc []byte,
+                             responses []SchnorrMResponse) ([]int, error) {
+    if len(pkeys) != len(pcommits) || len(pkeys) != len(responses) || len(pkeys) != len(coefficients) {
+        return nil, errors.New("number of keys, coefficients, commitments and responses differ")
+    }
+
+    var bad []int
+    for i, pkey := range pkeys {
+        if !SchnorrMVerifyResponse(suite, pkey, coefficients[i], pcommits[i], cc, responses[i]) {
+            bad = append(bad, i)
+        }
+    }
+    return bad, nil
+}
+
 // this function produces a signature given a response from the server.
 func SchnorrMComputeSignatureFromResponses(suite abstract.Suite,
                                            cc []byte,
@@ -151,14 +424,12 @@ func SchnorrMComputeSignatureFromResponses(suite abstract.Suite,
     hct := suite.Cipher(cc)
     c := suite.Secret().Pick(hct)           // H(m||r)
 
-    var r abstract.Secret = responses[0].R
+    // as with the commitments, don't sum into responses[0].R
+    r := suite.Secret().Zero()
 
-    for _, response := range responses[1:] {
+    for _, response := range responses {
         r.Add(r, response.R)
     }
 
     return SchnorrSignature{S: r, E: c}
 }
-
-
-
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"crypto/rand"
 	"github.com/dedis/crypto/abstract"
-    "github.com/dedis/crypto/edwards/ed25519"
     "testing"
 )
 // This test function runs through a 2-party 
@@ -15,73 +14,78 @@ import (
 // before using it in the network stack properly
 // The code file is commented with the relevant steps.
 func TestMultisignature2ServerScenario(t *testing.T) {
-
-	suite := ed25519.NewAES128SHA256Ed25519(true) 
-
-	// Generate ourselves two keypairs, one for each "server"
-	kv_1, err := SchnorrGenerateKeypair(suite)
-	if err != nil { t.Error(err.Error()) }
-	kv_2, err := SchnorrGenerateKeypair(suite)
-	if err != nil { t.Error(err.Error()) }
-
-	// Make a random message and "send" it to the server
-	randomdata := make([]byte, 1024)
-    _, err = rand.Read(randomdata)
-    if err != nil {
-        fmt.Println(err.Error())
-    	return
-    }
-
-    // client side
-    // compute the shared public key given the public keys of each 
-    // participant.
-
-    pks := []SchnorrPublicKey {SchnorrExtractPubkey(kv_1), SchnorrExtractPubkey(kv_2)}
-    sharedpubkey := SchnorrMComputeSharedPublicKey(suite, pks)
-
-    // SERVER
-    // In response to this each server will generate two
-    // arbitrary secrets and respond with a commitment.
-    commit1, err := SchnorrMGenerateCommitment(suite)
-    if err != nil { 
-    	t.Error(err.Error()) 
-    }
-
-    commit2, err := SchnorrMGenerateCommitment(suite)
-    if err != nil { 
-    	t.Error(err.Error()) 
-    }
-
-    // Client side
-    commit_array := []SchnorrMPublicCommitment{SchnorrMPublicCommitment{commit1.PublicCommitment().T}, SchnorrMPublicCommitment{commit2.PublicCommitment().T}}
-    aggregate_commitment := SchnorrMComputeAggregateCommitment(suite, commit_array)
-
-    // client and servers
-    collective_challenge := SchnorrMComputeCollectiveChallenge(suite, randomdata, aggregate_commitment)
-  	
-    // servers respond to client with responses
-  	response_1 := SchnorrMUnmarshallCCComputeResponse(suite, kv_1, commit1, collective_challenge)
-  	response_2 := SchnorrMUnmarshallCCComputeResponse(suite, kv_2, commit2, collective_challenge)
-
-  	// finally, we compute a signature given the responses.
-  	responsearr := []SchnorrMResponse{ response_1, response_2 }
-
-  	sig := SchnorrMComputeSignatureFromResponses(suite, collective_challenge, responsearr)
-
-  	// After all that, we should be able to validate the signature
-  	// against the group public key. First we serialize the signature
-
-  	buf := bytes.Buffer{} 
-    abstract.Write(&buf, &sig, suite)
-    bsig := buf.Bytes()
-
-	verified, err := SchnorrVerify(suite, sharedpubkey.GetSchnorrPK(), randomdata, bsig)
-    if err != nil {
-        t.Error("Error during Verification")
-    }
-    if verified == false {
-        t.Error("Verification of signature failed.")
-    }
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+		// Generate ourselves two keypairs, one for each "server"
+		kv_1, err := SchnorrGenerateKeypair(suite)
+		if err != nil { t.Error(err.Error()) }
+		kv_2, err := SchnorrGenerateKeypair(suite)
+		if err != nil { t.Error(err.Error()) }
+
+		// Make a random message and "send" it to the server
+		randomdata := make([]byte, 1024)
+        _, err = rand.Read(randomdata)
+        if err != nil {
+            fmt.Println(err.Error())
+        	return
+        }
+
+        // client side
+        // compute the shared public key given the public keys of each 
+        // participant.
+
+        pks := []SchnorrPublicKey {SchnorrExtractPubkey(kv_1), SchnorrExtractPubkey(kv_2)}
+        sharedpubkey := SchnorrMComputeSharedPublicKey(suite, pks)
+
+        // SERVER
+        // In response to this each server will generate two
+        // arbitrary secrets and respond with a commitment.
+        commit1, err := SchnorrMGenerateCommitment(suite)
+        if err != nil { 
+        	t.Error(err.Error()) 
+        }
+
+        commit2, err := SchnorrMGenerateCommitment(suite)
+        if err != nil { 
+        	t.Error(err.Error()) 
+        }
+
+        // Client side
+        commit_array := []SchnorrMPublicCommitment{SchnorrMPublicCommitment{commit1.PublicCommitment().T}, SchnorrMPublicCommitment{commit2.PublicCommitment().T}}
+        aggregate_commitment := SchnorrMComputeAggregateCommitment(suite, commit_array)
+
+        // client and servers
+        collective_challenge := SchnorrMComputeCollectiveChallenge(suite, sharedpubkey.GetSchnorrPK(), randomdata, nil, aggregate_commitment)
+
+        // servers respond to client with responses
+        // each server applies their own key coefficient
+        coeff_1, err := SchnorrMKeyCoefficient(suite, SchnorrMAggregationWeighted, pks, pks[0])
+        if err != nil { t.Error(err.Error()) }
+        coeff_2, err := SchnorrMKeyCoefficient(suite, SchnorrMAggregationWeighted, pks, pks[1])
+        if err != nil { t.Error(err.Error()) }
+
+      	response_1 := SchnorrMUnmarshallCCComputeResponse(suite, kv_1, coeff_1, commit1, collective_challenge)
+      	response_2 := SchnorrMUnmarshallCCComputeResponse(suite, kv_2, coeff_2, commit2, collective_challenge)
+
+      	// finally, we compute a signature given the responses.
+      	responsearr := []SchnorrMResponse{ response_1, response_2 }
+
+      	sig := SchnorrMComputeSignatureFromResponses(suite, collective_challenge, responsearr)
+
+      	// After all that, we should be able to validate the signature
+      	// against the group public key. First we serialize the signature
+
+      	buf := bytes.Buffer{} 
+        abstract.Write(&buf, &sig, suite)
+        bsig := buf.Bytes()
+
+		verified, err := SchnorrVerify(suite, sharedpubkey.GetSchnorrPK(), randomdata, bsig)
+        if err != nil {
+            t.Error("Error during Verification")
+        }
+        if verified == false {
+            t.Error("Verification of signature failed.")
+        }
+    })
 }
 
 
@@ -89,101 +93,279 @@ func TestMultisignature2ServerScenario(t *testing.T) {
 
 
 func TestMultisignature5ServerScenario(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        // Generate ourselves two keypairs, one for each "server"
+        kv_1, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+        kv_2, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+        kv_3, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+        kv_4, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+        kv_5, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+
+        // Make a random message and "send" it to the server
+        randomdata := make([]byte, 1024)
+        _, err = rand.Read(randomdata)
+        if err != nil {
+            fmt.Println(err.Error())
+            return
+        }
+
+        // client side
+        // compute the shared public key given the public keys of each 
+        // participant.
+
+        pks := []SchnorrPublicKey {SchnorrExtractPubkey(kv_1), 
+                                   SchnorrExtractPubkey(kv_2),
+                                   SchnorrExtractPubkey(kv_3),
+                                   SchnorrExtractPubkey(kv_4),
+                                   SchnorrExtractPubkey(kv_5)}
+        sharedpubkey := SchnorrMComputeSharedPublicKey(suite, pks)
+
+        // SERVER
+        // In response to this each server will generate two
+        // arbitrary secrets and respond with a commitment.
+        commit1, err := SchnorrMGenerateCommitment(suite)
+        if err != nil { 
+            t.Error(err.Error()) 
+        }
+
+        commit2, err := SchnorrMGenerateCommitment(suite)
+        if err != nil { 
+            t.Error(err.Error()) 
+        }
+        commit3, err := SchnorrMGenerateCommitment(suite)
+        if err != nil { 
+            t.Error(err.Error()) 
+        }
+        commit4, err := SchnorrMGenerateCommitment(suite)
+        if err != nil { 
+            t.Error(err.Error()) 
+        }
+        commit5, err := SchnorrMGenerateCommitment(suite)
+        if err != nil { 
+            t.Error(err.Error()) 
+        }
+
+        // Client side
+        commit_array := []SchnorrMPublicCommitment{SchnorrMPublicCommitment{commit1.PublicCommitment().T}, 
+                                                   SchnorrMPublicCommitment{commit2.PublicCommitment().T},
+                                                   SchnorrMPublicCommitment{commit3.PublicCommitment().T},
+                                                   SchnorrMPublicCommitment{commit4.PublicCommitment().T},
+                                                   SchnorrMPublicCommitment{commit5.PublicCommitment().T}}
+        aggregate_commitment := SchnorrMComputeAggregateCommitment(suite, commit_array)
+
+        // client and servers
+        collective_challenge := SchnorrMComputeCollectiveChallenge(suite, sharedpubkey.GetSchnorrPK(), randomdata, nil, aggregate_commitment)
+
+        // servers respond to client with responses
+        // each server applies their own key coefficient
+        var coeffs []abstract.Secret
+        for _, pk := range pks {
+            coeff, err := SchnorrMKeyCoefficient(suite, SchnorrMAggregationWeighted, pks, pk)
+            if err != nil { t.Error(err.Error()) }
+            coeffs = append(coeffs, coeff)
+        }
+
+        response_1 := SchnorrMUnmarshallCCComputeResponse(suite, kv_1, coeffs[0], commit1, collective_challenge)
+        response_2 := SchnorrMUnmarshallCCComputeResponse(suite, kv_2, coeffs[1], commit2, collective_challenge)
+        response_3 := SchnorrMUnmarshallCCComputeResponse(suite, kv_3, coeffs[2], commit3, collective_challenge)
+        response_4 := SchnorrMUnmarshallCCComputeResponse(suite, kv_4, coeffs[3], commit4, collective_challenge)
+        response_5 := SchnorrMUnmarshallCCComputeResponse(suite, kv_5, coeffs[4], commit5, collective_challenge)
+
+        // finally, we compute a signature given the responses.
+        responsearr := []SchnorrMResponse{ response_1, response_2, response_3, response_4, response_5 }
+
+        sig := SchnorrMComputeSignatureFromResponses(suite, collective_challenge, responsearr)
+
+        // After all that, we should be able to validate the signature
+        // against the group public key. First we serialize the signature
+
+        buf := bytes.Buffer{} 
+        abstract.Write(&buf, &sig, suite)
+        bsig := buf.Bytes()
+
+        verified, err := SchnorrVerify(suite, sharedpubkey.GetSchnorrPK(), randomdata, bsig)
+        if err != nil {
+            t.Error("Error during Verification")
+        }
+        if verified == false {
+            t.Error("Verification of signature failed.")
+        }
+    })
+}
+
+
+// The legacy sum lets the last member to publish a key choose
+// Y_2 = g^x' - Y_1 and then sign alone with x'. Check that this 
+// works against the legacy mode (so the test means something)
+// and fails against the weighted mode.
+func TestMultisignatureRogueKey(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        honest, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+        attacker, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+
+        rogue := SchnorrPublicKey{suite.Point().Sub(attacker.Y, honest.Y)}
+        pks := []SchnorrPublicKey{SchnorrExtractPubkey(honest), rogue}
+
+        message := []byte("Transfer everything to the attacker")
+        sig, err := SchnorrSign(suite, attacker, message, nil)
+        if err != nil { t.Error(err.Error()) }
+
+        legacy := SchnorrMComputeSharedPublicKeyLegacySum(suite, pks)
+        verified, err := SchnorrVerify(suite, legacy.GetSchnorrPK(), message, sig)
+        if err != nil { t.Error(err.Error()) }
+        if verified == false {
+            t.Error("Rogue key attack should succeed against the legacy sum")
+        }
+
+        weighted := SchnorrMComputeSharedPublicKey(suite, pks)
+        verified, err = SchnorrVerify(suite, weighted.GetSchnorrPK(), message, sig)
+        if err != nil { t.Error(err.Error()) }
+        if verified == true {
+            t.Error("Rogue key attack succeeded against the weighted key")
+        }
+    })
+}
+
+// The coefficients must not depend on the order the keys are listed in,
+// and keys outside the group have no coefficient.
+func TestMultisignatureKeyCoefficient(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv_1, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+        kv_2, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+        kv_3, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+
+        pks := []SchnorrPublicKey{SchnorrExtractPubkey(kv_1), SchnorrExtractPubkey(kv_2)}
+        reversed := []SchnorrPublicKey{pks[1], pks[0]}
+
+        a, err := SchnorrMKeyCoefficient(suite, SchnorrMAggregationWeighted, pks, pks[0])
+        if err != nil { t.Error(err.Error()) }
+        b, err := SchnorrMKeyCoefficient(suite, SchnorrMAggregationWeighted, reversed, pks[0])
+        if err != nil { t.Error(err.Error()) }
+        if !a.Equal(b) {
+            t.Error("Key coefficient depends on member order")
+        }
+
+        k1 := SchnorrMComputeSharedPublicKey(suite, pks)
+        k2 := SchnorrMComputeSharedPublicKey(suite, reversed)
+        if !k1.P.Equal(k2.P) {
+            t.Error("Shared public key depends on member order")
+        }
+
+        one, err := SchnorrMKeyCoefficient(suite, SchnorrMAggregationLegacySum, pks, pks[1])
+        if err != nil { t.Error(err.Error()) }
+        if !one.Equal(suite.Secret().One()) {
+            t.Error("Legacy sum coefficient should be one")
+        }
+
+        _, err = SchnorrMKeyCoefficient(suite, SchnorrMAggregationWeighted, pks, SchnorrExtractPubkey(kv_3))
+        if err == nil {
+            t.Error("Got a coefficient for a key outside the group")
+        }
+    })
+}
 
-    suite := ed25519.NewAES128SHA256Ed25519(true) 
-
-    // Generate ourselves two keypairs, one for each "server"
-    kv_1, err := SchnorrGenerateKeypair(suite)
-    if err != nil { t.Error(err.Error()) }
-    kv_2, err := SchnorrGenerateKeypair(suite)
-    if err != nil { t.Error(err.Error()) }
-    kv_3, err := SchnorrGenerateKeypair(suite)
-    if err != nil { t.Error(err.Error()) }
-    kv_4, err := SchnorrGenerateKeypair(suite)
-    if err != nil { t.Error(err.Error()) }
-    kv_5, err := SchnorrGenerateKeypair(suite)
-    if err != nil { t.Error(err.Error()) }
-
-    // Make a random message and "send" it to the server
-    randomdata := make([]byte, 1024)
-    _, err = rand.Read(randomdata)
-    if err != nil {
-        fmt.Println(err.Error())
-        return
-    }
-
-    // client side
-    // compute the shared public key given the public keys of each 
-    // participant.
-
-    pks := []SchnorrPublicKey {SchnorrExtractPubkey(kv_1), 
-                               SchnorrExtractPubkey(kv_2),
-                               SchnorrExtractPubkey(kv_3),
-                               SchnorrExtractPubkey(kv_4),
-                               SchnorrExtractPubkey(kv_5)}
-    sharedpubkey := SchnorrMComputeSharedPublicKey(suite, pks)
-
-    // SERVER
-    // In response to this each server will generate two
-    // arbitrary secrets and respond with a commitment.
-    commit1, err := SchnorrMGenerateCommitment(suite)
-    if err != nil { 
-        t.Error(err.Error()) 
-    }
-
-    commit2, err := SchnorrMGenerateCommitment(suite)
-    if err != nil { 
-        t.Error(err.Error()) 
-    }
-    commit3, err := SchnorrMGenerateCommitment(suite)
-    if err != nil { 
-        t.Error(err.Error()) 
-    }
-    commit4, err := SchnorrMGenerateCommitment(suite)
-    if err != nil { 
-        t.Error(err.Error()) 
-    }
-    commit5, err := SchnorrMGenerateCommitment(suite)
-    if err != nil { 
-        t.Error(err.Error()) 
-    }
-
-    // Client side
-    commit_array := []SchnorrMPublicCommitment{SchnorrMPublicCommitment{commit1.PublicCommitment().T}, 
-                                               SchnorrMPublicCommitment{commit2.PublicCommitment().T},
-                                               SchnorrMPublicCommitment{commit3.PublicCommitment().T},
-                                               SchnorrMPublicCommitment{commit4.PublicCommitment().T},
-                                               SchnorrMPublicCommitment{commit5.PublicCommitment().T}}
-    aggregate_commitment := SchnorrMComputeAggregateCommitment(suite, commit_array)
-
-    // client and servers
-    collective_challenge := SchnorrMComputeCollectiveChallenge(suite, randomdata, aggregate_commitment)
-    
-    // servers respond to client with responses
-    response_1 := SchnorrMUnmarshallCCComputeResponse(suite, kv_1, commit1, collective_challenge)
-    response_2 := SchnorrMUnmarshallCCComputeResponse(suite, kv_2, commit2, collective_challenge)
-    response_3 := SchnorrMUnmarshallCCComputeResponse(suite, kv_3, commit3, collective_challenge)
-    response_4 := SchnorrMUnmarshallCCComputeResponse(suite, kv_4, commit4, collective_challenge)
-    response_5 := SchnorrMUnmarshallCCComputeResponse(suite, kv_5, commit5, collective_challenge)
-
-    // finally, we compute a signature given the responses.
-    responsearr := []SchnorrMResponse{ response_1, response_2, response_3, response_4, response_5 }
-
-    sig := SchnorrMComputeSignatureFromResponses(suite, collective_challenge, responsearr)
-
-    // After all that, we should be able to validate the signature
-    // against the group public key. First we serialize the signature
-
-    buf := bytes.Buffer{} 
-    abstract.Write(&buf, &sig, suite)
-    bsig := buf.Bytes()
-
-    verified, err := SchnorrVerify(suite, sharedpubkey.GetSchnorrPK(), randomdata, bsig)
-    if err != nil {
-        t.Error("Error during Verification")
-    }
-    if verified == false {
-        t.Error("Verification of signature failed.")
-    }
+// Runs the reveal round checks: hashes and commitments survive the
+// wire encoding, honest reveals verify and a changed T is caught.
+func TestMultisignatureCommitReveal(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        var commits []SchnorrMPublicCommitment
+        var hashes []SchnorrMCommitmentHash
+        for i := 0; i < 3; i++ {
+            commit, err := SchnorrMGenerateCommitment(suite)
+            if err != nil { t.Error(err.Error()) }
+            commits = append(commits, commit.PublicCommitment())
+            hashes = append(hashes, SchnorrMHashCommitment(suite, commit.PublicCommitment()))
+        }
+
+        signers := []int{0, 1, 2}
+        decodedSigners, decodedHashes, err := SchnorrMDecodeCommitmentHashes(suite, SchnorrMEncodeCommitmentHashes(suite, signers, hashes))
+        if err != nil { t.Fatal(err.Error()) }
+        for i := range signers {
+            if decodedSigners[i] != signers[i] {
+                t.Error("Signer index was not preserved", i)
+            }
+        }
+        decodedCommits, err := SchnorrMDecodePublicCommitments(suite, SchnorrMEncodePublicCommitments(suite, commits))
+        if err != nil { t.Fatal(err.Error()) }
+
+        bad, err := SchnorrMVerifyRevealedCommitments(suite, decodedCommits, decodedHashes)
+        if err != nil { t.Error(err.Error()) }
+        if bad != -1 {
+            t.Error("Honest commitment reported as not matching its hash", bad)
+        }
+
+        // a party that changes its mind after seeing the others
+        late, err := SchnorrMGenerateCommitment(suite)
+        if err != nil { t.Error(err.Error()) }
+        decodedCommits[1] = late.PublicCommitment()
+
+        bad, err = SchnorrMVerifyRevealedCommitments(suite, decodedCommits, decodedHashes)
+        if err != nil { t.Error(err.Error()) }
+        if bad != 1 {
+            t.Error("Changed commitment was not detected")
+        }
+
+        _, err = SchnorrMVerifyRevealedCommitments(suite, decodedCommits[:2], decodedHashes)
+        if err == nil {
+            t.Error("Missing commitment was not detected")
+        }
+    })
 }
 
+// A cosigner that sends a bad response should be singled out
+// by SchnorrMVerifyResponses while the honest one passes.
+func TestMultisignatureBlame(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv_1, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+        kv_2, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Error(err.Error()) }
+        pks := []SchnorrPublicKey{SchnorrExtractPubkey(kv_1), SchnorrExtractPubkey(kv_2)}
+
+        commit1, err := SchnorrMGenerateCommitment(suite)
+        if err != nil { t.Error(err.Error()) }
+        commit2, err := SchnorrMGenerateCommitment(suite)
+        if err != nil { t.Error(err.Error()) }
+        commits := []SchnorrMPublicCommitment{commit1.PublicCommitment(), commit2.PublicCommitment()}
+
+        message := []byte("This is a test")
+        aggregate := SchnorrMComputeAggregateCommitment(suite, commits)
+        joint := SchnorrMComputeSharedPublicKey(suite, pks)
+        cc := SchnorrMComputeCollectiveChallenge(suite, joint.GetSchnorrPK(), message, nil, aggregate)
+
+        coeff_1, err := SchnorrMKeyCoefficient(suite, SchnorrMAggregationWeighted, pks, pks[0])
+        if err != nil { t.Error(err.Error()) }
+        coeff_2, err := SchnorrMKeyCoefficient(suite, SchnorrMAggregationWeighted, pks, pks[1])
+        if err != nil { t.Error(err.Error()) }
+
+        // the second server "forgets" its coefficient
+        responses := []SchnorrMResponse{
+            SchnorrMUnmarshallCCComputeResponse(suite, kv_1, coeff_1, commit1, cc),
+            SchnorrMUnmarshallCCComputeResponse(suite, kv_2, suite.Secret().One(), commit2, cc),
+        }
+
+        coeffs := []abstract.Secret{coeff_1, coeff_2}
+        bad, err := SchnorrMVerifyResponses(suite, pks, coeffs, commits, cc, responses)
+        if err != nil { t.Error(err.Error()) }
+        if len(bad) != 1 || bad[0] != 1 {
+            t.Error("Expected only member 1 to be blamed, got", bad)
+        }
+
+        responses[1] = SchnorrMUnmarshallCCComputeResponse(suite, kv_2, coeff_2, commit2, cc)
+        bad, err = SchnorrMVerifyResponses(suite, pks, coeffs, commits, cc, responses)
+        if err != nil { t.Error(err.Error()) }
+        if len(bad) != 0 {
+            t.Error("Honest members were blamed", bad)
+        }
+    })
+}
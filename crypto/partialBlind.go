@@ -11,19 +11,16 @@ given we are building a server/client architecture. In this case:
 2. Server/signer are interchangeable. This entity holds private parameters and can sign messages 
    given agreed information witht he user/client by answering challenges.
 
-Of particular concern in my implementation is the Z-generation. The paper describes F(info) as a 
-public key with no known private key; in order to generate a point on the curve we have effectively 
-taken g^{F(info)} which means F(info) could act as a private key in a schnorr signature scheme. 
-I am not sure if this breaks the security of the system. However, given some info we need to 
-deterministically find a curve point, which we cannot do with the underlying library at present.
+The paper describes z = F(info) as a public key with no known private key. Originally we took
+g^{H(info)}, whose discrete log H(info) anyone could compute, which broke witness 
+indistinguishability. GenerateZ now uses a proper hash to curve (see hashtocurve.go).
 
 */
 
 import (
-    //"bytes"
 	"crypto/rand"
+	"errors"
 	"github.com/dedis/crypto/abstract"
-	"golang.org/x/crypto/sha3"
 )
 
 // Represents he prviate parameters 
@@ -39,42 +36,25 @@ type WISchnorrBlindPrivateParams struct {
 	B         abstract.Point
 }
 
+// Domain separation tags for hashing info to z, in the style RFC 9380
+// asks for. They name the hash to curve suite, so there is one per curve.
+var wiSchnorrZDomains = map[string]string{
+    SuiteEd25519:   "vennard.ch-WISchnorr-Z-V01-CS01-with-edwards25519_XMD:SHA-512_ELL2_RO_",
+    SuiteP256:      "vennard.ch-WISchnorr-Z-V01-CS02-with-P256_XMD:SHA-256_SSWU_RO_",
+}
+
 /* GenerateZ takes some random agreed information and creates
    Z the "public-only" key that is witness-independent as per 
-   the paper. We've probably broken that slightly in this implementation
-   because I could not pick a point without generating it 
-   via a Secret, instead of directly via a Point - that is, even as a 
-   32-byte string, we cannot decode on C25519 (and this wouldn't work 
-   for abstract suites anyway). 
-
-   However, it demonstrates the idea.
+   the paper. The info is hashed straight to a point of the prime 
+   order subgroup with RFC 9380's hash to curve, so nobody knows 
+   log_g(z), and both sides get the same z from the same info.
 */
 func GenerateZ (suite abstract.Suite, info[] byte) (abstract.Point, error) {
-	
-	hasher := sha3.New256()
-    hasher.Write(info)
-    zraw := hasher.Sum(nil)
-
-    
-
-    //I think this might be cheating
-    zrawCt := suite.Cipher(zraw)
-
-    zfactor := suite.Secret().Pick(zrawCt)
-    Z := suite.Point()
-    Z.Mul(nil, zfactor)
-
-    // every 32-bit integer exists on Curve25519 only if we have the fullgroup
-    // this should work, but doesn't.
-
-    /*var Z abstract.Point
-    zrawBuf := bytes.NewBuffer(zraw)
-    err := abstract.Read(zrawBuf, &Z, suite);
-    if err != nil {
-        return nil, err
-    }*/
-
-    return Z, nil
+    domain, ok := wiSchnorrZDomains[SuiteName(suite)]
+    if !ok {
+        return nil, errors.New("no hash to curve for suite " + suite.String())
+    }
+    return HashToPoint(suite, info, []byte(domain))
 }
 
 // public parameters that can be transmitted to
@@ -161,8 +141,10 @@ type WISchnorrClientParamersList struct {
 	Z          abstract.Point
 }
 
-/* This function is responsible for producing the challenge message E to send back to the signer. */
-func ClientGenerateChallenge (suite abstract.Suite, publicParameters WISchnorrPublicParams, pk SchnorrPublicKey, info []byte, msg []byte) (WISchnorrChallengeMessage, WISchnorrClientParamersList, error) {
+/* This function is responsible for producing the challenge message E to send back to the signer. 
+   context is an optional string the final signature is bound to (see transcript.go); the 
+   same one has to be given to ClientSignBlindly and VerifyBlindSignature. */
+func ClientGenerateChallenge (suite abstract.Suite, publicParameters WISchnorrPublicParams, pk SchnorrPublicKey, info []byte, msg []byte, context []byte) (WISchnorrChallengeMessage, WISchnorrClientParamersList, error) {
 
 	r1 := make([]byte, 16)
 	r2 := make([]byte, 16)
@@ -200,8 +182,6 @@ func ClientGenerateChallenge (suite abstract.Suite, publicParameters WISchnorrPu
         return WISchnorrChallengeMessage{}, WISchnorrClientParamersList{}, err
     }
 
-    zraw, _ := z.MarshalBinary()
-
     packedParameters := WISchnorrClientParamersList{t1,t2,t3,t4,z}
 
     // There might be a better way to lay out this
@@ -222,21 +202,7 @@ func ClientGenerateChallenge (suite abstract.Suite, publicParameters WISchnorrPu
     beta := suite.Point()
     beta.Mul(z, t4).Add(beta, beta1).Add(beta, publicParameters.B)
 
-    var combinedmsg []byte
-
-    bAlpha, _ := alpha.MarshalBinary()
-    bBeta, _ := beta.MarshalBinary()
-
-    //zraw, _ := publicParameters.Z.MarshalBinary()
-
-    combinedmsg = append(combinedmsg, bAlpha...)
-    combinedmsg = append(combinedmsg, bBeta...)
-    combinedmsg = append(combinedmsg, zraw...)
-    combinedmsg = append(combinedmsg, msg...)
-
-    hasher := sha3.New256()
-    hasher.Write(combinedmsg)
-    ee := hasher.Sum(nil)
+    ee := schnorrBlindHash(pk.Y, context, alpha, beta, z, msg)
     ect := suite.Cipher(ee)
 
     epsilon := suite.Secret().Pick(ect)
@@ -278,7 +244,7 @@ type WIBlindSignature struct {
 
 /* This is the function that given the client's challenge and response from the server is able to 
    compute the final blind signature. This is done on the user side (blindly to the signer). */
-func ClientSignBlindly (suite abstract.Suite, clientParameters WISchnorrClientParamersList, responseMsg WISchnorrResponseMessage, pubKey SchnorrPublicKey, msg []byte) (WIBlindSignature, bool) {
+func ClientSignBlindly (suite abstract.Suite, clientParameters WISchnorrClientParamersList, responseMsg WISchnorrResponseMessage, pubKey SchnorrPublicKey, msg []byte, context []byte) (WIBlindSignature, bool) {
 
 	rho   := suite.Secret()
 	omega := suite.Secret()
@@ -298,7 +264,6 @@ func ClientSignBlindly (suite abstract.Suite, clientParameters WISchnorrClientPa
 	gpyw := suite.Point()
 
 	gpyw.Add(gp, yw)
-	bGpyw, _ := gpyw.MarshalBinary()
 
 	gs := suite.Point()
 	gs.Mul(nil, sigma)
@@ -306,21 +271,8 @@ func ClientSignBlindly (suite abstract.Suite, clientParameters WISchnorrClientPa
 	zd.Mul(clientParameters.Z, delta)
 	gszd := suite.Point()
 	gszd.Add(gs, zd)
-	bGszd, _ := gszd.MarshalBinary()
-
-	bZ, _ := clientParameters.Z.MarshalBinary()
 
-	var combinedmsg []byte
-
-
-    combinedmsg = append(combinedmsg, bGpyw...)
-    combinedmsg = append(combinedmsg, bGszd...)
-    combinedmsg = append(combinedmsg, bZ...)
-    combinedmsg = append(combinedmsg, msg...)
-
-	hasher := sha3.New256()
-    hasher.Write(combinedmsg)
-    bSig := hasher.Sum(nil)
+    bSig := schnorrBlindHash(pubKey.Y, context, gpyw, gszd, clientParameters.Z, msg)
     bSigCt := suite.Cipher(bSig)
 
     sig := suite.Secret().Pick(bSigCt)
@@ -340,7 +292,7 @@ func ClientSignBlindly (suite abstract.Suite, clientParameters WISchnorrClientPa
    by any party given a decoded schnorr signature, a 
    message and valid information. Invalid information will break the protocol
    and produce an invalid message; this is tested for in the unit test code. */
-func VerifyBlindSignature (suite abstract.Suite, pk SchnorrPublicKey, sig WIBlindSignature, info []byte, msg[] byte) (bool, error) {
+func VerifyBlindSignature (suite abstract.Suite, pk SchnorrPublicKey, sig WIBlindSignature, info []byte, msg[] byte, context []byte) (bool, error) {
 
 	z, err := GenerateZ(suite, info)
     if err != nil {
@@ -355,20 +307,7 @@ func VerifyBlindSignature (suite abstract.Suite, pk SchnorrPublicKey, sig WIBlin
 	zd := suite.Point().Mul(z, sig.D)
 	gszd := suite.Point().Add(gs, zd)
 
-	bP1, _ := gpyw.MarshalBinary()
-	bP2, _ := gszd.MarshalBinary()
-	bZ, _ := z.MarshalBinary()
-
-	var combinedmsg []byte
-
-	combinedmsg = append(combinedmsg, bP1...)
-    combinedmsg = append(combinedmsg, bP2...)
-    combinedmsg = append(combinedmsg, bZ...)
-    combinedmsg = append(combinedmsg, msg...)
-
-	hasher := sha3.New256()
-    hasher.Write(combinedmsg)
-    bSig := hasher.Sum(nil)
+    bSig := schnorrBlindHash(pk.Y, context, gpyw, gszd, z, msg)
     bSigCt := suite.Cipher(bSig)
 
 	hsig := suite.Secret().Pick(bSigCt)
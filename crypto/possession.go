@@ -0,0 +1,47 @@
+package crypto
+
+/* Proof of possession for public keys. Whoever generates a key
+   signs a domain-separated encoding of it, and anyone building a
+   group from that key checks the signature first. Someone who
+   made up a key as a function of the other members' keys (the
+   rogue-key attack) does not know its private key, so cannot
+   produce the proof. */
+
+import (
+    "io/ioutil"
+    "os"
+    "github.com/dedis/crypto/abstract"
+)
+
+// Prefix for the message signed in a proof of possession. Nothing
+// else we sign starts with this, so a proof can't be replayed as
+// a signature on anything else or vice versa.
+const schnorrPoPDomain = "schnorr-proof-of-possession-v1:"
+
+func schnorrPoPMessage(pkey SchnorrPublicKey) []byte {
+    y_bin, _ := pkey.Y.MarshalBinary()
+    return append([]byte(schnorrPoPDomain), y_bin...)
+}
+
+// Signs our own public key with its private key.
+func SchnorrProvePossession(suite abstract.Suite, kv SchnorrKeyset) ([]byte, error) {
+    return SchnorrSign(suite, kv, schnorrPoPMessage(SchnorrExtractPubkey(kv)), nil)
+}
+
+// Checks a proof of possession for the given public key.
+func SchnorrVerifyPossession(suite abstract.Suite, pkey SchnorrPublicKey, proof []byte) (bool, error) {
+    return SchnorrVerify(suite, pkey, schnorrPoPMessage(pkey), proof)
+}
+
+// The proof is written as a bare signature next to the .pub file.
+func SchnorrSaveProof(path string, proof []byte) error {
+    f, err := os.OpenFile(path, os.O_CREATE | os.O_TRUNC | os.O_RDWR, 0644)
+    if err != nil { return err }
+    defer f.Close()
+    _, err = f.Write(proof)
+    return err
+}
+
+func SchnorrLoadProof(path string) ([]byte, error) {
+    return ioutil.ReadFile(path)
+}
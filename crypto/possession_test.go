@@ -0,0 +1,49 @@
+package crypto
+
+import (
+    "github.com/dedis/crypto/abstract"
+    "testing"
+)
+
+func TestProofOfPossession(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        other, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        pk := SchnorrExtractPubkey(kv)
+
+        proof, err := SchnorrProvePossession(suite, kv)
+        if err != nil { t.Fatal(err.Error()) }
+
+        err = SchnorrSaveProof("/tmp/gotests.pop", proof)
+        if err != nil { t.Error("Failed to write file") }
+        proof, err = SchnorrLoadProof("/tmp/gotests.pop")
+        if err != nil { t.Error("Failed to read file") }
+
+        v, err := SchnorrVerifyPossession(suite, pk, proof)
+        if err != nil { t.Error(err.Error()) }
+        if v == false {
+            t.Error("Valid proof of possession did not verify")
+        }
+
+        // the rogue key Y' = Y_other - Y has no known private key, and
+        // borrowing the proof for some other key must not work either.
+        rogue := SchnorrPublicKey{suite.Point().Sub(other.Y, kv.Y)}
+        v, err = SchnorrVerifyPossession(suite, rogue, proof)
+        if err != nil { t.Error(err.Error()) }
+        if v == true {
+            t.Error("Proof of possession verified for a different key")
+        }
+
+        // and a plain signature on the key bytes is not a proof
+        y_bin, _ := pk.Y.MarshalBinary()
+        sig, err := SchnorrSign(suite, kv, y_bin, nil)
+        if err != nil { t.Error(err.Error()) }
+        v, err = SchnorrVerifyPossession(suite, pk, sig)
+        if err != nil { t.Error(err.Error()) }
+        if v == true {
+            t.Error("Signature without the domain prefix accepted as a proof")
+        }
+    })
+}
@@ -0,0 +1,198 @@
+package crypto
+
+/* Signing files. Rather than the file itself our Schnorr signatures
+   sign an encoding of its hash and the name of the hash algorithm:
+
+       "vennard.ch/prehash v1" 0x00 algorithm 0x00 digest
+
+   and a message sent to be signed as it is gets a tag of its own:
+
+       "vennard.ch/message v1" 0x00 message
+
+   so that a signature over one algorithm's digest can't be passed
+   off as one over another's, nor can a message crafted to look like
+   the encoding of some digest get a file signed. RFC 8032 signatures
+   have to verify with anybody's Ed25519 code, so they use none of
+   this: they sign a message as it is, and a file's SHA-512 digest as
+   Ed25519ph, which keeps the two apart in the same way (see
+   ed25519.go). DefaultHashAlgorithmFor gives the algorithm for each.
+
+   The detached signature goes in <file>.sig, together with
+   everything needed to check it again apart from the public key. */
+
+import (
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "hash"
+    "io"
+    "io/ioutil"
+    "os"
+    "golang.org/x/crypto/sha3"
+)
+
+const (
+    prehashPrefix   = "vennard.ch/prehash v1"
+    messagePrefix   = "vennard.ch/message v1"
+)
+
+// The hash algorithms a file may be signed with.
+const (
+    HashSHA256      = "sha256"
+    HashSHA512      = "sha512"
+    HashSHA3_256    = "sha3-256"
+    HashSHA3_512    = "sha3-512"
+)
+
+// What sigcli1 uses unless told otherwise.
+const DefaultHashAlgorithm = HashSHA3_256
+
+// The hash algorithm to sign files with under scheme unless told
+// otherwise: Ed25519ph only takes SHA-512.
+func DefaultHashAlgorithmFor(scheme SignatureScheme) string {
+    if scheme == SchemeEd25519 {
+        return Ed25519PrehashAlgorithm
+    }
+    return DefaultHashAlgorithm
+}
+
+// Returns a new hash for the named algorithm.
+func NewPrehash(algorithm string) (hash.Hash, error) {
+    switch algorithm {
+    case HashSHA256:
+        return sha256.New(), nil
+    case HashSHA512:
+        return sha512.New(), nil
+    case HashSHA3_256:
+        return sha3.New256(), nil
+    case HashSHA3_512:
+        return sha3.New512(), nil
+    }
+    return nil, fmt.Errorf("unsupported hash algorithm %s", algorithm)
+}
+
+// Builds the message a Schnorr key signs for a digest. Fails if the
+// algorithm is unknown or the digest is the wrong length for it.
+func PrehashMessage(algorithm string, digest []byte) ([]byte, error) {
+    h, err := NewPrehash(algorithm)
+    if err != nil {
+        return nil, err
+    }
+    if len(digest) != h.Size() {
+        return nil, fmt.Errorf("a %s digest is %d bytes, not %d", algorithm, h.Size(), len(digest))
+    }
+    msg := []byte(prehashPrefix)
+    msg = append(msg, 0)
+    msg = append(msg, []byte(algorithm)...)
+    msg = append(msg, 0)
+    return append(msg, digest...), nil
+}
+
+// Builds the message a Schnorr key signs for a message signed as it
+// is.
+func PlainMessage(message []byte) []byte {
+    msg := []byte(messagePrefix)
+    msg = append(msg, 0)
+    return append(msg, message...)
+}
+
+// Hashes everything read from r.
+func PrehashReader(algorithm string, r io.Reader) ([]byte, error) {
+    h, err := NewPrehash(algorithm)
+    if err != nil {
+        return nil, err
+    }
+    _, err = io.Copy(h, r)
+    if err != nil {
+        return nil, err
+    }
+    return h.Sum(nil), nil
+}
+
+// Hashes a file on disk.
+func PrehashFile(algorithm string, path string) ([]byte, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return PrehashReader(algorithm, f)
+}
+
+// The contents of a <file>.sig file. Suite is the suite of a
+// Schnorr signature, see suites.go; files that don't give one
+// are in the default suite.
+type DetachedSignature struct {
+    Scheme          SignatureScheme
+    Suite           string      `json:",omitempty"`
+    HashAlgorithm   string
+    Digest          []byte
+    Signature       []byte
+}
+
+// The detached signature for path lives here.
+func DetachedSignaturePath(path string) string {
+    return path + ".sig"
+}
+
+// Written armored (see armor.go), with the hash algorithm and 
+// digest as headers:
+//
+//     -----BEGIN DETACHED SIGNATURE-----
+//     Digest: e1385dcb...
+//     Format-Version: 1
+//     Hash-Algorithm: sha3-256
+//     Scheme: schnorr
+//     Suite: Ed25519
+//
+//     8oS8EDGue6Pg...
+//     -----END DETACHED SIGNATURE-----
+func EncodeDetachedSignature(sig DetachedSignature) []byte {
+    return armor(armorDetachedSignature, sig.Scheme, sig.Suite, sig.Signature, map[string]string{
+        "Hash-Algorithm": sig.HashAlgorithm,
+        "Digest":         hex.EncodeToString(sig.Digest),
+    })
+}
+
+// Reads the armored form, or the JSON one .sig files used to have.
+func DecodeDetachedSignature(data []byte) (DetachedSignature, error) {
+    var sig DetachedSignature
+    if !IsArmored(data) {
+        err := json.Unmarshal(data, &sig)
+        return sig, err
+    }
+    block, err := unarmor(data, "", "", armorDetachedSignature)
+    if err != nil {
+        return sig, err
+    }
+    sig.Scheme, err = ParseSignatureScheme(block.Headers["Scheme"])
+    if err != nil {
+        return sig, err
+    }
+    sig.Suite = block.Headers["Suite"]
+    sig.HashAlgorithm = block.Headers["Hash-Algorithm"]
+    sig.Digest, err = hex.DecodeString(block.Headers["Digest"])
+    if err != nil {
+        return sig, err
+    }
+    sig.Signature = block.Bytes
+    return sig, nil
+}
+
+func SaveDetachedSignature(path string, sig DetachedSignature) error {
+    return writeKeyFile(path, EncodeDetachedSignature(sig), 0644)
+}
+
+func LoadDetachedSignature(path string) (DetachedSignature, error) {
+    fcontents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return DetachedSignature{}, err
+    }
+    sig, err := DecodeDetachedSignature(fcontents)
+    if err != nil {
+        return sig, fmt.Errorf("%s: %s", path, err.Error())
+    }
+    return sig, nil
+}
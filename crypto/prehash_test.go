@@ -0,0 +1,77 @@
+package crypto
+
+import (
+    "bytes"
+    "github.com/dedis/crypto/abstract"
+    "io/ioutil"
+    "testing"
+)
+
+func TestPrehashMessage(t *testing.T) {
+    for _, alg := range []string{HashSHA256, HashSHA512, HashSHA3_256, HashSHA3_512} {
+        digest, err := PrehashReader(alg, bytes.NewBufferString("some release tarball"))
+        if err != nil { t.Fatal(err.Error()) }
+        _, err = PrehashMessage(alg, digest)
+        if err != nil {
+            t.Error("Digest rejected for", alg, err.Error())
+        }
+        _, err = PrehashMessage(alg, digest[1:])
+        if err == nil {
+            t.Error("Short digest accepted for", alg)
+        }
+    }
+    _, err := PrehashMessage("md5", make([]byte, 16))
+    if err == nil {
+        t.Error("Unknown hash algorithm accepted")
+    }
+
+    // the same bytes under two algorithms must not sign the same thing
+    digest := make([]byte, 32)
+    m1, _ := PrehashMessage(HashSHA256, digest)
+    m2, _ := PrehashMessage(HashSHA3_256, digest)
+    if bytes.Equal(m1, m2) {
+        t.Error("Algorithm is not part of the signed message")
+    }
+
+    // nor may a plain message that looks like a digest's encoding
+    if bytes.Equal(PlainMessage(m1), m1) || bytes.HasPrefix(PlainMessage(m1), []byte(prehashPrefix)) {
+        t.Error("Plain message signs the same thing as a digest")
+    }
+}
+
+func TestDetachedSignature(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+
+        err = ioutil.WriteFile("/tmp/gotests-artifact", []byte("release 1.0"), 0644)
+        if err != nil { t.Fatal(err.Error()) }
+        digest, err := PrehashFile(DefaultHashAlgorithm, "/tmp/gotests-artifact")
+        if err != nil { t.Fatal(err.Error()) }
+        msg, err := PrehashMessage(DefaultHashAlgorithm, digest)
+        if err != nil { t.Fatal(err.Error()) }
+        signature, err := SchnorrSign(suite, kv, msg, nil)
+        if err != nil { t.Fatal(err.Error()) }
+
+        sigpath := DetachedSignaturePath("/tmp/gotests-artifact")
+        err = SaveDetachedSignature(sigpath, DetachedSignature{
+            Scheme:        SchemeSchnorr,
+            HashAlgorithm: DefaultHashAlgorithm,
+            Digest:        digest,
+            Signature:     signature,
+        })
+        if err != nil { t.Fatal(err.Error()) }
+
+        loaded, err := LoadDetachedSignature(sigpath)
+        if err != nil { t.Fatal(err.Error()) }
+        if loaded.Scheme != SchemeSchnorr || !bytes.Equal(loaded.Digest, digest) {
+            t.Error("Detached signature changed on disk")
+        }
+        msg, err = PrehashMessage(loaded.HashAlgorithm, loaded.Digest)
+        if err != nil { t.Fatal(err.Error()) }
+        v, err := SchnorrVerify(suite, SchnorrExtractPubkey(kv), msg, loaded.Signature)
+        if err != nil || v == false {
+            t.Error("Loaded detached signature does not verify")
+        }
+    })
+}
@@ -8,20 +8,18 @@ package crypto
 // 2) I reworked hashing to use SHA3. 
 // 3) Generating keys grabs a random 128-bit blob from
 //    /dev/urandom instead of  using a fixed example.
-// 4) likewise, k the randomly chosen data in our signature
-//    is also read from /dev/urandom.
+// 4) k, the per-signature nonce, is by default hashed from
+//    the private key, the message and fresh randomness, 
+//    see SchnorrNonceMode.
 
 import (
     "bytes"
     "crypto/rand"
-    "encoding/hex"
     "encoding/json"
+    "errors"
+    "fmt"
     "io/ioutil"
-    "os"
-    "golang.org/x/crypto/sha3"
     "github.com/dedis/crypto/abstract"
-    "github.com/dedis/crypto/edwards/ed25519"
-
 )
 
 
@@ -38,44 +36,8 @@ type SchnorrPublicKey struct {
     Y  abstract.Point
 }
 
-func encodeAsB64(y abstract.Point) string {
-
-    // there must be better ways of doing this. Ideally, 
-    // we should have JSON marshalling for the 
-    // point, secret types in dedis/crypto
-    // but we don't, so, that's a shame.
-    suite := ed25519.NewAES128SHA256Ed25519(true) 
-
-    buf := bytes.Buffer{} 
-    abstract.Write(&buf, &y, suite)
-    return hex.EncodeToString(buf.Bytes())
-}
-
-func decodeFromB64(buf []byte) abstract.Point {
-    suite := ed25519.NewAES128SHA256Ed25519(true) 
-    
-    str := string(buf)
-    decodedBytes, _ := hex.DecodeString(str)
-    P := suite.Point()
-    decoded := bytes.NewBuffer(decodedBytes)
-
-    _ = abstract.Read(decoded, &P, suite);
-    return P
-}
-
-func (k SchnorrPublicKey) UnmarshalJSON(b []byte) (err error) {
-    k.Y = decodeFromB64(b)
-    return nil
-}
-
-func (k SchnorrPublicKey) MarshalJSON() ([]byte, error) {
-    return json.Marshal(struct{
-        Y      string `json:"Y"`
-    }{  
-        Y: encodeAsB64(k.Y),
-    })
-
-}
+// These and the other types in this package can be written as 
+// JSON or text, see encoding.go.
 
 // Represents a Schnorr signature.
 type SchnorrSignature struct {
@@ -92,44 +54,118 @@ func SchnorrExtractPubkey(privkey SchnorrKeyset) SchnorrPublicKey {
     return SchnorrPublicKey{Y: privkey.Y}
 }
 
+// How SchnorrSignWithOptions picks the nonce k. If k is ever 
+// reused or can be guessed, the private key can be worked out
+// from the signature, so this matters.
+type SchnorrNonceMode string
+
+const (
+    // k is read from /dev/urandom and nothing else. Only as 
+    // good as the system RNG.
+    SchnorrNonceRandom          SchnorrNonceMode = "random"
+    // k = H(x, y, context, msg), as in Ed25519 and in the spirit 
+    // of RFC 6979. The same key and message always give the same 
+    // signature, and no RNG is needed at signing time.
+    SchnorrNonceDeterministic   SchnorrNonceMode = "deterministic"
+    // k = H(x, y, z, context, msg) with fresh random z. Still safe
+    // if the RNG is weak, and doesn't give the same signature 
+    // twice. This is what you get if you don't choose.
+    SchnorrNonceHedged          SchnorrNonceMode = "hedged"
+)
+
+// Options for SchnorrSignWithOptions. The zero value is fine.
+type SchnorrSignOptions struct {
+    Nonce   SchnorrNonceMode
+    Format  SchnorrSignatureFormat      // see batch.go
+    Context []byte                      // see transcript.go, nil is fine
+}
+
+// Works out k for signing msg with kv.
+func schnorrPickNonce (suite abstract.Suite, 
+                       kv SchnorrKeyset, 
+                       msg []byte, 
+                       context []byte,
+                       mode SchnorrNonceMode) (abstract.Secret, error) {
+
+    var random []byte
+    switch mode {
+    case SchnorrNonceRandom, SchnorrNonceHedged, "":
+        random = make([]byte, 32)
+        _, err := rand.Read(random)
+        if err != nil {
+            return nil, err
+        }
+    case SchnorrNonceDeterministic:
+    default:
+        return nil, errors.New("unknown nonce mode " + string(mode))
+    }
+
+    if mode == SchnorrNonceRandom {
+        // I have no idea if I just encrypted randomness or not
+        // I'm hoping this just reads the state out.
+        return suite.Secret().Pick(suite.Cipher(random)), nil
+    }
+
+    x_bin, err := kv.X.MarshalBinary()
+    if err != nil {
+        return nil, err
+    }
+    y_bin, err := kv.Y.MarshalBinary()
+    if err != nil {
+        return nil, err
+    }
+    // the context has to go in as well: the same k used under
+    // two contexts gives two challenges, and that gives away x.
+    t := newSchnorrTranscript(schnorrLabelNonce)
+    t.Append("secret", x_bin)
+    t.Append("pubkey", y_bin)
+    t.Append("random", random)          // empty in deterministic mode
+    t.Append("context", context)
+    t.Append("message", msg)
+
+    return suite.Secret().Pick(suite.Cipher(t.Sum())), nil
+}
+
 // Signs a given message and returns the signature.
 // If no signature is possible due to an error
 // returns the error in the second retval.
+// The context (see transcript.go) may be nil; a signature made
+// with one only checks with SchnorrVerifyWithContext and the same
+// context. Uses hedged nonces, see SchnorrSignWithOptions to choose.
 func SchnorrSign (suite abstract.Suite, 
                   kv SchnorrKeyset, 
-                  msg []byte) ([]byte, error) {
+                  msg []byte,
+                  context []byte) ([]byte, error) {
+    return SchnorrSignWithOptions(suite, kv, msg, SchnorrSignOptions{Context: context})
+}
 
-    rsource := make([]byte, 16)
-    _, err := rand.Read(rsource)
+// As SchnorrSign, with control over how the nonce is chosen.
+func SchnorrSignWithOptions (suite abstract.Suite, 
+                             kv SchnorrKeyset, 
+                             msg []byte,
+                             options SchnorrSignOptions) ([]byte, error) {
+
+    k, err := schnorrPickNonce(suite, kv, msg, options.Context, options.Nonce)   // some k
     if err != nil {
         return nil, err
     }
-    // I have no idea if I just encrypted randomness or not
-    // I'm hoping this just reads the state out.
-    rct := suite.Cipher(rsource)
-    
-    k := suite.Secret().Pick(rct)           // some k
     r := suite.Point().Mul(nil, k)          // g^k
-
-    r_bin, _ := r.MarshalBinary()
-    msg_and_r := append(msg, r_bin...) 
-    
-    hasher := sha3.New256()
-    hasher.Write(msg_and_r)
-    h := hasher.Sum(nil)
-
-    // again I'm hoping this just reads the state out
-    // and doesn't  actually perform any ops
-    hct := suite.Cipher(h)
-    e := suite.Secret().Pick(hct)           // H(m||r)
+    e := schnorrChallenge(suite, kv.Y, options.Context, msg, r)  // H(y, ctx, r, m)
 
     s := suite.Secret()
     s.Mul(kv.X, e).Sub(k, s)                // k - xe
 
-    sig := SchnorrSignature{S:s,E:e}
-
     buf := bytes.Buffer{} 
-    abstract.Write(&buf, &sig, suite)
+    switch options.Format {
+    case SchnorrFormatSE, "":
+        sig := SchnorrSignature{S:s,E:e}
+        abstract.Write(&buf, &sig, suite)
+    case SchnorrFormatRS:
+        sig := SchnorrRSignature{R:r,S:s}
+        abstract.Write(&buf, &sig, suite)
+    default:
+        return nil, errors.New("unknown signature format " + string(options.Format))
+    }
     return buf.Bytes(), nil
 }
 
@@ -138,6 +174,14 @@ func SchnorrSign (suite abstract.Suite,
 func SchnorrVerify (suite abstract.Suite, 
                     kp SchnorrPublicKey, 
                     msg []byte, sig []byte) (bool, error) {
+    return SchnorrVerifyWithContext(suite, kp, msg, sig, nil)
+}
+
+// As SchnorrVerify, for signatures made with a context string.
+func SchnorrVerifyWithContext (suite abstract.Suite, 
+                               kp SchnorrPublicKey, 
+                               msg []byte, sig []byte,
+                               context []byte) (bool, error) {
 
     buf := bytes.NewBuffer(sig)
     signature := SchnorrSignature{}
@@ -154,17 +198,7 @@ func SchnorrVerify (suite abstract.Suite,
     ye = suite.Point().Mul(kp.Y, e)     // y^e
     r = suite.Point().Add(gs, ye)       // g^xy^e
         
-    r_bin, _ := r.MarshalBinary()
-    msg_and_r := append(msg, r_bin...) 
-    hasher := sha3.New256()
-    hasher.Write(msg_and_r)
-    h := hasher.Sum(nil)
-    
-    // again I'm hoping this just reads the state out
-    // and doesn't  actually perform any ops
-    lct := suite.Cipher(h)
-
-    ev := suite.Secret().Pick(lct)
+    ev := schnorrChallenge(suite, kp.Y, context, msg, r)
     return ev.Equal(e), nil
 }
 
@@ -195,30 +229,158 @@ func SchnorrGenerateKeypair (suite abstract.Suite) (SchnorrKeyset, error) {
 }
 
 
-// Loads the key pair as a binary blob from a file on disk
-func SchnorrLoadKeypair(path string, suite abstract.Suite) (SchnorrKeyset, error) {
-    
-    fcontents, err := ioutil.ReadFile(path)
+// Reads a raw key, which must use up all of data.
+func schnorrReadRaw(suite abstract.Suite, data []byte, obj interface{}) error {
+    r := bytes.NewReader(data)
+    err := abstract.Read(r, obj, suite)
     if err != nil {
-        return SchnorrKeyset{}, err
+        return err
+    }
+    if r.Len() != 0 {
+        return fmt.Errorf("%d bytes left over, is this the right kind of key?", r.Len())
     }
-    buf := bytes.NewBuffer(fcontents)
+    return nil
+}
+
+// JSON key files are the key's fields as encoding.go writes them,
+// with the name of the suite in front. Files from before the suite
+// was recorded don't have it.
+type schnorrJSONKeypair struct {
+    Suite   string
+    X       abstract.Secret
+    Y       abstract.Point
+}
+
+type schnorrJSONPubkey struct {
+    Suite   string
+    Y       abstract.Point
+}
+
+func schnorrKeyJSON(v interface{}) ([]byte, error) {
+    data, err := marshalFieldsJSON(v)
+    if err != nil {
+        return nil, err
+    }
+    indented := bytes.Buffer{}
+    err = json.Indent(&indented, data, "", "    ")
+    return indented.Bytes(), err
+}
+
+// Encodes the key pair as a raw blob (the format abstract.Write 
+// uses), armored or as JSON, see armor.go.
+func SchnorrEncodeKeypair(suite abstract.Suite, kv SchnorrKeyset, format KeyFileFormat) ([]byte, error) {
+    switch format {
+    case KeyFormatJSON:
+        return schnorrKeyJSON(schnorrJSONKeypair{suite.String(), kv.X, kv.Y})
+    case KeyFormatRaw, KeyFormatPEM:
+        buf := bytes.Buffer{} 
+        err := abstract.Write(&buf, &kv, suite)
+        if err != nil {
+            return nil, err
+        }
+        if format == KeyFormatRaw {
+            return buf.Bytes(), nil
+        }
+        return armor(armorSchnorrPrivateKey, SchemeSchnorr, suite.String(), buf.Bytes(), nil), nil
+    }
+    return nil, fmt.Errorf("unknown key file format %s", format)
+}
+
+// Decodes a key pair in any of the formats.
+func SchnorrDecodeKeypair(suite abstract.Suite, data []byte) (SchnorrKeyset, error) {
     kv := SchnorrKeyset{}
-    err2 := abstract.Read(buf, &kv, suite);
+    if isJSONFile(data) {
+        var encoded schnorrJSONKeypair
+        err := DecodeJSON(suite, data, &encoded)
+        if err == nil {
+            err = checkSuiteName(suite, encoded.Suite)
+        }
+        if err == nil && (encoded.X == nil || encoded.Y == nil) {
+            err = errors.New("key pair is missing X or Y")
+        }
+        return SchnorrKeyset{encoded.X, encoded.Y}, err
+    }
+    if IsArmored(data) {
+        block, err := unarmor(data, SchemeSchnorr, suite.String(), armorSchnorrPrivateKey)
+        if err != nil {
+            return kv, err
+        }
+        data = block.Bytes
+    }
+    err := schnorrReadRaw(suite, data, &kv)
+    return kv, err
+}
+
+// As SchnorrEncodeKeypair for the public key.
+func SchnorrEncodePubkey(suite abstract.Suite, k SchnorrPublicKey, format KeyFileFormat) ([]byte, error) {
+    switch format {
+    case KeyFormatJSON:
+        return schnorrKeyJSON(schnorrJSONPubkey{suite.String(), k.Y})
+    case KeyFormatRaw, KeyFormatPEM:
+        buf := bytes.Buffer{} 
+        err := abstract.Write(&buf, &k, suite)
+        if err != nil {
+            return nil, err
+        }
+        if format == KeyFormatRaw {
+            return buf.Bytes(), nil
+        }
+        return armor(armorSchnorrPublicKey, SchemeSchnorr, suite.String(), buf.Bytes(), nil), nil
+    }
+    return nil, fmt.Errorf("unknown key file format %s", format)
+}
 
-    return kv, err2
+func SchnorrDecodePubkey(suite abstract.Suite, data []byte) (SchnorrPublicKey, error) {
+    k := SchnorrPublicKey{}
+    if isJSONFile(data) {
+        // don't just pick Y out of a key pair
+        var fields map[string]json.RawMessage
+        err := json.Unmarshal(data, &fields)
+        if err != nil {
+            return k, err
+        }
+        if _, private := fields["X"]; private {
+            return k, errors.New("this is a key pair, not a public key")
+        }
+        var encoded schnorrJSONPubkey
+        err = DecodeJSON(suite, data, &encoded)
+        if err == nil {
+            err = checkSuiteName(suite, encoded.Suite)
+        }
+        if err == nil && encoded.Y == nil {
+            err = errors.New("public key is missing Y")
+        }
+        return SchnorrPublicKey{encoded.Y}, err
+    }
+    if IsArmored(data) {
+        block, err := unarmor(data, SchemeSchnorr, suite.String(), armorSchnorrPublicKey)
+        if err != nil {
+            return k, err
+        }
+        data = block.Bytes
+    }
+    err := schnorrReadRaw(suite, data, &k)
+    return k, err
 }
 
-// Saves the keypair as a binary blob on disk. The file format
-// matches abstract.Write(...) so whatever that uses, we're using here.
+// Loads the key pair from a file on disk, in any format. If the
+// file is encrypted we need the passphrase, see keyfile.go.
+func SchnorrLoadKeypair(path string, suite abstract.Suite) (SchnorrKeyset, error) {
+    kv, _, err := SchnorrLoadKeypairAndPassphrase(path, suite)
+    return kv, err
+}
+
+// Saves the keypair armored.
 func SchnorrSaveKeypair(path string,  suite abstract.Suite, kv SchnorrKeyset) error {
-    buf := bytes.Buffer{} 
-    abstract.Write(&buf, &kv, suite)
-    f, err := os.OpenFile(path, os.O_CREATE | os.O_RDWR, 0600)
-    if err != nil { return err }
-    defer f.Close()
-    _, e2 := f.Write(buf.Bytes())
-    return e2
+    return SchnorrSaveKeypairAs(path, suite, kv, KeyFormatPEM)
+}
+
+func SchnorrSaveKeypairAs(path string,  suite abstract.Suite, kv SchnorrKeyset, format KeyFileFormat) error {
+    data, err := SchnorrEncodeKeypair(suite, kv, format)
+    if err != nil {
+        return err
+    }
+    return writeKeyFile(path, data, 0600)
 }
 
 // Loads only the public key from disk.
@@ -228,22 +390,24 @@ func SchnorrLoadPubkey(path string, suite abstract.Suite) (SchnorrPublicKey, err
     if err != nil {
         return SchnorrPublicKey{}, err
     }
-    buf := bytes.NewBuffer(fcontents)
-    kv := SchnorrPublicKey{}
-    err2 := abstract.Read(buf, &kv, suite);
-
-    return kv, err2
+    k, err := SchnorrDecodePubkey(suite, fcontents)
+    if err != nil {
+        return k, fmt.Errorf("%s: %s", path, err.Error())
+    }
+    return k, nil
 }
 
-// Saves only the public key to disk.
+// Saves only the public key to disk, armored.
 func SchnorrSavePubkey(path string, suite abstract.Suite, k SchnorrPublicKey) error {
-    buf := bytes.Buffer{} 
-    abstract.Write(&buf, &k, suite)
-    f, err := os.OpenFile(path, os.O_CREATE | os.O_RDWR, 0644)
-    if err != nil { return err }
-    defer f.Close()
-    _, e2 := f.Write(buf.Bytes())
-    return e2
+    return SchnorrSavePubkeyAs(path, suite, k, KeyFormatPEM)
+}
+
+func SchnorrSavePubkeyAs(path string, suite abstract.Suite, k SchnorrPublicKey, format KeyFileFormat) error {
+    data, err := SchnorrEncodePubkey(suite, k, format)
+    if err != nil {
+        return err
+    }
+    return writeKeyFile(path, data, 0644)
 }
 
 
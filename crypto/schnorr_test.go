@@ -6,49 +6,199 @@ package crypto
 // keyset generation, signing and verification only
 
 import (
-    "github.com/dedis/crypto/edwards/ed25519"
+    "bytes"
+    "encoding/hex"
+    "github.com/dedis/crypto/abstract"
     "testing"
 )
 
 func TestSchnorrGenerateKeyset(t *testing.T) {
-    
-    // again, if I had more time and was publishing 
-    // this, I'd be calculating some known values 
-    // outside this code
-    // and checking generation produced what it 
-    // should.
-    suite := ed25519.NewAES128SHA256Ed25519(true) 
-    _, err := SchnorrGenerateKeypair(suite)
-    if err != nil {
-        t.Error("Keypair generation failed")
-    }
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        // again, if I had more time and was publishing 
+        // this, I'd be calculating some known values 
+        // outside this code
+        // and checking generation produced what it 
+        // should.
+        _, err := SchnorrGenerateKeypair(suite)
+        if err != nil {
+            t.Error("Keypair generation failed")
+        }
+    })
 }
 
 func TestSchnorrSignature(t *testing.T) {
-    
-    suite := ed25519.NewAES128SHA256Ed25519(true) 
-    
-    // for good measure, do a few.
-    // in proper code we'd not just rely 
-    // on random generation, we'd also have
-    // some known test vectors.
-    for i := 0; i < 100; i++ {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        // for good measure, do a few.
+        // in proper code we'd not just rely 
+        // on random generation, we'd also have
+        // some known test vectors.
+        for i := 0; i < 100; i++ {
+            kv, err := SchnorrGenerateKeypair(suite)
+            if err != nil {
+                t.Error("Keypair generation failed")
+            }
+
+            pk := SchnorrExtractPubkey(kv) 
+            message := []byte("This is a test")
+            wrongmessage := []byte("Clearly this shouldn't work")
+
+
+            sig, err := SchnorrSign(suite, kv, message, nil)
+            if err != nil {
+                t.Error("Signature Generation failed")    }
+
+
+            v1, e1 := SchnorrVerify(suite, pk, message, sig)
+            if e1 != nil {
+                t.Error("Error during Verification")
+            }
+            if v1 == false {
+                t.Error("Verification of signature failed")
+            }
+
+            v2, e2 := SchnorrVerify(suite, pk, wrongmessage, sig)
+            if e2 != nil {
+                t.Error("Error during Verification")
+            }
+            if v2 == true {
+                t.Error("Verification of signature succeeded for bad message")
+            }
+        }
+    })
+}
+
+func TestSchnorrNonceModes(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil {
+            t.Fatal("Keypair generation failed")
+        }
+        other, err := SchnorrGenerateKeypair(suite)
+        if err != nil {
+            t.Fatal("Keypair generation failed")
+        }
+        pk := SchnorrExtractPubkey(kv)
+        message := []byte("This is a test")
+        othermessage := []byte("This is another test")
+
+        sign := func(kv SchnorrKeyset, msg []byte, mode SchnorrNonceMode) []byte {
+            sig, err := SchnorrSignWithOptions(suite, kv, msg, SchnorrSignOptions{Nonce: mode})
+            if err != nil {
+                t.Fatal("Signature Generation failed", err.Error())
+            }
+            return sig
+        }
+
+        // every mode has to produce signatures that verify
+        for _, mode := range []SchnorrNonceMode{SchnorrNonceRandom, SchnorrNonceDeterministic, SchnorrNonceHedged} {
+            v, err := SchnorrVerify(suite, pk, message, sign(kv, message, mode))
+            if err != nil || v == false {
+                t.Error("Verification of signature failed for mode", mode)
+            }
+        }
+
+        // deterministic: same key and message, same signature;
+        // change either and the nonce changes too.
+        sig1 := sign(kv, message, SchnorrNonceDeterministic)
+        sig2 := sign(kv, message, SchnorrNonceDeterministic)
+        if !bytes.Equal(sig1, sig2) {
+            t.Error("Deterministic signatures differ for the same key and message")
+        }
+        if bytes.Equal(sig1, sign(kv, othermessage, SchnorrNonceDeterministic)) {
+            t.Error("Deterministic signatures equal for different messages")
+        }
+        if bytes.Equal(sig1, sign(other, message, SchnorrNonceDeterministic)) {
+            t.Error("Deterministic signatures equal for different keys")
+        }
+
+        // hedged mixes in randomness, so should not repeat
+        if bytes.Equal(sign(kv, message, SchnorrNonceHedged), sign(kv, message, SchnorrNonceHedged)) {
+            t.Error("Hedged signatures repeated")
+        }
+
+        _, err = SchnorrSignWithOptions(suite, kv, message, SchnorrSignOptions{Nonce: "bogus"})
+        if err == nil {
+            t.Error("Unknown nonce mode accepted")
+        }
+    })
+}
+
+func TestSchnorrContext(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
         kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil {
+            t.Fatal("Keypair generation failed")
+        }
+        pk := SchnorrExtractPubkey(kv)
+        message := []byte("This is a test")
+        context := []byte("vennard.ch test suite")
+
+        for _, mode := range []SchnorrNonceMode{SchnorrNonceDeterministic, SchnorrNonceHedged} {
+            sig, err := SchnorrSignWithOptions(suite, kv, message, SchnorrSignOptions{Nonce: mode, Context: context})
+            if err != nil {
+                t.Fatal("Signature Generation failed")
+            }
+            v, err := SchnorrVerifyWithContext(suite, pk, message, sig, context)
+            if err != nil || v == false {
+                t.Error("Verification with the right context failed")
+            }
+            v, err = SchnorrVerify(suite, pk, message, sig)
+            if err != nil || v == true {
+                t.Error("Verification without the context succeeded")
+            }
+            v, err = SchnorrVerifyWithContext(suite, pk, message, sig, []byte("vennard.ch other suite"))
+            if err != nil || v == true {
+                t.Error("Verification with the wrong context succeeded")
+            }
+        }
+
+        sig, err := SchnorrSign(suite, kv, message, context)
+        if err != nil {
+            t.Fatal("Signature Generation failed")
+        }
+        v, err := SchnorrVerifyWithContext(suite, pk, message, sig, context)
+        if err != nil || v == false {
+            t.Error("SchnorrSign ignored the context")
+        }
+
+        // a deterministic nonce must change with the context, or two 
+        // signatures on the same message would give away the key.
+        sig1, _ := SchnorrSignWithOptions(suite, kv, message, SchnorrSignOptions{Nonce: SchnorrNonceDeterministic, Format: SchnorrFormatRS})
+        sig2, _ := SchnorrSignWithOptions(suite, kv, message, SchnorrSignOptions{Nonce: SchnorrNonceDeterministic, Format: SchnorrFormatRS, Context: context})
+        if bytes.Equal(sig1[:suite.PointLen()], sig2[:suite.PointLen()]) {
+            t.Error("Same nonce used under two contexts")
+        }
+    })
+}
+
+func TestLoadSaveKeys(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        keypair, err := SchnorrGenerateKeypair(suite)
         if err != nil {
             t.Error("Keypair generation failed")
         }
-        
-        pk := SchnorrExtractPubkey(kv) 
+
+        pk := SchnorrExtractPubkey(keypair)
+
+
+        err = SchnorrSaveKeypair("/tmp/gotests.pri", suite, keypair)
+        if err !=  nil { t.Error("Failed to write file") }
+        err = SchnorrSavePubkey("/tmp/gotests.pub", suite, pk)
+        if err !=  nil { t.Error("Failed to write file") }
+
+        keypair_loaded, err := SchnorrLoadKeypair("/tmp/gotests.pri", suite)
+        if err !=  nil { t.Error("Failed to load keypair") }
+        pk_loaded, err := SchnorrLoadPubkey("/tmp/gotests.pub", suite)
+
         message := []byte("This is a test")
         wrongmessage := []byte("Clearly this shouldn't work")
-        
 
-        sig, err := SchnorrSign(suite, kv, message)
+        sig, err := SchnorrSign(suite, keypair_loaded, message, nil)
         if err != nil {
             t.Error("Signature Generation failed")    }
 
 
-        v1, e1 := SchnorrVerify(suite, pk, message, sig)
+        v1, e1 := SchnorrVerify(suite, pk_loaded, message, sig)
         if e1 != nil {
             t.Error("Error during Verification")
         }
@@ -56,60 +206,124 @@ func TestSchnorrSignature(t *testing.T) {
             t.Error("Verification of signature failed")
         }
 
-        v2, e2 := SchnorrVerify(suite, pk, wrongmessage, sig)
+        v2, e2 := SchnorrVerify(suite, pk_loaded, wrongmessage, sig)
         if e2 != nil {
             t.Error("Error during Verification")
         }
         if v2 == true {
             t.Error("Verification of signature succeeded for bad message")
         }
-    }
+
+    })
+}
+// The first three test vectors from RFC 8032 section 7.1.
+var ed25519Vectors = []struct {
+    secret, public, message, signature string
+}{
+    {   // TEST 1
+        "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60",
+        "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a",
+        "",
+        "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e06522490155" +
+        "5fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b",
+    },
+    {   // TEST 2
+        "4ccd089b28ff96da9db6c346ec114e0f5b8a319f35aba624da8cf6ed4fb8a6fb",
+        "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+        "72",
+        "92a009a9f0d4cab8720e820b5f642540a2b27b5416503f8fb3762223ebdb69da" +
+        "085ac1e43e15996e458f3613d0f11d8c387b2eaeb4302aeeb00d291612bb0c00",
+    },
+    {   // TEST 3
+        "c5aa8df43f9f837bedb7442f31dcb7b166d38535076f094b85ce3a2e0b4458f7",
+        "fc51cd8e6218a1a38da47ed00230f0580816ed13ba3303ac5deb911548908025",
+        "af82",
+        "6291d657deec24024827e69c3abe01a30ce548a284743a445e3680d7db5ac3ac" +
+        "18ff9b538d16f290ae67f760984dc6594a7c15e9716ed28dc027beceea1ec40a",
+    },
 }
 
+func TestEd25519Vectors(t *testing.T) {
+    for i, vector := range ed25519Vectors {
+        seed, _ := hex.DecodeString(vector.secret)
+        public, _ := hex.DecodeString(vector.public)
+        message, _ := hex.DecodeString(vector.message)
+        signature, _ := hex.DecodeString(vector.signature)
 
-func TestLoadSaveKeys(t *testing.T) {
-    
-    suite := ed25519.NewAES128SHA256Ed25519(true) 
-    
-    keypair, err := SchnorrGenerateKeypair(suite)
-    if err != nil {
-        t.Error("Keypair generation failed")
+        kv, err := Ed25519KeypairFromSeed(seed)
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+        if !bytes.Equal(kv.Public, public) {
+            t.Error("Wrong public key for test", i + 1)
+        }
+        sig := Ed25519Sign(kv, message)
+        if !bytes.Equal(sig, signature) {
+            t.Error("Wrong signature for test", i + 1)
+        }
+        v, err := Ed25519Verify(public, message, signature)
+        if err != nil || v == false {
+            t.Error("Signature did not verify for test", i + 1)
+        }
+        v, err = Ed25519Verify(public, append(message, 0), signature)
+        if err != nil || v == true {
+            t.Error("Signature verified for the wrong message in test", i + 1)
+        }
     }
-        
-    pk := SchnorrExtractPubkey(keypair)
-
+}
 
-    err = SchnorrSaveKeypair("/tmp/gotests.pri", suite, keypair)
-    if err !=  nil { t.Error("Failed to write file") }
-    err = SchnorrSavePubkey("/tmp/gotests.pub", suite, pk)
-    if err !=  nil { t.Error("Failed to write file") }
-
-    keypair_loaded, err := SchnorrLoadKeypair("/tmp/gotests.pri", suite)
-    if err !=  nil { t.Error("Failed to load keypair") }
-    pk_loaded, err := SchnorrLoadPubkey("/tmp/gotests.pub", suite)
-    
-    message := []byte("This is a test")
-    wrongmessage := []byte("Clearly this shouldn't work")
-        
-    sig, err := SchnorrSign(suite, keypair_loaded, message)
-    if err != nil {
-        t.Error("Signature Generation failed")    }
+// The Ed25519ph vector of RFC 8032, section 7.3: the message is
+// "abc", of which the signature is over the SHA-512 digest.
+func TestEd25519PrehashVector(t *testing.T) {
+    seed, _ := hex.DecodeString("833fe62409237b9d62ec77587520911e9a759cec1d19755b7da901b96dca3d42")
+    public, _ := hex.DecodeString("ec172b93ad5e563bf4932c70e1245034c35467ef2efd4d64ebf819683467e2bf")
+    signature, _ := hex.DecodeString("98a70222f0b8121aa9d30f813d683f809e462b469c7ff87639499bb94e6dae41" +
+                                     "31f85042463c2a355a2003d062adf5aaa10b8c61e636062aaad11c2a26083406")
+    kv, err := Ed25519KeypairFromSeed(seed)
+    if err != nil { t.Fatal(err.Error()) }
+    digest, err := PrehashReader(HashSHA512, bytes.NewReader([]byte("abc")))
+    if err != nil { t.Fatal(err.Error()) }
 
+    sig, err := Ed25519SignPrehash(kv, HashSHA512, digest)
+    if err != nil { t.Fatal(err.Error()) }
+    if !bytes.Equal(sig, signature) {
+        t.Error("Wrong Ed25519ph signature")
+    }
+    v, err := Ed25519VerifyPrehash(public, HashSHA512, digest, signature)
+    if err != nil || v == false {
+        t.Error("Ed25519ph signature did not verify")
+    }
 
-    v1, e1 := SchnorrVerify(suite, pk_loaded, message, sig)
-    if e1 != nil {
-        t.Error("Error during Verification")
+    // it is not a signature on the digest as a message, and only
+    // takes SHA-512
+    v, err = Ed25519Verify(public, digest, signature)
+    if err != nil || v == true {
+        t.Error("Ed25519ph signature verified as a plain one")
     }
-    if v1 == false {
-        t.Error("Verification of signature failed")
+    _, err = Ed25519SignPrehash(kv, HashSHA3_512, digest)
+    if err == nil {
+        t.Error("Signed a SHA3-512 digest as Ed25519ph")
     }
+}
 
-    v2, e2 := SchnorrVerify(suite, pk_loaded, wrongmessage, sig)
-    if e2 != nil {
-        t.Error("Error during Verification")
+func TestEd25519LoadSaveKeys(t *testing.T) {
+    kv, err := Ed25519GenerateKeypair()
+    if err != nil {
+        t.Fatal("Keypair generation failed")
     }
-    if v2 == true {
-        t.Error("Verification of signature succeeded for bad message")
+    err = Ed25519SaveKeypair("/tmp/gotests-ed25519.pri", kv)
+    if err != nil { t.Error("Failed to write file") }
+    err = Ed25519SavePubkey("/tmp/gotests-ed25519.pub", kv.Public)
+    if err != nil { t.Error("Failed to write file") }
+
+    loaded, err := Ed25519LoadKeypair("/tmp/gotests-ed25519.pri")
+    if err != nil { t.Fatal("Failed to load keypair") }
+    public, err := Ed25519LoadPubkey("/tmp/gotests-ed25519.pub")
+    if err != nil { t.Fatal("Failed to load public key") }
+
+    message := []byte("This is a test")
+    v, err := Ed25519Verify(public, message, Ed25519Sign(loaded, message))
+    if err != nil || v == false {
+        t.Error("Verification of signature failed")
     }
-    
-}
\ No newline at end of file
+}
@@ -0,0 +1,306 @@
+package crypto
+
+/* Saving a signature together with everything needed to check it
+   again later: the message, the signer's public key and, for
+   partially blind signatures, the agreed information. sigcli2
+   and sigcli3 write these so their signatures don't just vanish
+   once they've been printed.
+
+   There are three encodings of the same thing:
+
+     binary: "VNSM", a version byte (2), then seven fields each
+             written as a 32 bit big endian length and the bytes:
+                 kind       "schnorr" or "blind"
+                 suite      the suite's name, see suites.go
+                 pubkey     the point as MarshalBinary gives it,
+                            32 bytes in Ed25519 and 65 in P256
+                 message
+                 signature  as abstract.Write writes it: (S, E)
+                            for "schnorr", (P, W, S, D) for "blind"
+                 info       empty for "schnorr"
+                 context    empty unless one was used
+     hex:    the binary form in lowercase hex, one line
+     json:   the SignedMessage struct below, public key in hex
+             as in the group configuration, everything else
+             base64 as encoding/json does it
+
+   LoadSignedMessage works out which one it has been given. Version 1
+   binary files, and JSON ones without a Suite, didn't say which
+   suite they are in; we still read them, in the suite we are told. */
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "github.com/dedis/crypto/abstract"
+)
+
+// What kind of signature a SignedMessage carries, which
+// decides how it is checked.
+type SignedMessageKind string
+
+const (
+    // checked with SchnorrVerify: plain, multi- and threshold signatures
+    SignedSchnorr       SignedMessageKind = "schnorr"
+    // checked with VerifyBlindSignature
+    SignedBlind         SignedMessageKind = "blind"
+)
+
+// How SaveSignedMessage writes the file.
+type SignedMessageFormat string
+
+const (
+    SignedFormatBinary  SignedMessageFormat = "binary"
+    SignedFormatHex     SignedMessageFormat = "hex"
+    SignedFormatJSON    SignedMessageFormat = "json"
+)
+
+const (
+    signedMessageMagic      = "VNSM"
+    signedMessageVersion    = 2
+)
+
+type SignedMessage struct {
+    Kind        SignedMessageKind
+    Suite       string
+    PublicKey   SchnorrPublicKey
+    Message     []byte
+    Signature   []byte
+    Info        []byte      `json:",omitempty"`
+    Context     []byte      `json:",omitempty"`
+}
+
+// Checks a format name given on the command line.
+func ParseSignedMessageFormat(name string) (SignedMessageFormat, error) {
+    switch SignedMessageFormat(name) {
+    case SignedFormatBinary, SignedFormatHex, SignedFormatJSON:
+        return SignedMessageFormat(name), nil
+    }
+    return "", fmt.Errorf("unknown signature output format %s", name)
+}
+
+// Wraps a partially blind signature. The signature is stored as
+// abstract.Write would write it, like every other signature.
+func NewBlindSignedMessage(suite abstract.Suite, pk SchnorrPublicKey, sig WIBlindSignature, 
+                           info []byte, msg []byte, context []byte) SignedMessage {
+    buf := bytes.Buffer{}
+    abstract.Write(&buf, &sig, suite)
+    return SignedMessage{Kind: SignedBlind, Suite: suite.String(), PublicKey: pk, Message: msg, 
+                         Signature: buf.Bytes(), Info: info, Context: context}
+}
+
+// Decodes the signature of a blind SignedMessage.
+func (this * SignedMessage) BlindSignature(suite abstract.Suite) (WIBlindSignature, error) {
+    var sig WIBlindSignature
+    if this.Kind != SignedBlind {
+        return sig, errors.New("not a partially blind signature")
+    }
+    err := abstract.Read(bytes.NewBuffer(this.Signature), &sig, suite)
+    return sig, err
+}
+
+// The suite the signature is in.
+func (this * SignedMessage) GetSuite() (abstract.Suite, error) {
+    return SuiteByName(this.Suite)
+}
+
+// Checks the signature with SchnorrVerify or VerifyBlindSignature,
+// whichever its kind calls for.
+func (this * SignedMessage) Verify(suite abstract.Suite) (bool, error) {
+    switch this.Kind {
+    case SignedSchnorr:
+        return SchnorrVerifyWithContext(suite, this.PublicKey, this.Message, this.Signature, this.Context)
+    case SignedBlind:
+        sig, err := this.BlindSignature(suite)
+        if err != nil {
+            return false, err
+        }
+        return VerifyBlindSignature(suite, this.PublicKey, sig, this.Info, this.Message, this.Context)
+    }
+    return false, fmt.Errorf("unknown signature kind %s", this.Kind)
+}
+
+func writeSignedField(w io.Writer, data []byte) {
+    var length [4]byte
+    binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+    w.Write(length[:])
+    w.Write(data)
+}
+
+func readSignedField(r *bytes.Reader) ([]byte, error) {
+    var length [4]byte
+    _, err := io.ReadFull(r, length[:])
+    if err != nil {
+        return nil, err
+    }
+    n := binary.BigEndian.Uint32(length[:])
+    if int64(n) > int64(r.Len()) {
+        return nil, errors.New("signed message field runs past the end of the data")
+    }
+    data := make([]byte, n)
+    _, err = io.ReadFull(r, data)
+    return data, err
+}
+
+func encodeSignedMessageBinary(sm SignedMessage) ([]byte, error) {
+    pk, err := sm.PublicKey.Y.MarshalBinary()
+    if err != nil {
+        return nil, err
+    }
+    buf := bytes.Buffer{}
+    buf.WriteString(signedMessageMagic)
+    buf.WriteByte(signedMessageVersion)
+    for _, field := range [][]byte{[]byte(sm.Kind), []byte(sm.Suite), pk, sm.Message, sm.Signature, sm.Info, sm.Context} {
+        writeSignedField(&buf, field)
+    }
+    return buf.Bytes(), nil
+}
+
+// The suite to decode a signed message recorded as being in with:
+// that one, which must be suite if suite is not nil. Files that
+// don't record one are in suite, or the default if that is nil.
+func signedMessageSuite(suite abstract.Suite, recorded string) (abstract.Suite, error) {
+    if recorded == "" {
+        if suite == nil {
+            return DefaultSuite(), nil
+        }
+        return suite, nil
+    }
+    recordedSuite, err := SuiteByName(recorded)
+    if err != nil {
+        return nil, err
+    }
+    if suite != nil && SuiteName(suite) != SuiteName(recordedSuite) {
+        return nil, fmt.Errorf("signed message is in suite %s, not %s", SuiteName(recordedSuite), SuiteName(suite))
+    }
+    return recordedSuite, nil
+}
+
+func decodeSignedMessageBinary(suite abstract.Suite, data []byte) (SignedMessage, error) {
+    var sm SignedMessage
+    if len(data) < len(signedMessageMagic) + 1 || string(data[:len(signedMessageMagic)]) != signedMessageMagic {
+        return sm, errors.New("not a signed message")
+    }
+    version := data[len(signedMessageMagic)]
+    if version != 1 && version != signedMessageVersion {
+        return sm, fmt.Errorf("signed message version %d is not supported", version)
+    }
+    r := bytes.NewReader(data[len(signedMessageMagic) + 1:])
+
+    fields := make([][]byte, 7)
+    for i := range fields {
+        if version == 1 && i == 1 {
+            // no suite
+            continue
+        }
+        field, err := readSignedField(r)
+        if err != nil {
+            return sm, err
+        }
+        fields[i] = field
+    }
+    if r.Len() != 0 {
+        return sm, errors.New("trailing data after signed message")
+    }
+
+    suite, err := signedMessageSuite(suite, string(fields[1]))
+    if err != nil {
+        return sm, err
+    }
+    y := suite.Point()
+    err = y.UnmarshalBinary(fields[2])
+    if err != nil {
+        return sm, err
+    }
+    sm.Kind = SignedMessageKind(fields[0])
+    sm.Suite = suite.String()
+    sm.PublicKey = SchnorrPublicKey{y}
+    sm.Message = fields[3]
+    sm.Signature = fields[4]
+    if len(fields[5]) > 0 {
+        sm.Info = fields[5]
+    }
+    if len(fields[6]) > 0 {
+        sm.Context = fields[6]
+    }
+    return sm, nil
+}
+
+// Encodes the signed message in the given format.
+func EncodeSignedMessage(sm SignedMessage, format SignedMessageFormat) ([]byte, error) {
+    if sm.PublicKey.Y == nil {
+        return nil, errors.New("signed message has no public key")
+    }
+    if sm.Suite == "" {
+        return nil, errors.New("signed message has no suite")
+    }
+    switch format {
+    case SignedFormatJSON:
+        return json.MarshalIndent(sm, "", "    ")
+    case SignedFormatBinary:
+        return encodeSignedMessageBinary(sm)
+    case SignedFormatHex:
+        data, err := encodeSignedMessageBinary(sm)
+        if err != nil {
+            return nil, err
+        }
+        return []byte(hex.EncodeToString(data) + "\n"), nil
+    }
+    return nil, fmt.Errorf("unknown signature output format %s", format)
+}
+
+// Decodes a signed message in any of the three formats. It must be
+// in suite, unless that is nil, when it can be in any; see
+// signedMessageSuite for files that don't say.
+func DecodeSignedMessage(suite abstract.Suite, data []byte) (SignedMessage, error) {
+    var sm SignedMessage
+
+    if bytes.HasPrefix(data, []byte(signedMessageMagic)) {
+        return decodeSignedMessageBinary(suite, data)
+    }
+    trimmed := bytes.TrimSpace(data)
+    if bytes.HasPrefix(trimmed, []byte("{")) {
+        // the suite decides how to read the rest
+        var recorded struct{ Suite string }
+        err := json.Unmarshal(trimmed, &recorded)
+        if err != nil {
+            return sm, err
+        }
+        suite, err := signedMessageSuite(suite, recorded.Suite)
+        if err != nil {
+            return sm, err
+        }
+        err = DecodeJSON(suite, trimmed, &sm)
+        if err == nil && sm.PublicKey.Y == nil {
+            err = errors.New("signed message has no public key")
+        }
+        sm.Suite = suite.String()
+        return sm, err
+    }
+    decoded, err := hex.DecodeString(string(trimmed))
+    if err != nil {
+        return sm, errors.New("not a signed message")
+    }
+    return decodeSignedMessageBinary(suite, decoded)
+}
+
+func SaveSignedMessage(path string, sm SignedMessage, format SignedMessageFormat) error {
+    data, err := EncodeSignedMessage(sm, format)
+    if err != nil {
+        return err
+    }
+    return writeKeyFile(path, data, 0644)
+}
+
+func LoadSignedMessage(path string, suite abstract.Suite) (SignedMessage, error) {
+    fcontents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return SignedMessage{}, err
+    }
+    return DecodeSignedMessage(suite, fcontents)
+}
@@ -0,0 +1,152 @@
+package crypto
+
+import (
+    "bytes"
+    "github.com/dedis/crypto/abstract"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// a blind signature on message under the keypair, with info
+func makeBlindSignedMessage(t *testing.T, suite abstract.Suite, kv SchnorrKeyset, info []byte, message []byte) SignedMessage {
+    pk := SchnorrExtractPubkey(kv)
+
+    signerParams, err := NewPrivateParams(suite, info)
+    if err != nil { t.Fatal(err.Error()) }
+    challenge, userPrivateParams, err := ClientGenerateChallenge(suite, signerParams.DerivePubParams(), pk, info, message, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    response := ServerGenerateResponse(suite, challenge, signerParams, kv)
+    sig, worked := ClientSignBlindly(suite, userPrivateParams, response, pk, message, nil)
+    if !worked { t.Fatal("blind signing failed") }
+    return NewBlindSignedMessage(suite, pk, sig, info, message, nil)
+}
+
+func TestSignedMessageRoundTrip(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        message := []byte("a message worth keeping the signature for")
+
+        sig, err := SchnorrSign(suite, kv, message, nil)
+        if err != nil { t.Fatal(err.Error()) }
+        plain := SignedMessage{Kind: SignedSchnorr, Suite: suite.String(), PublicKey: SchnorrExtractPubkey(kv), 
+                               Message: message, Signature: sig}
+        blind := makeBlindSignedMessage(t, suite, kv, []byte("agreed info"), message)
+
+        dir, err := ioutil.TempDir("", "signedmessage")
+        if err != nil { t.Fatal(err.Error()) }
+        defer os.RemoveAll(dir)
+
+        for _, sm := range []SignedMessage{plain, blind} {
+            for _, format := range []SignedMessageFormat{SignedFormatBinary, SignedFormatHex, SignedFormatJSON} {
+                path := filepath.Join(dir, string(sm.Kind) + "." + string(format))
+                err = SaveSignedMessage(path, sm, format)
+                if err != nil { t.Fatal(err.Error()) }
+
+                loaded, err := LoadSignedMessage(path, suite)
+                if err != nil {
+                    t.Error("Loading", sm.Kind, format, "failed:", err.Error())
+                    continue
+                }
+                if loaded.Kind != sm.Kind || loaded.Suite != suite.String() || !loaded.PublicKey.Y.Equal(sm.PublicKey.Y) ||
+                   !bytes.Equal(loaded.Message, sm.Message) || !bytes.Equal(loaded.Signature, sm.Signature) ||
+                   !bytes.Equal(loaded.Info, sm.Info) {
+                    t.Error("Loaded", sm.Kind, format, "differs from what was saved")
+                }
+                // the file says which suite it is in
+                anySuite, err := LoadSignedMessage(path, nil)
+                if err != nil || anySuite.Suite != suite.String() {
+                    t.Error("Loading", sm.Kind, format, "without a suite failed")
+                }
+
+                v, err := loaded.Verify(suite)
+                if err != nil { t.Error(err.Error()) }
+                if !v {
+                    t.Error("Loaded", sm.Kind, format, "signature does not verify")
+                }
+
+                loaded.Message[0] ^= 1
+                v, _ = loaded.Verify(suite)
+                if v {
+                    t.Error("Loaded", sm.Kind, format, "signature verifies on a changed message")
+                }
+            }
+        }
+    })
+}
+
+func TestSignedMessageDecodeErrors(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        sig, err := SchnorrSign(suite, kv, []byte("m"), nil)
+        if err != nil { t.Fatal(err.Error()) }
+        sm := SignedMessage{Kind: SignedSchnorr, Suite: suite.String(), PublicKey: SchnorrExtractPubkey(kv), 
+                            Message: []byte("m"), Signature: sig}
+        data, err := EncodeSignedMessage(sm, SignedFormatBinary)
+        if err != nil { t.Fatal(err.Error()) }
+
+        for name, bad := range map[string][]byte{
+            "truncated":    data[:len(data) - 1],
+            "trailing":     append(append([]byte{}, data...), 0),
+            "version":      append([]byte("VNSM\x03"), data[5:]...),
+            "garbage":      []byte("not a signature at all"),
+        } {
+            _, err = DecodeSignedMessage(suite, bad)
+            if err == nil {
+                t.Error("Decoded a", name, "signed message")
+            }
+        }
+
+        _, err = EncodeSignedMessage(SignedMessage{Kind: SignedSchnorr}, SignedFormatBinary)
+        if err == nil {
+            t.Error("Encoded a signed message without a public key")
+        }
+        _, err = EncodeSignedMessage(SignedMessage{Kind: SignedSchnorr, PublicKey: sm.PublicKey}, SignedFormatJSON)
+        if err == nil {
+            t.Error("Encoded a signed message without a suite")
+        }
+
+        // nor may it be read in another suite than its own
+        for _, other := range SuiteNames() {
+            otherSuite, _ := SuiteByName(other)
+            if SuiteName(otherSuite) == SuiteName(suite) {
+                continue
+            }
+            _, err = DecodeSignedMessage(otherSuite, data)
+            if err == nil {
+                t.Error("Decoded a signed message in", other)
+            }
+        }
+    })
+}
+
+// Files from before signed messages said which suite they are in
+// are read in the suite we are given.
+func TestSignedMessageVersion1(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        sig, err := SchnorrSign(suite, kv, []byte("m"), nil)
+        if err != nil { t.Fatal(err.Error()) }
+        pk, err := SchnorrExtractPubkey(kv).Y.MarshalBinary()
+        if err != nil { t.Fatal(err.Error()) }
+
+        buf := bytes.Buffer{}
+        buf.WriteString("VNSM\x01")
+        for _, field := range [][]byte{[]byte("schnorr"), pk, []byte("m"), sig, nil, nil} {
+            writeSignedField(&buf, field)
+        }
+        sm, err := DecodeSignedMessage(suite, buf.Bytes())
+        if err != nil { t.Fatal(err.Error()) }
+        if sm.Suite != suite.String() {
+            t.Error("Version 1 signed message not in the suite given")
+        }
+        v, err := sm.Verify(suite)
+        if err != nil || v == false {
+            t.Error("Version 1 signed message does not verify")
+        }
+    })
+}
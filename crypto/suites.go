@@ -0,0 +1,80 @@
+package crypto
+
+/* The suites the programs can be told to use, by name. Nothing
+   else in this package cares which suite it is given, except
+   HashToPoint which needs to know the curve underneath.
+
+   A suite's name is its String() in lower case, so the Suite
+   header armor.go has always written ("Ed25519") names the same
+   suite as the "ed25519" given on the command line. Key files, 
+   detached signatures and group configurations record the String()
+   of the suite they were made with, and loading them with another 
+   one is an error; raw key files have nowhere to record it. */
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "github.com/dedis/crypto/abstract"
+    "github.com/dedis/crypto/edwards/ed25519"
+    "github.com/dedis/crypto/nist"
+)
+
+const (
+    SuiteEd25519    = "ed25519"
+    SuiteP256       = "p256"
+)
+
+// What everything used before there was a choice, and still
+// the suite when none is given.
+const DefaultSuiteName = SuiteEd25519
+
+var suites = map[string]func() abstract.Suite{
+    SuiteEd25519:   func() abstract.Suite { return ed25519.NewAES128SHA256Ed25519(true) },
+    SuiteP256:      func() abstract.Suite { return nist.NewAES128SHA256P256() },
+}
+
+// The names SuiteByName accepts, sorted.
+func SuiteNames() []string {
+    names := make([]string, 0, len(suites))
+    for name := range suites {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Returns the suite called name, ignoring case. The empty name
+// gives the default suite.
+func SuiteByName(name string) (abstract.Suite, error) {
+    if name == "" {
+        name = DefaultSuiteName
+    }
+    newSuite, ok := suites[strings.ToLower(name)]
+    if !ok {
+        return nil, fmt.Errorf("unknown suite %s, use one of %s", name,
+                               strings.Join(SuiteNames(), ", "))
+    }
+    return newSuite(), nil
+}
+
+// The default suite.
+func DefaultSuite() abstract.Suite {
+    suite, _ := SuiteByName(DefaultSuiteName)
+    return suite
+}
+
+// The name SuiteByName knows suite by.
+func SuiteName(suite abstract.Suite) string {
+    return strings.ToLower(suite.String())
+}
+
+// Checks a suite name recorded in a file against the suite we
+// are loading it with. Files that don't record one are taken
+// to match.
+func checkSuiteName(suite abstract.Suite, recorded string) error {
+    if recorded != "" && strings.ToLower(recorded) != SuiteName(suite) {
+        return fmt.Errorf("this is for suite %s, not %s", recorded, SuiteName(suite))
+    }
+    return nil
+}
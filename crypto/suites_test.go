@@ -0,0 +1,124 @@
+package crypto
+
+import (
+    "encoding/json"
+    "github.com/dedis/crypto/abstract"
+    "io/ioutil"
+    "strings"
+    "testing"
+)
+
+// Runs test once for every suite, as a subtest named after it.
+func forEachSuite(t *testing.T, test func(t *testing.T, suite abstract.Suite)) {
+    for _, name := range SuiteNames() {
+        suite, err := SuiteByName(name)
+        if err != nil { t.Fatal(err.Error()) }
+        t.Run(name, func(t *testing.T) { test(t, suite) })
+    }
+}
+
+func TestSuiteByName(t *testing.T) {
+    for _, name := range SuiteNames() {
+        suite, err := SuiteByName(name)
+        if err != nil { t.Fatal(err.Error()) }
+        if SuiteName(suite) != name {
+            t.Error("Suite", name, "calls itself", SuiteName(suite))
+        }
+        // the name armor.go has always written works too
+        again, err := SuiteByName(suite.String())
+        if err != nil || SuiteName(again) != name {
+            t.Error("Suite", name, "not found as", suite.String())
+        }
+    }
+
+    suite, err := SuiteByName("")
+    if err != nil || SuiteName(suite) != DefaultSuiteName {
+        t.Error("The empty name is not the default suite")
+    }
+    _, err = SuiteByName("p384")
+    if err == nil {
+        t.Error("Found a suite that doesn't exist")
+    }
+}
+
+// A key or group made in one suite must not load in another.
+func TestSuiteRecorded(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        for _, name := range SuiteNames() {
+            other, _ := SuiteByName(name)
+            if name == SuiteName(suite) {
+                continue
+            }
+
+            kv, err := SchnorrGenerateKeypair(suite)
+            if err != nil { t.Fatal(err.Error()) }
+            for _, format := range []KeyFileFormat{KeyFormatPEM, KeyFormatJSON} {
+                data, err := SchnorrEncodeKeypair(suite, kv, format)
+                if err != nil { t.Fatal(err.Error()) }
+                _, err = SchnorrDecodeKeypair(other, data)
+                if err == nil {
+                    t.Error("Key pair as", format, "loaded in suite", name)
+                }
+                pdata, err := SchnorrEncodePubkey(suite, SchnorrExtractPubkey(kv), format)
+                if err != nil { t.Fatal(err.Error()) }
+                _, err = SchnorrDecodePubkey(other, pdata)
+                if err == nil {
+                    t.Error("Public key as", format, "loaded in suite", name)
+                }
+            }
+
+            // a group configuration is read in the suite it names
+            proof, err := SchnorrProvePossession(suite, kv)
+            if err != nil { t.Fatal(err.Error()) }
+            config := SchnorrMGroupConfig{
+                Suite:       suite.String(),
+                JointKey:    SchnorrMComputeSharedPublicKey(suite, []SchnorrPublicKey{SchnorrExtractPubkey(kv)}).GetSchnorrPK(),
+                Aggregation: SchnorrMAggregationWeighted,
+                Members:     []SchnorrMMember{{"localhost", 1111, SchnorrExtractPubkey(kv), "", proof}},
+            }
+            err = SchnorrMSaveGroupConfig("/tmp/gotests.suitegroup", config)
+            if err != nil { t.Fatal(err.Error()) }
+            loaded, err := SchnorrMLoadGroupConfig("/tmp/gotests.suitegroup")
+            if err != nil { t.Fatal(err.Error()) }
+            if !loaded.JointKey.Y.Equal(config.JointKey.Y) || loaded.VerifyProofs(suite) != -1 {
+                t.Error("Group configuration changed on the way round")
+            }
+
+            // and not in the one it doesn't
+            data, _ := ioutil.ReadFile("/tmp/gotests.suitegroup")
+            renamed := strings.Replace(string(data), `"` + suite.String() + `"`, `"` + other.String() + `"`, 1)
+            ioutil.WriteFile("/tmp/gotests.suitegroup", []byte(renamed), 0644)
+            _, err = SchnorrMLoadGroupConfig("/tmp/gotests.suitegroup")
+            if err == nil {
+                t.Error("Group configuration loaded in suite", name)
+            }
+        }
+    })
+}
+
+// Group configurations from before the suite was recorded are in
+// the default suite.
+func TestGroupConfigWithoutSuite(t *testing.T) {
+    suite := DefaultSuite()
+    kv, err := SchnorrGenerateKeypair(suite)
+    if err != nil { t.Fatal(err.Error()) }
+    proof, err := SchnorrProvePossession(suite, kv)
+    if err != nil { t.Fatal(err.Error()) }
+    data, err := json.Marshal(struct{
+        JointKey    SchnorrPublicKey
+        Aggregation SchnorrMKeyAggregation
+        Members     []SchnorrMMember
+    }{SchnorrExtractPubkey(kv), SchnorrMAggregationLegacySum, []SchnorrMMember{{"localhost", 1111, SchnorrExtractPubkey(kv), "", proof}}})
+    if err != nil { t.Fatal(err.Error()) }
+    ioutil.WriteFile("/tmp/gotests.oldgroup", data, 0644)
+
+    loaded, err := SchnorrMLoadGroupConfig("/tmp/gotests.oldgroup")
+    if err != nil { t.Fatal(err.Error()) }
+    loadedSuite, err := loaded.GetSuite()
+    if err != nil || SuiteName(loadedSuite) != DefaultSuiteName {
+        t.Error("A configuration without a suite is not in the default one")
+    }
+    if !loaded.JointKey.Y.Equal(kv.Y) {
+        t.Error("Joint key changed")
+    }
+}
@@ -0,0 +1,183 @@
+package crypto
+
+/* This file implements t-of-n threshold Schnorr signing on top of
+   the multisignature protocol in multisignatures.go.
+
+   The group secret x is Shamir-shared: member i (counting from 1,
+   in the order they are listed in the group configuration) holds
+   x_i = f(i) for a random polynomial f of degree t-1 with f(0) = x,
+   and publishes y_i = g^x_i as its public key. The group key is g^x.
+
+   Signing is the usual three rounds (hash, reveal, respond) but
+   only t members take part, and each one uses its Lagrange
+   coefficient for the signing set in place of the key weight:
+   r_i = v_i - c*l_i*x_i. Summing over the signing set gives
+   v - c*x, so the result verifies with SchnorrVerify against g^x.
+   As in FROST the nonces are fixed before anyone sees the others'
+   (here via the commit-then-reveal round) so a coordinator
+   cannot steer the aggregate commitment.
+*/
+
+import (
+    "crypto/rand"
+    "errors"
+    "fmt"
+    "github.com/dedis/crypto/abstract"
+)
+
+// Picks a random secret. Same approach as the key generation code.
+func schnorrTRandomSecret(suite abstract.Suite) (abstract.Secret, error) {
+    rsource := make([]byte, 32)
+    _, err := rand.Read(rsource)
+    if err != nil {
+        return nil, err
+    }
+    rct := suite.Cipher(rsource)
+    return suite.Secret().Pick(rct), nil
+}
+
+// Generates a random polynomial of degree t-1 whose constant
+// term is the given secret. coeffs[k] is the coefficient of x^k.
+func schnorrTRandomPolynomial(suite abstract.Suite, secret abstract.Secret, t int) ([]abstract.Secret, error) {
+    coeffs := make([]abstract.Secret, t)
+    coeffs[0] = suite.Secret().Set(secret)
+    for k := 1; k < t; k++ {
+        a, err := schnorrTRandomSecret(suite)
+        if err != nil {
+            return nil, err
+        }
+        coeffs[k] = a
+    }
+    return coeffs, nil
+}
+
+// Evaluates the polynomial at x using Horner's rule.
+func schnorrTEvaluate(suite abstract.Suite, coeffs []abstract.Secret, x int) abstract.Secret {
+    xs := suite.Secret().SetInt64(int64(x))
+    result := suite.Secret().Zero()
+    for k := len(coeffs) - 1; k >= 0; k-- {
+        result.Mul(result, xs)
+        result.Add(result, coeffs[k])
+    }
+    return result
+}
+
+// Checks 1 <= t <= n. A threshold of 0 is treated by callers as "everyone".
+func schnorrTCheckThreshold(t int, n int) error {
+    if n < 1 {
+        return errors.New("a group needs at least one member")
+    }
+    if t < 1 || t > n {
+        return fmt.Errorf("threshold %d is not between 1 and %d", t, n)
+    }
+    return nil
+}
+
+// Splits the given secret into n shares, any t of which recover it.
+// shares[i] is the share for member index i+1.
+func SchnorrTSplitSecret(suite abstract.Suite, secret abstract.Secret, t int, n int) ([]abstract.Secret, error) {
+    err := schnorrTCheckThreshold(t, n)
+    if err != nil {
+        return nil, err
+    }
+    coeffs, err := schnorrTRandomPolynomial(suite, secret, t)
+    if err != nil {
+        return nil, err
+    }
+    shares := make([]abstract.Secret, n)
+    for i := range shares {
+        shares[i] = schnorrTEvaluate(suite, coeffs, i + 1)
+    }
+    return shares, nil
+}
+
+// Acts as a trusted dealer: picks a group secret, splits it between
+// n members with threshold t and returns the group public key and
+// one keyset per member, with X the share and Y = g^X. The dealer
+// knows the group secret, so it should be run somewhere you trust
+// and the output handed out carefully.
+func SchnorrTDealKeys(suite abstract.Suite, t int, n int) (SchnorrPublicKey, []SchnorrKeyset, error) {
+    groupKey, err := SchnorrGenerateKeypair(suite)
+    if err != nil {
+        return SchnorrPublicKey{}, nil, err
+    }
+    shares, err := SchnorrTSplitSecret(suite, groupKey.X, t, n)
+    if err != nil {
+        return SchnorrPublicKey{}, nil, err
+    }
+    keysets := make([]SchnorrKeyset, n)
+    for i, share := range shares {
+        keysets[i] = SchnorrKeyset{share, suite.Point().Mul(nil, share)}
+    }
+    return SchnorrExtractPubkey(groupKey), keysets, nil
+}
+
+// Computes the Lagrange coefficient at zero for the share with the
+// given index, when the signing set holds the shares listed in
+// indices: l_i = prod_{j != i} j / (j - i). Indices start from 1
+// and must be distinct.
+func SchnorrTLagrangeCoefficient(suite abstract.Suite, index int, indices []int) (abstract.Secret, error) {
+    num := suite.Secret().One()
+    den := suite.Secret().One()
+    found := false
+    seen := make(map[int]bool)
+
+    for _, j := range indices {
+        if j < 1 {
+            return nil, fmt.Errorf("invalid share index %d", j)
+        }
+        if seen[j] {
+            return nil, fmt.Errorf("share index %d appears twice", j)
+        }
+        seen[j] = true
+        if j == index {
+            found = true
+            continue
+        }
+        xj := suite.Secret().SetInt64(int64(j))
+        diff := suite.Secret().SetInt64(int64(j - index))
+        num.Mul(num, xj)
+        den.Mul(den, diff)
+    }
+    if !found {
+        return nil, fmt.Errorf("share index %d is not in the signing set", index)
+    }
+    return suite.Secret().Div(num, den), nil
+}
+
+// Recovers the secret from t or more shares. Only used by the
+// tests and for checking dealt keys: the signing protocol never
+// brings the shares together.
+func SchnorrTRecoverSecret(suite abstract.Suite, shares []abstract.Secret, indices []int) (abstract.Secret, error) {
+    if len(shares) != len(indices) {
+        return nil, errors.New("number of shares and indices differ")
+    }
+    secret := suite.Secret().Zero()
+    for i, share := range shares {
+        l, err := SchnorrTLagrangeCoefficient(suite, indices[i], indices)
+        if err != nil {
+            return nil, err
+        }
+        secret.Add(secret, suite.Secret().Mul(l, share))
+    }
+    return secret, nil
+}
+
+// Interpolates the public keys of the shares with the given indices
+// at zero, the counterpart of SchnorrTRecoverSecret in the exponent.
+// Given t verification shares it gives the group key, which lets
+// anyone check a group configuration without any of the shares.
+func SchnorrTRecoverPublic(suite abstract.Suite, pkeys []SchnorrPublicKey, indices []int) (SchnorrPublicKey, error) {
+    if len(pkeys) != len(indices) {
+        return SchnorrPublicKey{}, errors.New("number of keys and indices differ")
+    }
+    Y := suite.Point().Null()
+    for i, pkey := range pkeys {
+        l, err := SchnorrTLagrangeCoefficient(suite, indices[i], indices)
+        if err != nil {
+            return SchnorrPublicKey{}, err
+        }
+        Y.Add(Y, suite.Point().Mul(pkey.Y, l))
+    }
+    return SchnorrPublicKey{Y}, nil
+}
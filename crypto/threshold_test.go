@@ -0,0 +1,112 @@
+package crypto
+
+import (
+    "bytes"
+    "github.com/dedis/crypto/abstract"
+    "testing"
+)
+
+// Any t of the n shares give back the secret; fewer do not.
+func TestThresholdSplitRecover(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+
+        shares, err := SchnorrTSplitSecret(suite, kv.X, 3, 5)
+        if err != nil { t.Fatal(err.Error()) }
+
+        subsets := [][]int{ {1, 2, 3}, {5, 3, 1}, {2, 4, 5}, {1, 2, 3, 4, 5} }
+        for _, indices := range subsets {
+            var subset []abstract.Secret
+            for _, i := range indices {
+                subset = append(subset, shares[i - 1])
+            }
+            recovered, err := SchnorrTRecoverSecret(suite, subset, indices)
+            if err != nil { t.Error(err.Error()) }
+            if !recovered.Equal(kv.X) {
+                t.Error("Failed to recover the secret from shares", indices)
+            }
+        }
+
+        recovered, err := SchnorrTRecoverSecret(suite, shares[:2], []int{1, 2})
+        if err != nil { t.Error(err.Error()) }
+        if recovered.Equal(kv.X) {
+            t.Error("Recovered the secret from fewer than t shares")
+        }
+
+        _, err = SchnorrTSplitSecret(suite, kv.X, 6, 5)
+        if err == nil {
+            t.Error("Accepted a threshold larger than the group")
+        }
+    })
+}
+
+// Runs the signing protocol with a 2-of-3 dealt group, once for
+// each possible pair of signers, and checks the result verifies
+// against the group key with the ordinary SchnorrVerify.
+func TestThresholdSignature(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        groupKey, keysets, err := SchnorrTDealKeys(suite, 2, 3)
+        if err != nil { t.Fatal(err.Error()) }
+
+        config := SchnorrMGroupConfig{
+            Suite:       suite.String(),
+            JointKey:    groupKey,
+            Aggregation: SchnorrMAggregationThreshold,
+            Threshold:   2,
+        }
+        for _, kv := range keysets {
+            config.Members = append(config.Members, SchnorrMMember{HostName: "localhost", PKey: SchnorrExtractPubkey(kv)})
+        }
+
+        message := []byte("This is a test")
+
+        for _, signers := range [][]int{ {0, 1}, {0, 2}, {1, 2} } {
+
+            var privateCommits []SchnorrMPrivateCommitment
+            var commits []SchnorrMPublicCommitment
+            for _ = range signers {
+                commit, err := SchnorrMGenerateCommitment(suite)
+                if err != nil { t.Fatal(err.Error()) }
+                privateCommits = append(privateCommits, commit)
+                commits = append(commits, commit.PublicCommitment())
+            }
+
+            aggregate := SchnorrMComputeAggregateCommitment(suite, commits)
+            cc := SchnorrMComputeCollectiveChallenge(suite, config.JointKey, message, nil, aggregate)
+
+            var responses []SchnorrMResponse
+            var coeffs []abstract.Secret
+            var pks []SchnorrPublicKey
+            for k, i := range signers {
+                coeff, err := config.Coefficient(suite, i, signers)
+                if err != nil { t.Fatal(err.Error()) }
+                coeffs = append(coeffs, coeff)
+                pks = append(pks, config.Members[i].PKey)
+                responses = append(responses, SchnorrMUnmarshallCCComputeResponse(suite, keysets[i], coeff, privateCommits[k], cc))
+            }
+
+            bad, err := SchnorrMVerifyResponses(suite, pks, coeffs, commits, cc, responses)
+            if err != nil { t.Error(err.Error()) }
+            if len(bad) != 0 {
+                t.Error("Honest threshold responses failed to verify", bad)
+            }
+
+            sig := SchnorrMComputeSignatureFromResponses(suite, cc, responses)
+            buf := bytes.Buffer{} 
+            abstract.Write(&buf, &sig, suite)
+
+            verified, err := SchnorrVerify(suite, config.JointKey, message, buf.Bytes())
+            if err != nil { t.Error(err.Error()) }
+            if verified == false {
+                t.Error("Threshold signature failed to verify for signers", signers)
+            }
+        }
+
+        // one signer on its own is below the threshold
+        _, err = config.Coefficient(suite, 0, []int{0})
+        if err == nil {
+            t.Error("Got a coefficient for a signing set below the threshold")
+        }
+    })
+}
@@ -0,0 +1,98 @@
+package crypto
+
+/* Challenge hashing. Every challenge we compute goes through a
+   transcript rather than hashing a plain concatenation like msg||R:
+
+     - it starts with a version tag and a protocol label, so a
+       hash from one scheme is never a valid hash in another,
+     - every field is written with its name and its length, so
+       moving bytes from one field into the next changes the hash,
+     - the signer's public key is always included, and so is a
+       context string the caller can choose (empty by default)
+       to keep signatures made for one application from being
+       accepted by another.
+
+   Field lengths are 32 bit big endian. The transcript is hashed
+   with SHA3-256 as before. */
+
+import (
+    "encoding/binary"
+    "hash"
+    "golang.org/x/crypto/sha3"
+    "github.com/dedis/crypto/abstract"
+)
+
+// Bump this if the layout of the transcript ever changes.
+const schnorrTranscriptVersion = "vennard.ch/crypto transcript v1"
+
+// Protocol labels.
+const (
+    // plain, multi- and threshold signatures; they all
+    // verify with SchnorrVerify so they share a label.
+    schnorrLabelSignature   = "schnorr-signature"
+    // the partially blind scheme in partialBlind.go
+    schnorrLabelBlind       = "wi-schnorr-partially-blind"
+    // deriving signing nonces in schnorr.go
+    schnorrLabelNonce       = "schnorr-nonce"
+    // what DKG members echo to each other, see dkg.go
+    schnorrLabelDKGCommitments = "schnorr-dkg-commitments"
+)
+
+type schnorrTranscript struct {
+    hasher  hash.Hash
+}
+
+func newSchnorrTranscript(label string) *schnorrTranscript {
+    t := &schnorrTranscript{sha3.New256()}
+    t.Append("version", []byte(schnorrTranscriptVersion))
+    t.Append("label", []byte(label))
+    return t
+}
+
+func (this * schnorrTranscript) writeLength(n int) {
+    var length [4]byte
+    binary.BigEndian.PutUint32(length[:], uint32(n))
+    this.hasher.Write(length[:])
+}
+
+// Adds a named field to the transcript.
+func (this * schnorrTranscript) Append(name string, data []byte) {
+    this.writeLength(len(name))
+    this.hasher.Write([]byte(name))
+    this.writeLength(len(data))
+    this.hasher.Write(data)
+}
+
+func (this * schnorrTranscript) AppendPoint(name string, p abstract.Point) {
+    p_bin, _ := p.MarshalBinary()
+    this.Append(name, p_bin)
+}
+
+func (this * schnorrTranscript) Sum() []byte {
+    return this.hasher.Sum(nil)
+}
+
+// The hash behind e for a Schnorr signature: signer's key y,
+// caller's context, commitment r = g^k and message.
+func schnorrSignatureHash(y abstract.Point, context []byte, msg []byte, r abstract.Point) []byte {
+    t := newSchnorrTranscript(schnorrLabelSignature)
+    t.AppendPoint("pubkey", y)
+    t.Append("context", context)
+    t.AppendPoint("commitment", r)
+    t.Append("message", msg)
+    return t.Sum()
+}
+
+// The hash behind the partially blind signature: H(alpha, beta, z, msg)
+// in the paper, plus the signer's key y and the context.
+func schnorrBlindHash(y abstract.Point, context []byte, alpha abstract.Point, 
+                      beta abstract.Point, z abstract.Point, msg []byte) []byte {
+    t := newSchnorrTranscript(schnorrLabelBlind)
+    t.AppendPoint("pubkey", y)
+    t.Append("context", context)
+    t.AppendPoint("alpha", alpha)
+    t.AppendPoint("beta", beta)
+    t.AppendPoint("z", z)
+    t.Append("message", msg)
+    return t.Sum()
+}
@@ -0,0 +1,50 @@
+package crypto
+
+import (
+    "bytes"
+    "github.com/dedis/crypto/abstract"
+    "testing"
+)
+
+func TestTranscriptFraming(t *testing.T) {
+
+    hash := func(label string, fields ...string) []byte {
+        tr := newSchnorrTranscript(label)
+        for k := 0; k < len(fields); k += 2 {
+            tr.Append(fields[k], []byte(fields[k + 1]))
+        }
+        return tr.Sum()
+    }
+
+    base := hash(schnorrLabelSignature, "context", "ab", "message", "c")
+
+    // moving bytes from one field to the next must change the hash
+    if bytes.Equal(base, hash(schnorrLabelSignature, "context", "a", "message", "bc")) {
+        t.Error("Field boundaries are not part of the transcript")
+    }
+    // as must the label or a field name
+    if bytes.Equal(base, hash(schnorrLabelBlind, "context", "ab", "message", "c")) {
+        t.Error("Protocol label is not part of the transcript")
+    }
+    if bytes.Equal(base, hash(schnorrLabelSignature, "contexT", "ab", "message", "c")) {
+        t.Error("Field names are not part of the transcript")
+    }
+    if !bytes.Equal(base, hash(schnorrLabelSignature, "context", "ab", "message", "c")) {
+        t.Error("Transcript hash is not deterministic")
+    }
+}
+
+func TestTranscriptBindsKey(t *testing.T) {
+    forEachSuite(t, func(t *testing.T, suite abstract.Suite) {
+        kv1, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+        kv2, err := SchnorrGenerateKeypair(suite)
+        if err != nil { t.Fatal(err.Error()) }
+
+        r := suite.Point().Base()
+        message := []byte("This is a test")
+        if bytes.Equal(schnorrSignatureHash(kv1.Y, nil, message, r), schnorrSignatureHash(kv2.Y, nil, message, r)) {
+            t.Error("Public key is not part of the challenge")
+        }
+    })
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"vennard.ch/crypto"
+)
+
+/* Rewrites a key file in another format: raw (what keytool used to 
+   write), pem (the armored files it writes now) or json. See 
+   detectKeyFile for how we tell what the input is. Encrypted keys 
+   are decrypted on the way in and only encrypted on the way out if 
+   asked to. The key stays in the suite it was in. */
+func runConvert(input string, output string, format crypto.KeyFileFormat, 
+                scheme crypto.SignatureScheme, suiteName string, encrypt bool) error {
+
+	info, err := detectKeyFile(input, scheme, suiteName)
+	if err != nil {
+		return err
+	}
+	scheme, public := info.Scheme, info.Public
+	if encrypt && (public || format != crypto.KeyFormatPEM) {
+		return errors.New("only private keys written as pem can be encrypted")
+	}
+
+	var passphrase []byte
+	if encrypt {
+		passphrase, err = crypto.KeyPassphrase(output, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if scheme == crypto.SchemeEd25519 {
+		if public {
+			pk, err := crypto.Ed25519LoadPubkey(input)
+			if err != nil {
+				return err
+			}
+			return crypto.Ed25519SavePubkeyAs(output, pk, format)
+		}
+		kv, err := crypto.Ed25519LoadKeypair(input)
+		if err != nil {
+			return err
+		}
+		if encrypt {
+			return crypto.Ed25519SaveEncryptedKeypair(output, kv, passphrase, crypto.DefaultKeyEncryptionParams)
+		}
+		return crypto.Ed25519SaveKeypairAs(output, kv, format)
+	}
+
+	suite, err := crypto.SuiteByName(info.Suite)
+	if err != nil {
+		return err
+	}
+	if public {
+		pk, err := crypto.SchnorrLoadPubkey(input, suite)
+		if err != nil {
+			return err
+		}
+		return crypto.SchnorrSavePubkeyAs(output, suite, pk, format)
+	}
+	kv, err := crypto.SchnorrLoadKeypair(input, suite)
+	if err != nil {
+		return err
+	}
+	if encrypt {
+		return crypto.SchnorrSaveEncryptedKeypair(output, suite, kv, passphrase, crypto.DefaultKeyEncryptionParams)
+	}
+	return crypto.SchnorrSaveKeypairAs(output, suite, kv, format)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"github.com/dedis/crypto/abstract"
+	"vennard.ch/crypto"
+	"vennard.ch/signer"
+	"vennard.ch/transport"
+)
+
+/* Coordinates a distributed key generation between running sigserv2 (or sigd)
+   instances (started with -dkgshare) and writes the resulting threshold
+   group configuration. The key files given are the servers' long-term 
+   public keys, which the shares are encrypted to; we only ever pass on 
+   ciphertexts and commitments, so unlike mkthreshold this process 
+   never learns anything secret. The servers must be using suite too,
+   and we connect to them over TLS if tlsConfig is not nil, with
+   authKey if they want us to authenticate. */
+func runDKG (suite abstract.Suite, tlsConfig *tls.Config, authKey *signer.ClientKey, group []SchnorrMSHostSpec, threshold int, outputFile string) error {
+
+	n := len(group)
+
+	var members []crypto.SchnorrPublicKey
+	for _, mshp := range group {
+		pkey, err := crypto.SchnorrLoadPubkey(mshp.KeyFilePath, suite)
+		if err != nil {
+			return err
+		}
+		members = append(members, pkey)
+	}
+
+	session := make([]byte, 32)
+	_, err := rand.Read(session)
+	if err != nil {
+		return err
+	}
+
+	var conns []*transport.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	for i, mshp := range group {
+		hostspec := net.JoinHostPort(mshp.HostName, strconv.Itoa(mshp.Port))
+		conn, err := transport.DialProtocol(hostspec, transport.ProtocolMultisig, tlsConfig)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+		err = signer.Authenticate(conn, authKey, crypto.SchnorrFingerprint(members[i]))
+		if err != nil {
+			return err
+		}
+	}
+
+	// round one: everyone deals.
+	var deals []crypto.SchnorrDKGDeal
+	for i, conn := range conns {
+		setup := crypto.SchnorrDKGSetup{Session: session, Threshold: threshold, Self: i, Members: members}
+		err := conn.Send(signer.DKG_SETUP, crypto.SchnorrDKGEncodeSetup(suite, setup))
+		if err != nil {
+			return err
+		}
+
+		buffer, err := conn.Expect(signer.DKG_SETUP)
+		if err != nil {
+			return fmt.Errorf("member %d did not deal: %s", i, err.Error())
+		}
+		deal, err := crypto.SchnorrDKGDecodeDeal(suite, buffer)
+		if err != nil {
+			return fmt.Errorf("member %d sent a bad deal: %s", i, err.Error())
+		}
+		if len(deal.Commitments) != threshold || len(deal.Shares) != n {
+			return fmt.Errorf("member %d sent a deal of the wrong size", i)
+		}
+		deals = append(deals, deal)
+		fmt.Println("Received deal from", group[i].HostName, group[i].Port)
+	}
+
+	var commitments [][]abstract.Point
+	for _, deal := range deals {
+		commitments = append(commitments, deal.Commitments)
+	}
+	groupKey, verificationShares := crypto.SchnorrDKGPublicKeys(suite, commitments, n)
+	hash := crypto.SchnorrDKGCommitmentHash(suite, session, members, commitments)
+
+	// round two: pass each member the shares meant for it and
+	// check that it ends up where the commitments say it should,
+	// having seen the commitments we did.
+	proofs := make([][]byte, n)
+	var echoes []crypto.SchnorrDKGEcho
+	for j, conn := range conns {
+		var dealings []crypto.SchnorrDKGDealing
+		for _, deal := range deals {
+			dealings = append(dealings, deal.For(j))
+		}
+		err := conn.Send(signer.DKG_SHARES, crypto.SchnorrDKGEncodeDealings(suite, dealings))
+		if err != nil {
+			return err
+		}
+
+		buffer, err := conn.Expect(signer.DKG_SHARES)
+		if err != nil {
+			return fmt.Errorf("member %d did not finish: %s", j, err.Error())
+		}
+		report, err := crypto.SchnorrDKGDecodeReport(suite, buffer)
+		if err != nil {
+			return fmt.Errorf("member %d sent a bad result: %s", j, err.Error())
+		}
+		if !report.GroupKey.Y.Equal(groupKey.Y) || !report.VerificationShare.Y.Equal(verificationShares[j].Y) {
+			return fmt.Errorf("member %d disagrees about the outcome", j)
+		}
+		v, err := crypto.SchnorrVerifyPossession(suite, report.VerificationShare, report.Proof)
+		if err != nil || v == false {
+			return fmt.Errorf("member %d sent a bad proof of possession", j)
+		}
+		proofs[j] = report.Proof
+		echoes = append(echoes, report.Echo)
+		fmt.Println("Member", group[j].HostName, group[j].Port, "has its share")
+	}
+
+	// round three: everyone's echo goes to every member, which only
+	// keeps its share if they all agree. We check them first, so as
+	// not to start a round some member is bound to refuse.
+	err = crypto.SchnorrDKGCheckEchoes(suite, members, hash, echoes)
+	if err != nil {
+		return err
+	}
+	encodedEchoes := crypto.SchnorrDKGEncodeEchoes(echoes)
+	for _, conn := range conns {
+		err := conn.Send(signer.DKG_CONFIRM, encodedEchoes)
+		if err != nil {
+			return err
+		}
+	}
+	for j, conn := range conns {
+		_, err := conn.Expect(signer.DKG_CONFIRM)
+		if err != nil {
+			return fmt.Errorf("member %d did not keep its share: %s", j, err.Error())
+		}
+		fmt.Println("Member", group[j].HostName, group[j].Port, "holds its share")
+	}
+
+	config := crypto.SchnorrMGroupConfig{
+		Suite:       suite.String(),
+		JointKey:    groupKey,
+		Aggregation: crypto.SchnorrMAggregationThreshold,
+		Threshold:   threshold,
+	}
+	for j, mshp := range group {
+		member := crypto.SchnorrMMember{HostName: mshp.HostName, Port: mshp.Port, PKey: verificationShares[j], 
+		                              Fingerprint: crypto.SchnorrFingerprint(verificationShares[j]), PoP: proofs[j]}
+		config.Members = append(config.Members, member)
+	}
+
+	return crypto.SchnorrMSaveGroupConfig(outputFile, config)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"vennard.ch/crypto"
+)
+
+/* What a key file holds, as far as we can tell without decrypting
+   it. Armored and JSON files say so themselves. A raw file only has
+   its length to go by: 64 bytes is a Schnorr key pair in Ed25519 and
+   97 or 65 bytes a P-256 key pair or public key. For 32 bytes we need 
+   the scheme from the command line and, for Ed25519 where seed and 
+   public key are the same size, the name: .pub is a public key and 
+   anything else a private one. The suite from the command line is
+   only used for raw files that could be in any. */
+type keyFileInfo struct {
+	Scheme    crypto.SignatureScheme
+	Public    bool
+	Format    crypto.KeyFileFormat
+	Encrypted bool
+	Suite     string
+}
+
+func detectKeyFile(path string, scheme crypto.SignatureScheme, suiteName string) (keyFileInfo, error) {
+	info := keyFileInfo{Scheme: scheme, Suite: suiteName}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+
+	switch {
+	case crypto.IsArmored(data):
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return info, fmt.Errorf("%s is not a valid PEM file", path)
+		}
+		info.Format = crypto.KeyFormatPEM
+		info.Encrypted = strings.HasPrefix(block.Type, "ENCRYPTED ")
+		info.Public = strings.HasSuffix(block.Type, "PUBLIC KEY")
+		info.Suite = block.Headers["Suite"]
+		info.Scheme, err = crypto.ParseSignatureScheme(block.Headers["Scheme"])
+		if err != nil {
+			return info, err
+		}
+		if !strings.HasSuffix(block.Type, " KEY") {
+			return info, fmt.Errorf("%s holds a %s, not a key", path, block.Type)
+		}
+	case crypto.IsEncryptedKey(data):
+		info.Format = crypto.KeyFormatRaw
+		info.Encrypted = true
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		var fields map[string]json.RawMessage
+		err = json.Unmarshal(data, &fields)
+		if err != nil {
+			return info, err
+		}
+		info.Format = crypto.KeyFormatJSON
+		_, hasSeed := fields["Seed"]
+		_, hasPublic := fields["Public"]
+		_, hasX := fields["X"]
+		if hasSeed || hasPublic {
+			info.Scheme = crypto.SchemeEd25519
+		} else {
+			info.Scheme = crypto.SchemeSchnorr
+		}
+		info.Public = !hasSeed && !hasX
+		if suite, ok := fields["Suite"]; ok {
+			err = json.Unmarshal(suite, &info.Suite)
+			if err != nil {
+				return info, err
+			}
+		}
+	default:
+		info.Format = crypto.KeyFormatRaw
+		switch {
+		case len(data) == 64:
+			info.Scheme, info.Suite = crypto.SchemeSchnorr, crypto.SuiteEd25519
+		case len(data) == 97 || len(data) == 65:
+			info.Scheme, info.Suite = crypto.SchemeSchnorr, crypto.SuiteP256
+			info.Public = len(data) == 65
+		case info.Scheme == crypto.SchemeSchnorr:
+			info.Public = true
+		default:
+			info.Public = strings.HasSuffix(path, ".pub")
+		}
+	}
+	if info.Scheme == crypto.SchemeEd25519 {
+		info.Suite = "Ed25519"
+	} else if suite, err := crypto.SuiteByName(info.Suite); err == nil {
+		info.Suite = suite.String()
+	}
+	return info, nil
+}
+
+// Loads the public half of whatever key is in the file; for an
+// encrypted private key that means asking for the passphrase.
+func loadPublicKey(path string, info keyFileInfo) ([]byte, string, error) {
+	if info.Scheme == crypto.SchemeEd25519 {
+		var public []byte
+		if info.Public {
+			pk, err := crypto.Ed25519LoadPubkey(path)
+			if err != nil {
+				return nil, "", err
+			}
+			public = pk
+		} else {
+			kv, err := crypto.Ed25519LoadKeypair(path)
+			if err != nil {
+				return nil, "", err
+			}
+			public = kv.Public
+		}
+		return public, crypto.Ed25519Fingerprint(public), nil
+	}
+
+	suite, err := crypto.SuiteByName(info.Suite)
+	if err != nil {
+		return nil, "", err
+	}
+	var pk crypto.SchnorrPublicKey
+	if info.Public {
+		k, err := crypto.SchnorrLoadPubkey(path, suite)
+		if err != nil {
+			return nil, "", err
+		}
+		pk = k
+	} else {
+		kv, err := crypto.SchnorrLoadKeypair(path, suite)
+		if err != nil {
+			return nil, "", err
+		}
+		pk = crypto.SchnorrExtractPubkey(kv)
+	}
+	public, err := pk.Y.MarshalBinary()
+	return public, crypto.SchnorrFingerprint(pk), err
+}
+
+func runInspect(path string, scheme crypto.SignatureScheme, suiteName string) error {
+	info, err := detectKeyFile(path, scheme, suiteName)
+	if err != nil {
+		return err
+	}
+	public, fingerprint, err := loadPublicKey(path, info)
+	if err != nil {
+		return err
+	}
+
+	kind := "private key"
+	if info.Public {
+		kind = "public key"
+	}
+	format := string(info.Format)
+	if info.Encrypted {
+		format += ", encrypted"
+	}
+	fmt.Println("File:        ", path)
+	fmt.Println("Type:        ", info.Scheme, kind)
+	fmt.Println("Format:      ", format)
+	fmt.Println("Suite:       ", info.Suite)
+	fmt.Println("Public key:  ", hex.EncodeToString(public))
+	fmt.Println("Fingerprint: ", fingerprint)
+	return nil
+}
+
+func runFingerprint(path string, scheme crypto.SignatureScheme, suiteName string) error {
+	info, err := detectKeyFile(path, scheme, suiteName)
+	if err != nil {
+		return err
+	}
+	_, fingerprint, err := loadPublicKey(path, info)
+	if err != nil {
+		return err
+	}
+	fmt.Println(fingerprint)
+	return nil
+}
@@ -5,9 +5,14 @@ import (
 	"fmt"
 	"crypto/rand"
 	"bytes"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"github.com/dedis/crypto/abstract"
+	"vennard.ch/crypto"
+	"vennard.ch/signer"
+	"vennard.ch/transport"
     kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -22,10 +27,50 @@ var (
 
 	genCmd = app.Command("gen", "Generate a new server instance pub,pri keypair")
 	genCmdOutput = genCmd.Arg("output", "Output file path to write (appends .pub, .pri)").Required().String()
+	genCmdEncrypt = genCmd.Flag("encrypt", "Encrypt the private key with a passphrase (taken from $SCHNORR_KEY_PASSPHRASE if set)").Bool()
+	genCmdScheme = genCmd.Flag("scheme", "Signature scheme the key is for: schnorr or ed25519 (RFC 8032)").Default("schnorr").Enum("schnorr", "ed25519")
+	genCmdSuite = genCmd.Flag("suite", "Suite for a schnorr key: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
 
 	groupCmd = app.Command("mkgroup", "Create a Schnorr Multisignature group configuration file")
 	groupCmdOutput = groupCmd.Arg("output", "Write the output file to this path").Required().String()
 	groupCmdHost = groupCmd.Arg("host:port,pathtokey", "triplet  indicating host to add").Required().Strings()
+	groupCmdSuite = groupCmd.Flag("suite", "Suite the keys are in: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
+
+	thresholdCmd = app.Command("mkthreshold", "Deal a t-of-n threshold key and write the shares and group configuration")
+	thresholdCmdOutput = thresholdCmd.Arg("output", "Write the group configuration to this path").Required().String()
+	thresholdCmdThreshold = thresholdCmd.Arg("threshold", "Number of members needed to sign").Required().Int()
+	thresholdCmdHost = thresholdCmd.Arg("host:port,pathtokey", "host to add and where to write its key share (appends .pub, .pri)").Required().Strings()
+	thresholdCmdSuite = thresholdCmd.Flag("suite", "Suite to deal the key in: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
+
+	dkgCmd = app.Command("dkg", "Run a distributed key generation between sigserv2 instances and write the group configuration")
+	dkgCmdOutput = dkgCmd.Arg("output", "Write the group configuration to this path").Required().String()
+	dkgCmdThreshold = dkgCmd.Arg("threshold", "Number of members needed to sign").Required().Int()
+	dkgCmdHost = dkgCmd.Arg("host:port,pathtokey", "server to include and its long-term public key").Required().Strings()
+	dkgCmdSuite = dkgCmd.Flag("suite", "Suite the servers' keys are in: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
+	dkgCmdTLS = dkgCmd.Flag("tls", "Connect to the servers over TLS, trusting the system's CAs unless --tlsca is given").Bool()
+	dkgCmdTLSCA = dkgCmd.Flag("tlsca", "Connect over TLS, trusting only the CAs in this file (PEM)").String()
+	dkgCmdTLSCert = dkgCmd.Flag("tlscert", "Connect over TLS and present this client certificate (PEM)").String()
+	dkgCmdTLSKey = dkgCmd.Flag("tlskey", "Private key for --tlscert (PEM)").String()
+	dkgCmdAuthKey = dkgCmd.Flag("authkey", "Authenticate to the servers with this schnorr key pair").String()
+	dkgCmdAuthSuite = dkgCmd.Flag("authsuite", "Suite of --authkey: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
+
+	convertCmd = app.Command("convert", "Convert a key file between the raw, pem and json formats")
+	convertCmdInput = convertCmd.Arg("input", "Key file to read; a raw Ed25519 key is taken to be public if it ends in .pub").Required().String()
+	convertCmdOutput = convertCmd.Arg("output", "Write the converted key here").Required().String()
+	convertCmdTo = convertCmd.Flag("to", "Format to write: raw, pem or json").Default("pem").Enum("raw", "pem", "json")
+	convertCmdScheme = convertCmd.Flag("scheme", "Signature scheme of the key, if the input doesn't say: schnorr or ed25519").Default("schnorr").Enum("schnorr", "ed25519")
+	convertCmdEncrypt = convertCmd.Flag("encrypt", "Encrypt the private key with a passphrase (pem only)").Bool()
+	convertCmdSuite = convertCmd.Flag("suite", "Suite of a raw schnorr key, if its length doesn't say: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
+
+	inspectCmd = app.Command("inspect", "Show what a key file holds: type, format, suite, public key and fingerprint")
+	inspectCmdInput = inspectCmd.Arg("keyfile", "Key file to inspect").Required().String()
+	inspectCmdScheme = inspectCmd.Flag("scheme", "Signature scheme of a raw key: schnorr or ed25519").Default("schnorr").Enum("schnorr", "ed25519")
+	inspectCmdSuite = inspectCmd.Flag("suite", "Suite of a raw schnorr key, if its length doesn't say: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
+
+	fingerprintCmd = app.Command("fingerprint", "Print the fingerprint of a key")
+	fingerprintCmdInput = fingerprintCmd.Arg("keyfile", "Public or private key file").Required().String()
+	fingerprintCmdScheme = fingerprintCmd.Flag("scheme", "Signature scheme of a raw key: schnorr or ed25519").Default("schnorr").Enum("schnorr", "ed25519")
+	fingerprintCmdSuite = fingerprintCmd.Flag("suite", "Suite of a raw schnorr key, if its length doesn't say: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
 
 	randomInfCmd = app.Command("raninf", "Generate a random blob of shared information for Partially-Blind")
 	randomInfCmdOutput = randomInfCmd.Arg("output", "Output file path to write").Required().String()
@@ -55,6 +100,51 @@ func createRandomSharedInfoInFile(path string) error {
     return err
 }
 
+/* Splits the host:port,pathtokey arguments used by mkgroup and 
+   mkthreshold. Exits on malformed input, as with the rest of the 
+   argument handling. */
+func parseHostSpecs(items []string) []SchnorrMSHostSpec {
+	var parties []SchnorrMSHostSpec
+
+	for _, item := range items {
+		parts := strings.Split(item, ",")
+		if len(parts) != 2 {
+			fmt.Println("Error invalid argument", item)
+			os.Exit(1)
+		}
+		hostspec := parts[0]
+		pubkeyfile := parts[1]
+
+		host, portspec, err := net.SplitHostPort(hostspec)
+		if err != nil {
+			fmt.Println("Error invalid argument")
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		port, err := strconv.Atoi(portspec)
+		if err != nil {
+			fmt.Println("Error invalid argument")
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		party := SchnorrMSHostSpec{host, port, pubkeyfile}
+		parties = append(parties, party)
+	}
+	return parties
+}
+
+/* Looks up the suite given with --suite, exiting if there is no such
+   suite, as parseHostSpecs does for bad hosts. */
+func parseSuite(name string) abstract.Suite {
+	suite, err := crypto.SuiteByName(name)
+	if err != nil {
+		fmt.Println("Error", err.Error())
+		os.Exit(1)
+	}
+	return suite
+}
+
 /* Entry point to the keytool utility. Switches based on the command line argument structure
    given above.
    Parses all  arguments except os.Args[0], the program name.
@@ -63,31 +153,69 @@ func main() {
 	
 	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
 	case genCmd.FullCommand():
-		runKeyGen(*genCmdOutput)
+		scheme, _ := crypto.ParseSignatureScheme(*genCmdScheme)
+		runKeyGen(*genCmdOutput, scheme, *genCmdSuite, *genCmdEncrypt)
 	case groupCmd.FullCommand():
 
 		var outputfile string = *groupCmdOutput
-		var parties []SchnorrMSHostSpec
-
-		for _, item := range *groupCmdHost {
-			parts := strings.Split(item, ",")
-			hostspec := parts[0]
-			pubkeyfile := parts[1]
-
-			hsparts := strings.Split(hostspec, ":")
-			host := hsparts[0]
-			port, err := strconv.Atoi(hsparts[1])
-			if err != nil {
-				fmt.Println("Error invalid argument")
-				fmt.Println(err.Error())
-				os.Exit(1)
-			}
-
-			party := SchnorrMSHostSpec{host, port, pubkeyfile}
-			parties = append(parties, party)
+		parties := parseHostSpecs(*groupCmdHost)
+
+		err := runMultiSignatureGen(parseSuite(*groupCmdSuite), parties, outputfile)
+		if err != nil {
+			fmt.Println("Error", err.Error())
+			os.Exit(1)
+		}
+	case thresholdCmd.FullCommand():
+
+		var outputfile string = *thresholdCmdOutput
+		parties := parseHostSpecs(*thresholdCmdHost)
+
+		err := runThresholdGen(parseSuite(*thresholdCmdSuite), parties, *thresholdCmdThreshold, outputfile)
+		if err != nil {
+			fmt.Println("Error", err.Error())
+			os.Exit(1)
 		}
+	case dkgCmd.FullCommand():
 
-		runMultiSignatureGen(parties, outputfile)
+		var outputfile string = *dkgCmdOutput
+		parties := parseHostSpecs(*dkgCmdHost)
+
+		tlsConfig, err := transport.ClientTLSConfig(*dkgCmdTLS, *dkgCmdTLSCA, *dkgCmdTLSCert, *dkgCmdTLSKey)
+		var authKey *signer.ClientKey
+		if err == nil {
+			authKey, err = signer.LoadClientKey(*dkgCmdAuthKey, *dkgCmdAuthSuite)
+		}
+		if err == nil {
+			err = runDKG(parseSuite(*dkgCmdSuite), tlsConfig, authKey, parties, *dkgCmdThreshold, outputfile)
+		}
+		if err != nil {
+			fmt.Println("Error", err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("Group configuration written to", outputfile)
+	case convertCmd.FullCommand():
+		scheme, _ := crypto.ParseSignatureScheme(*convertCmdScheme)
+		format, _ := crypto.ParseKeyFileFormat(*convertCmdTo)
+		err := runConvert(*convertCmdInput, *convertCmdOutput, format, scheme, *convertCmdSuite, *convertCmdEncrypt)
+		if err != nil {
+			fmt.Println("Error", err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("Key written to", *convertCmdOutput)
+	case inspectCmd.FullCommand():
+		scheme, _ := crypto.ParseSignatureScheme(*inspectCmdScheme)
+		err := runInspect(*inspectCmdInput, scheme, *inspectCmdSuite)
+		if err != nil {
+			fmt.Println("Error", err.Error())
+			os.Exit(1)
+		}
+	case fingerprintCmd.FullCommand():
+		scheme, _ := crypto.ParseSignatureScheme(*fingerprintCmdScheme)
+		err := runFingerprint(*fingerprintCmdInput, scheme, *fingerprintCmdSuite)
+		if err != nil {
+			fmt.Println("Error", err.Error())
+			os.Exit(1)
+		}
 	case randomInfCmd.FullCommand():
 		var outputfile string = *randomInfCmdOutput
 		err := createRandomSharedInfoInFile(outputfile)
@@ -1,11 +1,10 @@
-
 package main
 
 import (
 	"fmt"
 	"os"
-	"encoding/json"
-	"github.com/dedis/crypto/edwards/ed25519"
+	"strings"
+	"github.com/dedis/crypto/abstract"
 	"vennard.ch/crypto"
 )
 
@@ -15,26 +14,21 @@ type SchnorrMSHostSpec struct {
 	KeyFilePath string
 }
 
-type SchnorrMMember struct {
-	HostName    string
-	Port        int
-	PKey        crypto.SchnorrPublicKey
-}
-
-type SchnorrMGroupConfig struct {
-	JointKey    crypto.SchnorrPublicKey
-	Members     []SchnorrMMember
-}
-
 /* Create a group configuration file. This is really a convenience feature 
    more than anything, making it easier to direct the client than supplying 
-   all the arguments on the command line. */
-func runMultiSignatureGen (group []SchnorrMSHostSpec, outputFile string) error {
+   all the arguments on the command line. 
+   The joint key is always the weighted (rogue-key resistant) aggregate; 
+   legacy sum groups can still be read but are no longer created. 
+   Every key must come with the proof of possession keytool gen wrote 
+   next to it (key.pub -> key.pop), and we refuse keys whose proof 
+   does not check out. All the keys must be in suite, which the 
+   configuration records. */
+func runMultiSignatureGen (suite abstract.Suite, group []SchnorrMSHostSpec, outputFile string) error {
 
-	var config SchnorrMGroupConfig
+	var config crypto.SchnorrMGroupConfig
 	var pkeys []crypto.SchnorrPublicKey
 
-	suite := ed25519.NewAES128SHA256Ed25519(true) 
+	config.Suite = suite.String()
 	for _, mshp :=  range group {
 
 		pkey, err := crypto.SchnorrLoadPubkey(mshp.KeyFilePath, suite)
@@ -43,21 +37,80 @@ func runMultiSignatureGen (group []SchnorrMSHostSpec, outputFile string) error {
 			os.Exit(1)
 		}
 
+		poppath := strings.TrimSuffix(mshp.KeyFilePath, ".pub") + ".pop"
+		proof, err := crypto.SchnorrLoadProof(poppath)
+		if err != nil {
+			return fmt.Errorf("no proof of possession for %s: %s", mshp.KeyFilePath, err.Error())
+		}
+		v, err := crypto.SchnorrVerifyPossession(suite, pkey, proof)
+		if err != nil || v == false {
+			return fmt.Errorf("proof of possession in %s does not verify", poppath)
+		}
+
 		pkeys = append(pkeys, pkey)
 
-		member := SchnorrMMember{mshp.HostName, mshp.Port, pkey}
+		member := crypto.SchnorrMMember{HostName: mshp.HostName, Port: mshp.Port, PKey: pkey, 
+		                              Fingerprint: crypto.SchnorrFingerprint(pkey), PoP: proof}
 		config.Members = append(config.Members, member)
 	}
 
 	jointKey := crypto.SchnorrMComputeSharedPublicKey(suite, pkeys)
 	config.JointKey = jointKey.GetSchnorrPK()
+	config.Aggregation = crypto.SchnorrMAggregationWeighted
+
+	return crypto.SchnorrMSaveGroupConfig(outputFile, config)
+}
+
+/* Create a threshold group. Unlike mkgroup the keys are not made by 
+   each server: we act as the trusted dealer, pick the group secret,
+   split it and write one share per member to KeyFilePath.pri/.pub 
+   for handing out to the servers. The group secret itself is never 
+   written anywhere, but this process did see it. */
+func runThresholdGen (suite abstract.Suite, group []SchnorrMSHostSpec, threshold int, outputFile string) error {
+
+	var config crypto.SchnorrMGroupConfig
+	config.Suite = suite.String()
+
+	groupKey, shares, err := crypto.SchnorrTDealKeys(suite, threshold, len(group))
+	if err != nil {
+		return err
+	}
+
+	for i, mshp :=  range group {
+
+		kpripath := mshp.KeyFilePath + ".pri"
+		kpubpath := mshp.KeyFilePath + ".pub"
+		pkey := crypto.SchnorrExtractPubkey(shares[i])
+
+		err = crypto.SchnorrSaveKeypair(kpripath, suite, shares[i])
+		if err != nil {
+			return err
+		}
+		err = crypto.SchnorrSavePubkey(kpubpath, suite, pkey)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Written share for", mshp.HostName, "to :", kpripath)
 
-    data, _ := json.Marshal(config)
+		// shares get a proof like any other key so the
+		// group file checks out the same way.
+		proof, err := crypto.SchnorrProvePossession(suite, shares[i])
+		if err != nil {
+			return err
+		}
+		err = crypto.SchnorrSaveProof(mshp.KeyFilePath + ".pop", proof)
+		if err != nil {
+			return err
+		}
 
+		member := crypto.SchnorrMMember{HostName: mshp.HostName, Port: mshp.Port, PKey: pkey, 
+		                              Fingerprint: crypto.SchnorrFingerprint(pkey), PoP: proof}
+		config.Members = append(config.Members, member)
+	}
 
-    f, err := os.OpenFile(outputFile, os.O_CREATE | os.O_RDWR, 0644)
-    if err != nil { return err }
-    defer f.Close()
-    _, err = f.Write(data)
-	return err
-}
\ No newline at end of file
+	config.JointKey = groupKey
+	config.Aggregation = crypto.SchnorrMAggregationThreshold
+	config.Threshold = threshold
+
+	return crypto.SchnorrMSaveGroupConfig(outputFile, config)
+}
@@ -5,27 +5,81 @@ package main
 import (
     "fmt"
 	"github.com/dedis/crypto/abstract"
-	"github.com/dedis/crypto/edwards/ed25519"
 	"vennard.ch/crypto"
 )
 
 /* Does excactly what it sounds like - creates and saves a schnorr public/private keypair.
    Much like ssh-keygen, we append .pub to the public key. Unlike ssh-keygen we append .pri 
-   to the private key also. */
-func runKeyGen(kpath string) {
-	suite := ed25519.NewAES128SHA256Ed25519(true) 
-	KeyGen(suite, kpath)
+   to the private key also. A proof of possession for the key goes in .pop, mkgroup
+   wants it alongside the .pub. With encrypt set the .pri is sealed
+   under a passphrase, which we ask for before doing anything else. 
+   Schnorr keys are made in the named suite, see crypto/suites.go. */
+func runKeyGen(kpath string, scheme crypto.SignatureScheme, suiteName string, encrypt bool) {
+	suite, err := crypto.SuiteByName(suiteName)
+	if err != nil {
+		fmt.Println("Error", err.Error())
+		return
+	}
+	var passphrase []byte
+	if encrypt {
+		var err error
+		passphrase, err = crypto.KeyPassphrase(kpath + ".pri", true)
+		if err != nil {
+			fmt.Println("Error", err.Error())
+			return
+		}
+	}
+	if scheme == crypto.SchemeEd25519 {
+		Ed25519KeyGen(kpath, passphrase)
+		return
+	}
+	KeyGen(suite, kpath, passphrase)
 }
 
-/* abstract keygen function. Takes any suite, although later code assumes ED25519 with the 
-   fill curve group */
+/* Same again for RFC 8032 keys. The files are armored; keytool convert
+   --to raw gives the bare 32 byte keys anything else that speaks
+   Ed25519 wants. There is 
+   no proof of possession as these keys can't be put in a group. */
+func Ed25519KeyGen(kpath string, passphrase []byte) {
+	kpubpath := kpath + ".pub"
+	kpripath := kpath + ".pri"
+
+	keypair, err := crypto.Ed25519GenerateKeypair()
+	if err != nil {
+		fmt.Println("Key generation failed")
+		return
+	}
+	var r error
+	if passphrase != nil {
+		r = crypto.Ed25519SaveEncryptedKeypair(kpripath, keypair, passphrase, crypto.DefaultKeyEncryptionParams)
+	} else {
+		r = crypto.Ed25519SaveKeypair(kpripath, keypair)
+	}
+	if r != nil {
+		fmt.Printf("Unable to write to %s\n", kpripath)
+		fmt.Println("Error is")
+		fmt.Println(r.Error())
+		return
+	}
+	r = crypto.Ed25519SavePubkey(kpubpath, keypair.Public)
+	if r != nil {
+		fmt.Printf("Unable to write to %s\n", kpubpath)
+		return
+	}
+	fmt.Println("Written private keypair to : " + kpripath)
+	fmt.Println("Written public key to      : " + kpubpath)
+}
+
+/* abstract keygen function. Takes any suite; the key files record which 
+   one it was. The private key is encrypted if we're given a passphrase. */
 func KeyGen(suite abstract.Suite,
-			kpath string) {
+			kpath string, passphrase []byte) {
 
 	var kpubpath string = kpath
 	var kpripath string = kpath
 	kpubpath = kpubpath + ".pub"
 	kpripath = kpripath + ".pri"
+	kpoppath := kpath + ".pop"
 
 	keypair, err := crypto.SchnorrGenerateKeypair(suite)
 	if err != nil {
@@ -34,7 +88,12 @@ func KeyGen(suite abstract.Suite,
 	}
 	pubkey := crypto.SchnorrExtractPubkey(keypair)
 
-	r := crypto.SchnorrSaveKeypair(kpripath, suite, keypair)
+	var r error
+	if passphrase != nil {
+		r = crypto.SchnorrSaveEncryptedKeypair(kpripath, suite, keypair, passphrase, crypto.DefaultKeyEncryptionParams)
+	} else {
+		r = crypto.SchnorrSaveKeypair(kpripath, suite, keypair)
+	}
 	if r != nil {
 		fmt.Printf("Unable to write to %s\n", kpripath)
 		fmt.Println("Error is")
@@ -46,6 +105,17 @@ func KeyGen(suite abstract.Suite,
 		fmt.Printf("Unable to write to %s\n", kpubpath)
 		return
 	}
+	proof, err := crypto.SchnorrProvePossession(suite, keypair)
+	if err != nil {
+		fmt.Println("Proof of possession failed")
+		return
+	}
+	r = crypto.SchnorrSaveProof(kpoppath, proof)
+	if r != nil {
+		fmt.Printf("Unable to write to %s\n", kpoppath)
+		return
+	}
 	fmt.Println("Written private keypair to : " + kpripath)
 	fmt.Println("Written public key to      : " + kpubpath)
+	fmt.Println("Written key proof to       : " + kpoppath)
 }
\ No newline at end of file
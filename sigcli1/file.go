@@ -0,0 +1,70 @@
+package main
+
+import (
+    "io"
+    "os"
+    "vennard.ch/crypto"
+    "vennard.ch/transport"
+)
+
+// Files are sent in chunks this size, well under the
+// server's maximum message size.
+const fileChunkSize = 64 * 1024
+
+// Sends just the digest of the file and returns it with the signature.
+func signFileHash(conn *transport.Conn, path string, algorithm string) ([]byte, []byte, error) {
+    digest, err := crypto.PrehashFile(algorithm, path)
+    if err != nil {
+        return nil, nil, err
+    }
+    payload := append([]byte{byte(len(algorithm))}, []byte(algorithm)...)
+    payload = append(payload, digest...)
+    err = conn.Send(HASH, payload)
+    if err != nil {
+        return nil, nil, err
+    }
+    signature, err := conn.Expect(SIGNATURE)
+    return digest, signature, err
+}
+
+// Streams the whole file to the server, hashing it on the way
+// so we know what the server should have signed.
+func signFileStream(conn *transport.Conn, path string, algorithm string) ([]byte, []byte, error) {
+    h, err := crypto.NewPrehash(algorithm)
+    if err != nil {
+        return nil, nil, err
+    }
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer f.Close()
+
+    err = conn.Send(FILE_BEGIN, []byte(algorithm))
+    if err != nil {
+        return nil, nil, err
+    }
+    chunk := make([]byte, fileChunkSize)
+    for {
+        n, err := f.Read(chunk)
+        if n > 0 {
+            h.Write(chunk[:n])
+            senderr := conn.Send(FILE_CHUNK, chunk[:n])
+            if senderr != nil {
+                return nil, nil, senderr
+            }
+        }
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, nil, err
+        }
+    }
+    err = conn.Send(FILE_END, nil)
+    if err != nil {
+        return nil, nil, err
+    }
+    signature, err := conn.Expect(SIGNATURE)
+    return h.Sum(nil), signature, err
+}
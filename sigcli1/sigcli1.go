@@ -3,41 +3,144 @@ package main
 
 import (
     "fmt"
-    "net"
     "crypto/rand"
+    "encoding/hex"
     "flag"
-    "github.com/dedis/crypto/edwards/ed25519"
     "vennard.ch/crypto"
+    "vennard.ch/signer"
+    "vennard.ch/transport"
 )
 
+// see signer/sign.go
+const (
+        MESSAGE    byte = 1
+        SIGNATURE  byte = 2
+        HASH       byte = 3
+        FILE_BEGIN byte = 4
+        FILE_CHUNK byte = 5
+        FILE_END   byte = 6
+)
 
 func main() {
 	var port int
 	var hostname string
 	var kfilepath string
+	var schemename string
+	var suitename string
+	var filepath string
+	var hashalgorithm string
+	var hashonly bool
+	var usetls bool
+	var tlsca, tlscert, tlskey string
+	var authkeypath, authsuitename string
 
 	flag.StringVar(&kfilepath, "keyfile", "", "Use the keyfile specified")
 	flag.StringVar(&hostname, "host", "localhost", "Connect to the specified host")
 	flag.IntVar(&port, "port", 1111, "Use the specified port")
+	flag.StringVar(&schemename, "scheme", "schnorr", "Signature scheme: schnorr or ed25519 (RFC 8032)")
+	flag.StringVar(&suitename, "suite", crypto.DefaultSuiteName, "Suite for schnorr keys: ed25519 or p256")
+	flag.StringVar(&filepath, "file", "", "Sign this file and write the signature to <file>.sig, instead of signing random data")
+	flag.StringVar(&hashalgorithm, "hash", "", "Hash algorithm for -file: sha256, sha512, sha3-256 or sha3-512. Defaults to sha3-256, or sha512 for ed25519, the only one it takes")
+	flag.BoolVar(&hashonly, "hashonly", false, "With -file, send only the file's hash rather than the whole file")
+	flag.BoolVar(&usetls, "tls", false, "Connect over TLS, trusting the system's CAs unless -tlsca is given")
+	flag.StringVar(&tlsca, "tlsca", "", "Connect over TLS, trusting only the CAs in this file (PEM)")
+	flag.StringVar(&tlscert, "tlscert", "", "Connect over TLS and present this client certificate (PEM)")
+	flag.StringVar(&tlskey, "tlskey", "", "Private key for -tlscert (PEM)")
+	flag.StringVar(&authkeypath, "authkey", "", "Authenticate to the server with this schnorr key pair")
+	flag.StringVar(&authsuitename, "authsuite", crypto.DefaultSuiteName, "Suite of -authkey: ed25519 or p256")
 	flag.Parse()
 
-    suite := ed25519.NewAES128SHA256Ed25519(true) 
-    pk, err := crypto.SchnorrLoadPubkey(kfilepath, suite)
+    scheme, err := crypto.ParseSignatureScheme(schemename)
     if err != nil {
     	fmt.Println("Error " + err.Error())
     	return
     }
 
-    fmt.Println(pk.Y)
+    if hashalgorithm == "" {
+        hashalgorithm = crypto.DefaultHashAlgorithmFor(scheme)
+    }
+    if scheme == crypto.SchemeEd25519 && hashalgorithm != crypto.Ed25519PrehashAlgorithm {
+    	fmt.Println("Error ed25519 signs files as Ed25519ph, which takes -hash " + crypto.Ed25519PrehashAlgorithm)
+    	return
+    }
+
+    // both kinds of signature are 64 bytes, only checking them differs.
+    // algorithm is empty for a message, otherwise msg is a digest.
+    var verify func(msg []byte, algorithm string, sig []byte) (bool, error)
+    var sigsuite string
+    var serverKey string    // the fingerprint the server has to sign with
+
+    if scheme == crypto.SchemeEd25519 {
+        pk, err := crypto.Ed25519LoadPubkey(kfilepath)
+        if err != nil {
+        	fmt.Println("Error " + err.Error())
+        	return
+        }
+        fmt.Println(hex.EncodeToString(pk))
+        serverKey = crypto.Ed25519Fingerprint(pk)
+        verify = func(msg []byte, algorithm string, sig []byte) (bool, error) {
+            if algorithm != "" {
+                return crypto.Ed25519VerifyPrehash(pk, algorithm, msg, sig)
+            }
+            return crypto.Ed25519Verify(pk, msg, sig)
+        }
+    } else {
+        suite, err := crypto.SuiteByName(suitename)
+        if err != nil {
+        	fmt.Println("Error " + err.Error())
+        	return
+        }
+        pk, err := crypto.SchnorrLoadPubkey(kfilepath, suite)
+        if err != nil {
+        	fmt.Println("Error " + err.Error())
+        	return
+        }
+        fmt.Println(pk.Y)
+        sigsuite = suite.String()
+        serverKey = crypto.SchnorrFingerprint(pk)
+        verify = func(msg []byte, algorithm string, sig []byte) (bool, error) {
+            signed := crypto.PlainMessage(msg)
+            if algorithm != "" {
+                var err error
+                signed, err = crypto.PrehashMessage(algorithm, msg)
+                if err != nil {
+                    return false, err
+                }
+            }
+            return crypto.SchnorrVerify(suite, pk, signed, sig)
+        }
+    }
+
+    tlsConfig, err := transport.ClientTLSConfig(usetls, tlsca, tlscert, tlskey)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    authKey, err := signer.LoadClientKey(authkeypath, authsuitename)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
 
     var hostspec string
     hostspec = fmt.Sprintf("%s:%d", hostname, port)
-    fmt.Println("Connecting to %s\n", hostspec)
-    conn, err := net.Dial("tcp", hostspec)
+    fmt.Printf("Connecting to %s\n", hostspec)
+    conn, err := transport.DialProtocol(hostspec, transport.ProtocolSign, tlsConfig)
     if err != nil {
     	fmt.Println(err.Error())
     	return
     }
+    defer conn.Close()
+    err = signer.Authenticate(conn, authKey, serverKey)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+
+    if filepath != "" {
+        signFile(conn, filepath, hashalgorithm, hashonly, scheme, sigsuite, verify)
+        return
+    }
 
    	randomdata := make([]byte, 1024)
     _, err = rand.Read(randomdata)
@@ -46,15 +149,17 @@ func main() {
     	return
     }
 
-    buffer := make([]byte, 64)
-
-    conn.Write(randomdata)
-    _, err = conn.Read(buffer)
+    err = conn.Send(MESSAGE, randomdata)
+    if err != nil {
+    	fmt.Println(err.Error())
+    	return
+    }
+    buffer, err := conn.Expect(SIGNATURE)
     if err != nil {
     	fmt.Println(err.Error())
     	return
     } 
-    v, err := crypto.SchnorrVerify(suite, pk, randomdata, buffer)
+    v, err := verify(randomdata, "", buffer)
     if err != nil {
     	fmt.Println(err.Error())
     	return
@@ -66,4 +171,47 @@ func main() {
     }
 
     return
-}
\ No newline at end of file
+}
+
+// Gets the file signed, checks the signature and writes it
+// out as <file>.sig. suite is empty for RFC 8032 signatures.
+func signFile(conn *transport.Conn, path string, algorithm string, hashonly bool, 
+              scheme crypto.SignatureScheme, suite string, 
+              verify func(msg []byte, algorithm string, sig []byte) (bool, error)) {
+
+    var digest, signature []byte
+    var err error
+    if hashonly {
+        digest, signature, err = signFileHash(conn, path, algorithm)
+    } else {
+        digest, signature, err = signFileStream(conn, path, algorithm)
+    }
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+
+    v, err := verify(digest, algorithm, signature)
+    if err != nil {
+    	fmt.Println(err.Error())
+    	return
+    }
+    if v == false {
+    	fmt.Println("Signature verify FAILED, not writing it")
+    	return
+    }
+
+    sigpath := crypto.DetachedSignaturePath(path)
+    err = crypto.SaveDetachedSignature(sigpath, crypto.DetachedSignature{
+        Scheme:        scheme,
+        Suite:         suite,
+        HashAlgorithm: algorithm,
+        Digest:        digest,
+        Signature:     signature,
+    })
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    fmt.Println("Signature verified OK, written to", sigpath)
+}
@@ -1,262 +1,397 @@
-
 package main
 
 import (
     "bytes"
 	"crypto/rand"
-	"encoding/json"
-	"io/ioutil"
-	"os"
+	"crypto/tls"
+    "encoding/hex"
     "net"
+    "sort"
+    "strconv"
 	"fmt"
     "github.com/dedis/crypto/abstract"
-    "github.com/dedis/crypto/edwards/ed25519"
 	"vennard.ch/crypto"
+    "vennard.ch/signer"
+    "vennard.ch/transport"
 )
 
-type SchnorrMMember struct {
-	HostName    string
-	Port        int
-	PKey        crypto.SchnorrPublicKey
-}
-
-type SchnorrMGroupConfig struct {
-	JointKey    crypto.SchnorrPublicKey
-	Members     []SchnorrMMember
-}
-
-
+// see signer/cosign.go for what each round carries.
 const (
         MESSAGE byte = 1
-        COMMITMENT byte = 2
+        REVEAL  byte = 2
+        COMMITMENT byte = 3
 )
 
+// Returned by runClientProtocol when one or more cosigners sent a
+// response that does not verify. Members holds their indices in 
+// the group configuration.
+type cosignerFault struct {
+    Members       []int
+}
+
+func (e cosignerFault) Error() string {
+    return fmt.Sprintf("invalid responses from members %v", e.Members)
+}
+
 type controllerMessage struct {
     MemberIndex   int
+    Round         byte
     Message       [] byte    // if we don't keep this generic type enforcement 
                              // will stop us using a single channel.
+    Err           error      // set instead of Message if the server failed
 }
 
-func serverComms (gconfig SchnorrMGroupConfig, i int, msg []byte, reportChan chan controllerMessage, syncChan chan []byte) {
+func serverComms (gconfig crypto.SchnorrMGroupConfig, tlsConfig *tls.Config, authKey *signer.ClientKey, i int, msg []byte, reportChan chan controllerMessage, syncChan chan []byte) {
 
     config := gconfig.Members[i]
 
-	firstMessage := []byte{MESSAGE, 0}
-	firstMessage = append(firstMessage, msg...)
-
-	hostspec := fmt.Sprintf("%s:%d", config.HostName, config.Port)
+	hostspec := net.JoinHostPort(config.HostName, strconv.Itoa(config.Port))
 
     fmt.Println("CLIENT", i, "ServerComm: taling to ", hostspec)
 
-	conn, err := net.Dial("tcp", hostspec)
-    if err != nil {
-    	fmt.Println(err.Error())
-    	return
+	conn, err := transport.DialProtocol(hostspec, transport.ProtocolMultisig, tlsConfig)
+    if err == nil {
+        err = signer.Authenticate(conn, authKey, crypto.SchnorrFingerprint(config.PKey))
+        defer conn.Close()
     }
-
-    buffer_commit := make([]byte, 1024)
-
-    fmt.Println("CLIENT", i, "Sending message")
-
-    conn.Write(firstMessage)
-    _, err = conn.Read(buffer_commit)
     if err != nil {
     	fmt.Println(err.Error())
+        reportChan <- controllerMessage{i, MESSAGE, nil, err}
     	return
-    } 
-    fmt.Println("CLIENT", i, "Response received, reporting to controller")
-
-    // we now need to wait for the next step in the process.
-
-    reportMsg := controllerMessage{i, buffer_commit}
-    reportChan <- reportMsg // send back to runClientProtocol
+    }
 
-    // now we'll use channel's by default blocking as a synchronisation
+    // each round is the same: send our payload, read the reply,
+    // hand it to the controller and wait for the next payload. 
+    // I'm using channel's by default blocking as a synchronisation
     // mechamism. Essentially I'm implementing message passing
-    // here.
+    // here. A nil payload means we were not picked to sign.
+    payload := msg
 
-    fmt.Println("CLIENT", i, "Get aggregateBytes")
+    for _, state := range []byte{MESSAGE, REVEAL, COMMITMENT} {
 
-    var aggregateCommitmentBytes []byte
-    aggregateCommitmentBytes = <- syncChan
+        fmt.Println("CLIENT", i, "Sending round", state)
 
-    fmt.Println("CLIENT", i, "Got aggregateCommitmentBytes")
+        err = conn.Send(state, payload)
+        if err != nil {
+            fmt.Println("CLIENT", i, "Error sending to server")
+            reportChan <- controllerMessage{i, state, nil, err}
+            return
+        }
+        buffer, err := conn.Expect(state)
+        if err != nil {
+            fmt.Println("CLIENT", i, "Error getting response from server")
+        	fmt.Println(err.Error())
+            reportChan <- controllerMessage{i, state, nil, err}
+        	return
+        } 
+        fmt.Println("CLIENT", i, "Response received, reporting to controller")
+
+        reportMsg := controllerMessage{i, state, buffer, nil}
+        reportChan <- reportMsg // send back to runClientProtocol
+
+        if state == COMMITMENT {
+            break
+        }
+        payload = <- syncChan
+        if payload == nil {
+            fmt.Println("CLIENT", i, "Not needed for this signature, exiting.")
+            return
+        }
+    }
 
-    // now we have our aggregate commitment, we need to send this
-    // to the server also.
+    fmt.Println("CLIENT", i, "Done, exiting.")
+    return
+}
 
-    buffer_response := make([]byte, 1026)
-    secondMessage := []byte{COMMITMENT, 0}
+// Waits for the first round replies until threshold members have
+// answered, and returns those members (sorted) and their replies.
+// Members that could not be reached are skipped; it is only an 
+// error if too few are left to reach the threshold.
+func collectFirstRound (reportChan chan controllerMessage, n int, threshold int) ([]int, map[int][]byte, error) {
 
-    secondMessage = append(secondMessage, aggregateCommitmentBytes...)
+    messages := make(map[int][]byte)
+    var signers []int
 
-    fmt.Println("CLIENT", i, "Sending aggregate commitment back to server.")
+    for reported := 0; reported < n && len(signers) < threshold; reported++ {
+        msg := <- reportChan
+        if msg.Err != nil {
+            fmt.Println("CLIENT", "C", "Member", msg.MemberIndex, "unavailable:", msg.Err.Error())
+            continue
+        }
+        fmt.Println("CLIENT", "C", "Controller got message index", msg.MemberIndex)
+        messages[msg.MemberIndex] = msg.Message
+        signers = append(signers, msg.MemberIndex)
+    }
 
-    conn.Write(secondMessage)
-    _, err = conn.Read(buffer_response)
-    if err != nil {
-        fmt.Println("CLIENT", i, "Error getting response from server")
-    	fmt.Println(err.Error())
-    	return
+    if len(signers) < threshold {
+        return nil, nil, fmt.Errorf("only %d of the %d members needed responded", len(signers), threshold)
     }
+    sort.Ints(signers)
+    return signers, messages, nil
+}
 
-    fmt.Println("CLIENT", i, "Reporting response response to the controller, then exiting.")
-    // report the outcome of the server response
-    reportMsg = controllerMessage{i, buffer_response}
-    reportChan <- reportMsg // send back to runClientProtocol
+// Waits for one message for the given round from each of the signers 
+// and returns them ordered as signers is. Anything else (late first round
+// replies from members we did not pick) is dropped. Once the signing set
+// is fixed every one of them has to see it through.
+func collectRound (reportChan chan controllerMessage, round byte, signers []int) ([][]byte, error) {
 
-    // and then exit
-    conn.Close()
-    return
+    position := make(map[int]int)
+    for k, i := range signers {
+        position[i] = k
+    }
+
+    messages := make([][]byte, len(signers))
+    for respCount := 0; respCount < len(signers); {
+        msg := <- reportChan
+        k, isSigner := position[msg.MemberIndex]
+        if !isSigner || msg.Round != round {
+            continue
+        }
+        if msg.Err != nil {
+            return nil, fmt.Errorf("member %d failed during signing: %s", msg.MemberIndex, msg.Err.Error())
+        }
+        fmt.Println("CLIENT", "C", "Controller got message index", msg.MemberIndex)
+        messages[k] = msg.Message
+        respCount++
+    }
+    return messages, nil
 }
 
+// Sends the same payload to the given workers.
+func broadcastRound (syncChans [] chan []byte, members []int, payload []byte) {
+    for _, i := range(members) {
+        syncChans[i] <- payload
+    }
+}
 
-func runClientProtocol (configFilePath string) (bool, error) {
+func runClientProtocol (configFilePath string, tlsConfig *tls.Config, authKey *signer.ClientKey, filePath string, 
+                        outputPath string, outputFormat crypto.SignedMessageFormat) (bool, error) {
 
 	// first stage, let's retrieve everything from
 	// the configuration file that the client needs 
 
-	var config SchnorrMGroupConfig
-
-    suite :=  ed25519.NewAES128SHA256Ed25519(true)
-
-	fcontents, err := ioutil.ReadFile(configFilePath)
+	config, err := crypto.SchnorrMLoadGroupConfig(configFilePath)
     if err != nil {
-    	fmt.Println("Error reading file")
-        fmt.Println(err.Error())
-        os.Exit(1)
+        return false, fmt.Errorf("reading group configuration: %s", err.Error())
     }
-
-    err = json.Unmarshal(fcontents, &config)
+    // the group's keys say which suite we are in
+    suite, err := config.GetSuite()
     if err != nil {
-    	fmt.Println("Error unmarshalling")
-        fmt.Println(err.Error())
-        os.Exit(1)
+        return false, err
     }
-    
-    // and now, for our next trick, a random 1KB blob
 
-    randomdata := make([]byte, 1024)
-    _, err = rand.Read(randomdata)
-    if err != nil {
-        fmt.Println(err.Error())
-    	return false, err
+    // a key without a valid proof of possession may be a rogue 
+    // key made up from the others, so don't sign with that group.
+    bad := config.VerifyProofs(suite)
+    if bad >= 0 {
+        fmt.Println("CLIENT", "C", "Member", bad, "has no valid proof of possession for its key")
+        return false, fmt.Errorf("member %d proof of possession does not verify", bad)
+    }
+    
+    // and now, for our next trick, a random 1KB blob. Or if we 
+    // were given a file, what sigserv1 would sign for it: the 
+    // encoded hash, so that sigtool can check it later.
+
+    var randomdata []byte
+    var digest []byte
+    if filePath != "" {
+        digest, err = crypto.PrehashFile(crypto.DefaultHashAlgorithm, filePath)
+        if err != nil {
+            return false, err
+        }
+        randomdata, err = crypto.PrehashMessage(crypto.DefaultHashAlgorithm, digest)
+        if err != nil {
+            return false, err
+        }
+    } else {
+        randomdata = make([]byte, 1024)
+        _, err = rand.Read(randomdata)
+        if err != nil {
+            fmt.Println(err.Error())
+            return false, err
+        }
     }
 
-    reportChan := make(chan controllerMessage)
+    n := len(config.Members)
+
+    // the channels are buffered so that workers we end up not 
+    // needing (or that already gave up) never block us, or we them.
+    reportChan := make(chan controllerMessage, 3*n)
 
     var syncChans [] chan []byte
 
     for i, _ := range config.Members {
 
-        syncChan := make(chan []byte)
+        syncChan := make(chan []byte, 1)
         syncChans = append(syncChans, syncChan)
         fmt.Println("CLIENT", "C", "Launching goroutine worker")
 
-    	go serverComms(config, i, randomdata, reportChan, syncChan)
+    	go serverComms(config, tlsConfig, authKey, i, randomdata, reportChan, syncChan)
     }
 
-    var respCount int = 0
-    commitmentArray := make([]crypto.SchnorrMPublicCommitment, len(config.Members), len(config.Members))
-
-    fmt.Println("CLIENT", "C", "Controller getting ready to receive")
-
-    for {
+    // round one: everyone commits to a hash of their T. We go 
+    // ahead with the first Threshold members to answer.
 
-    	select {
-		case msg := <- reportChan:
+    fmt.Println("CLIENT", "C", "Controller waiting for", config.Threshold, "commitment hashes")
 
-			// we should probably check all our client threads have responded 
-			// once and only once, but we won't
-
-		    buf := bytes.NewBuffer(msg.Message)
-    		commitment := crypto.SchnorrMPublicCommitment{}
-    		err := abstract.Read(buf, &commitment, suite);
-    		if err != nil {
-                fmt.Println("CLIENT", "Read Error")
-                fmt.Println(err.Error())
-        		return false, err
-    		}
+    signers, firstReplies, err := collectFirstRound(reportChan, n, config.Threshold)
+    if err != nil {
+        return false, err
+    }
+    fmt.Println("CLIENT", "C", "Signing with members", signers)
 
-    		// we have our abstract point.
-			// let's go
-            fmt.Println("CLIENT", "C", "Controller got message index", msg.MemberIndex)
-            commitmentArray[msg.MemberIndex] = commitment
+    var others []int
+    for i := range config.Members {
+        if _, picked := firstReplies[i]; !picked {
+            others = append(others, i)
+        }
+    }
+    broadcastRound(syncChans, others, nil)
+
+    hashArray := make([]crypto.SchnorrMCommitmentHash, len(signers))
+    for k, i := range signers {
+        err := abstract.Read(bytes.NewBuffer(firstReplies[i]), &hashArray[k], suite)
+        if err != nil {
+            fmt.Println("CLIENT", "Read Error")
+            fmt.Println(err.Error())
+            return false, err
+        }
+    }
 
-            respCount = respCount + 1
+    // round two: now that every hash is in, ask for the T's.
 
-			
-		default:
-		}
+    fmt.Println("CLIENT", "C", "Sending commitment hashes back to workers")
+    broadcastRound(syncChans, signers, crypto.SchnorrMEncodeCommitmentHashes(suite, signers, hashArray))
 
-		if respCount == len(config.Members) {
-            // reset and break
-            respCount = 0
-			break
-		}
+    replies, err := collectRound(reportChan, REVEAL, signers)
+    if err != nil {
+        return false, err
+    }
+    commitmentArray := make([]crypto.SchnorrMPublicCommitment, len(signers))
+    for k, data := range replies {
+        err := abstract.Read(bytes.NewBuffer(data), &commitmentArray[k], suite)
+        if err != nil {
+            fmt.Println("CLIENT", "Read Error")
+            fmt.Println(err.Error())
+            return false, err
+        }
     }
 
-    fmt.Println("CLIENT", "C", "Controller received all responses, preparing to aggregate")
+    // the servers check this too, but there's no point
+    // going on if we can already see someone cheated.
+    bad, err = crypto.SchnorrMVerifyRevealedCommitments(suite, commitmentArray, hashArray)
+    if err != nil {
+        return false, err
+    }
+    if bad >= 0 {
+        fmt.Println("CLIENT", "C", "Member", signers[bad], "revealed a commitment that does not match its hash")
+        return false, fmt.Errorf("member %d commitment does not match its hash", signers[bad])
+    }
 
+    fmt.Println("CLIENT", "C", "Controller received all commitments, preparing to aggregate")
 
     // sum the points 
     aggregateCommmitment := crypto.SchnorrMComputeAggregateCommitment (suite, commitmentArray)
-    collectiveChallenge := crypto.SchnorrMComputeCollectiveChallenge(suite, randomdata, aggregateCommmitment)
-    
+    collectiveChallenge := crypto.SchnorrMComputeCollectiveChallenge(suite, config.JointKey, randomdata, nil, aggregateCommmitment)
 
-    bAggregateCommmitment := bytes.Buffer{} 
-    abstract.Write(&bAggregateCommmitment, &aggregateCommmitment, suite)
+    // round three: send everyone's T so each server can check them
+    // against the hashes and compute the challenge themselves.
 
-    // report 
-    for _, ch := range(syncChans) {
-        fmt.Println("CLIENT", "C", "Sending aggcommitbytes back to workers")
-        ch <- bAggregateCommmitment.Bytes()
-    }
+    fmt.Println("CLIENT", "C", "Sending commitments back to workers")
+    broadcastRound(syncChans, signers, crypto.SchnorrMEncodePublicCommitments(suite, commitmentArray))
 
     // now wait for the server responses, aggregate them and compute
     // a signature from the combined servers.
 
-    fmt.Println("CLIENT", "C", "Controller getting ready to receive")
-
-
-    responseArray := make([]crypto.SchnorrMResponse, len(config.Members), len(config.Members))
-
-    for {
-
-        select {
-        case msg := <- reportChan:
-
-            // we should probably check all our client threads have responded 
-            // once and only once, but we won't
-
-            buf := bytes.NewBuffer(msg.Message)
-            response := crypto.SchnorrMResponse{}
-            err := abstract.Read(buf, &response, suite);
-            if err != nil {
-                return false, err
-            }
-
-            fmt.Println("CLIENT", "C", "Received from", msg.MemberIndex)
-
-            // we have our abstract point.
-            // let's go
-            responseArray[msg.MemberIndex] = response
-
-            respCount = respCount + 1
-            fmt.Println("CLIENT", "C", "Received responses", respCount)
+    replies, err = collectRound(reportChan, COMMITMENT, signers)
+    if err != nil {
+        return false, err
+    }
+    responseArray := make([]crypto.SchnorrMResponse, len(signers))
+    for k, data := range replies {
+        err := abstract.Read(bytes.NewBuffer(data), &responseArray[k], suite)
+        if err != nil {
+            return false, err
+        }
+    }
 
-        default:
+    // check each cosigner's contribution on its own so that we can
+    // say who is at fault rather than just ending up with a bad signature.
+    pkeys := make([]crypto.SchnorrPublicKey, len(signers))
+    coefficients := make([]abstract.Secret, len(signers))
+    for k, i := range signers {
+        pkeys[k] = config.Members[i].PKey
+        coefficients[k], err = config.Coefficient(suite, i, signers)
+        if err != nil {
+            return false, err
         }
+    }
 
-        if respCount == len(config.Members) {
-            break
+    badSigners, err := crypto.SchnorrMVerifyResponses(suite, pkeys, coefficients, 
+                                                      commitmentArray, collectiveChallenge, responseArray)
+    if err != nil {
+        return false, err
+    }
+    if len(badSigners) > 0 {
+        var badMembers []int
+        for _, k := range badSigners {
+            i := signers[k]
+            member := config.Members[i]
+            pkey, _ := member.PKey.Y.MarshalBinary()
+            fmt.Println("CLIENT", "C", "Invalid response from member", i,
+                        "host", net.JoinHostPort(member.HostName, strconv.Itoa(member.Port)),
+                        "key", hex.EncodeToString(pkey))
+            badMembers = append(badMembers, i)
         }
+        return false, cosignerFault{badMembers}
     }
 
     sig := crypto.SchnorrMComputeSignatureFromResponses(suite, collectiveChallenge, responseArray)
 
+    bsig := bytes.Buffer{} 
+    abstract.Write(&bsig, &sig, suite)
+
     fmt.Println("Signature created, is")
-    fmt.Println(sig)
+    fmt.Println(hex.EncodeToString(bsig.Bytes()))
+    verified, err := crypto.SchnorrVerify(suite, config.JointKey, randomdata, bsig.Bytes())
+    if err != nil {
+        return false, err
+    }
+    if verified == false {
+        fmt.Println("CLIENT", "C", "Signature does not verify against the group key")
+        return false, nil
+    }
+    fmt.Println("Signature verified OK against the group key")
+
+    if outputPath != "" {
+        err = crypto.SaveSignedMessage(outputPath, crypto.SignedMessage{
+            Kind:      crypto.SignedSchnorr,
+            Suite:     suite.String(),
+            PublicKey: config.JointKey,
+            Message:   randomdata,
+            Signature: bsig.Bytes(),
+        }, outputFormat)
+        if err != nil {
+            return false, err
+        }
+        fmt.Println("Signed message written to", outputPath)
+    }
+
+    if filePath != "" {
+        sigpath := crypto.DetachedSignaturePath(filePath)
+        err = crypto.SaveDetachedSignature(sigpath, crypto.DetachedSignature{
+            Scheme:        crypto.SchemeSchnorr,
+            Suite:         suite.String(),
+            HashAlgorithm: crypto.DefaultHashAlgorithm,
+            Digest:        digest,
+            Signature:     bsig.Bytes(),
+        })
+        if err != nil {
+            return false, err
+        }
+        fmt.Println("Signature written to", sigpath)
+    }
 
     return true, nil
-}
\ No newline at end of file
+}
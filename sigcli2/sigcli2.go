@@ -3,10 +3,13 @@ package main
 
 import (
 //    "crypto/rand"
+    "fmt"
     "os"
     kingpin "gopkg.in/alecthomas/kingpin.v2"
 //    "github.com/dedis/crypto/edwards/ed25519"
-//    "vennard.ch/crypto"
+    "vennard.ch/crypto"
+    "vennard.ch/signer"
+    "vennard.ch/transport"
 )
 
 // because kingpin worked so nicely in the keytool, let's use it again:
@@ -14,10 +17,49 @@ import (
 var (
     app = kingpin.New("sigcli2", "Command line client for multisignature schnorr")
     configFile = app.Arg("config", "Read the group configuration from this file").Required().String()
+    outputFile = app.Flag("output", "Save the message, signature and group key here").String()
+    outputFormat = app.Flag("format", "Format for --output: binary, hex or json").Default("json").Enum("binary", "hex", "json")
+    signFile = app.Flag("file", "Have the group sign this file and write the signature to <file>.sig, instead of signing random data").String()
+    useTLS = app.Flag("tls", "Connect to the members over TLS, trusting the system's CAs unless --tlsca is given").Bool()
+    tlsCA = app.Flag("tlsca", "Connect over TLS, trusting only the CAs in this file (PEM)").String()
+    tlsCert = app.Flag("tlscert", "Connect over TLS and present this client certificate (PEM)").String()
+    tlsKey = app.Flag("tlskey", "Private key for --tlscert (PEM)").String()
+    authKeyFile = app.Flag("authkey", "Authenticate to the members with this schnorr key pair").String()
+    authSuite = app.Flag("authsuite", "Suite of --authkey: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
+)
+
+// Exit codes, so that scripts can tell a misbehaving cosigner
+// apart from everything else that can go wrong.
+const (
+    exitOK          = 0
+    exitFailure     = 1
+    exitBadCosigner = 3
 )
 
 func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 
-    runClientProtocol(*configFile)
+    tlsConfig, err := transport.ClientTLSConfig(*useTLS, *tlsCA, *tlsCert, *tlsKey)
+    if err != nil {
+        fmt.Println("Error", err.Error())
+        os.Exit(exitFailure)
+    }
+    authKey, err := signer.LoadClientKey(*authKeyFile, *authSuite)
+    if err != nil {
+        fmt.Println("Error", err.Error())
+        os.Exit(exitFailure)
+    }
+
+    ok, err := runClientProtocol(*configFile, tlsConfig, authKey, *signFile, *outputFile, crypto.SignedMessageFormat(*outputFormat))
+    if err != nil {
+        fmt.Println("Error", err.Error())
+        if _, isFault := err.(cosignerFault); isFault {
+            os.Exit(exitBadCosigner)
+        }
+        os.Exit(exitFailure)
+    }
+    if !ok {
+        os.Exit(exitFailure)
+    }
+    os.Exit(exitOK)
 }
\ No newline at end of file
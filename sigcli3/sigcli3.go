@@ -4,13 +4,14 @@ package main
 import (
     "bytes"
     "crypto/rand"
+    "encoding/hex"
     "io/ioutil"
     "os"
     "fmt"
-    "net"
     "github.com/dedis/crypto/abstract"
-    "github.com/dedis/crypto/edwards/ed25519"
     "vennard.ch/crypto"
+    "vennard.ch/signer"
+    "vennard.ch/transport"
     kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -20,6 +21,22 @@ var (
     appPrivatekeyfile = app.Arg("privatekey", "Path to schnorr public key").Required().String()
     appInfo = app.Arg("info", "Output file path to write (appends .pub, .pri)").Required().String()
     appHostspec = app.Arg("host", "Listen on port").Required().String()
+    appOutput = app.Flag("output", "Save the message, signature, key and info here").String()
+    appFormat = app.Flag("format", "Format for --output: binary, hex or json").Default("json").Enum("binary", "hex", "json")
+    appSuite = app.Flag("suite", "Suite the key is in: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
+    appTLS = app.Flag("tls", "Connect over TLS, trusting the system's CAs unless --tlsca is given").Bool()
+    appTLSCA = app.Flag("tlsca", "Connect over TLS, trusting only the CAs in this file (PEM)").String()
+    appTLSCert = app.Flag("tlscert", "Connect over TLS and present this client certificate (PEM)").String()
+    appTLSKey = app.Flag("tlskey", "Private key for --tlscert (PEM)").String()
+    appAuthKey = app.Flag("authkey", "Authenticate to the server with this schnorr key pair").String()
+    appAuthSuite = app.Flag("authsuite", "Suite of --authkey: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
+)
+
+// see signer/blind.go
+const (
+        PARAMS    byte = 1
+        CHALLENGE byte = 2
+        RESPONSE  byte = 3
 )
 
 /* this function loads the random binary blob used as the 
@@ -44,7 +61,21 @@ func main() {
     var kinfopath string = *appInfo
     var hostspec string = *appHostspec
 
-    suite := ed25519.NewAES128SHA256Ed25519(true) 
+    suite, err := crypto.SuiteByName(*appSuite)
+    if err != nil {
+        fmt.Println("CLIENT", "Error " + err.Error())
+        return
+    }
+    tlsConfig, err := transport.ClientTLSConfig(*appTLS, *appTLSCA, *appTLSCert, *appTLSKey)
+    if err != nil {
+        fmt.Println("CLIENT", "Error " + err.Error())
+        return
+    }
+    authKey, err := signer.LoadClientKey(*appAuthKey, *appAuthSuite)
+    if err != nil {
+        fmt.Println("CLIENT", "Error " + err.Error())
+        return
+    }
 
     fmt.Println("CLIENT", "Connecting to", hostspec)
 
@@ -68,17 +99,21 @@ func main() {
         return
     }
 
-    conn, err := net.Dial("tcp", hostspec)
+    conn, err := transport.DialProtocol(hostspec, transport.ProtocolBlind, tlsConfig)
     if err != nil {
     	fmt.Println("CLIENT", "Error connecting to server", err.Error())
     	return
     }
     defer conn.Close()
+    err = signer.Authenticate(conn, authKey, crypto.SchnorrFingerprint(pubKey))
+    if err != nil {
+    	fmt.Println("CLIENT", "Error authenticating to server", err.Error())
+    	return
+    }
 
     // first up, let's receive the signer's parameter set
 
-    buffer := make([]byte, 1026)
-    _,err = conn.Read(buffer)
+    buffer, err := conn.Expect(PARAMS)
     if err != nil {
         fmt.Println("CLIENT", "Error reading from server", err.Error())
         return
@@ -87,9 +122,13 @@ func main() {
     var userPublicParams crypto.WISchnorrPublicParams
     decodeBuffer := bytes.NewBuffer(buffer)
     err = abstract.Read(decodeBuffer, &userPublicParams, suite)
+    if err != nil {
+        fmt.Println("CLIENT", "Error reading parameters", err.Error())
+        return
+    }
 
     // now we've got that, complete the challenge phase (i.e. let's generate E)
-    challenge, userPrivateParams, err := crypto.ClientGenerateChallenge(suite, userPublicParams, pubKey, info, message)
+    challenge, userPrivateParams, err := crypto.ClientGenerateChallenge(suite, userPublicParams, pubKey, info, message, nil)
     if err != nil {
         fmt.Println("CLIENT", "Error generating challenge", err.Error())
         return
@@ -98,11 +137,14 @@ func main() {
     // encode and send to server.
     challengebuffer := bytes.Buffer{} 
     abstract.Write(&challengebuffer, &challenge, suite)
-    conn.Write(challengebuffer.Bytes())
+    err = conn.Send(CHALLENGE, challengebuffer.Bytes())
+    if err != nil {
+        fmt.Println("CLIENT", "Error sending challenge", err.Error())
+        return
+    }
 
     // and now we wait for the server to respond to this:
-    secondread := make([]byte, 1024)
-    _,err = conn.Read(secondread)
+    secondread, err := conn.Expect(RESPONSE)
     if err != nil {
         fmt.Println("CLIENT", "Error reading from server", err.Error())
         return
@@ -119,7 +161,7 @@ func main() {
     // we've got the response message, time to sign and check.
 
     // finally, we can sign the message and check it verifies.
-    sig, worked := crypto.ClientSignBlindly(suite, userPrivateParams, responseMessage, pubKey, message)
+    sig, worked := crypto.ClientSignBlindly(suite, userPrivateParams, responseMessage, pubKey, message, nil)
 
     //fmt.Println(blindSignature)
 
@@ -130,7 +172,7 @@ func main() {
 
 
     // now verify this worked fine.
-    result, err := crypto.VerifyBlindSignature(suite, pubKey, sig, info, message)
+    result, err := crypto.VerifyBlindSignature(suite, pubKey, sig, info, message, nil)
 
     if err != nil {
         fmt.Println("CLIENT", "Error handling signature verification", err.Error())
@@ -141,8 +183,17 @@ func main() {
         return
     }
 
-    fmt.Println("CLIENT", "Signature OK -", sig)
+    signed := crypto.NewBlindSignedMessage(suite, pubKey, sig, info, message, nil)
+    fmt.Println("CLIENT", "Signature OK -", hex.EncodeToString(signed.Signature))
 
+    if *appOutput != "" {
+        err = crypto.SaveSignedMessage(*appOutput, signed, crypto.SignedMessageFormat(*appFormat))
+        if err != nil {
+            fmt.Println("CLIENT", "Error saving signature", err.Error())
+            return
+        }
+        fmt.Println("CLIENT", "Signed message written to", *appOutput)
+    }
 
     return
 }
\ No newline at end of file
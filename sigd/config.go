@@ -0,0 +1,238 @@
+package main
+
+import (
+    "crypto/tls"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "path/filepath"
+    "time"
+    "vennard.ch/crypto"
+    "vennard.ch/signer"
+    "vennard.ch/transport"
+)
+
+/* The sigd configuration file. Each protocol is served if and only if
+   its section is present, with the same settings as the matching
+   sigservN flags:
+
+       {
+           "Port": 1111,
+           "Timeout": "30s",
+           "SessionTimeout": "5m",
+           "MaxSessions": 64,
+           "Sign":     { "Scheme": "schnorr", "Suite": "ed25519", "KeyFile": "server.pri" },
+           "Multisig": { "KeyFile": "member.pri", "Group": "group.json" },
+           "Blind":    { "KeyFile": "blind.pri", "Info": "shared.inf" },
+           "TLS":      { "CertFile": "sigd.crt", "KeyFile": "sigd.key", "ClientCAFile": "clients.crt" },
+           "Policy":   "policy.json",
+           "Audit":    "audit.log",
+           "Log":      { "Format": "json", "Level": "info" }
+       }
+
+   Without a TLS section we serve plain TCP, and without a Policy (see
+   signer/policy.go) anyone who can connect may use every key. Timeout
+   is how long a client gets for each message, one minute if not given,
+   SessionTimeout how long for the whole session, ten minutes if not
+   given, and MaxSessions how many clients are served at once, any
+   number if not given. Every signature issued or refused is appended
+   to the Audit file, if there is one (see signer/audit.go). Log is as
+   the -logformat and -loglevel flags of the sigservN, text at info if
+   not given. Relative paths are taken from the directory the file is
+   in. */
+type Config struct {
+    Port            int
+    MaxMessageSize  uint32          `json:",omitempty"`
+    Timeout         string          `json:",omitempty"`
+    SessionTimeout  string          `json:",omitempty"`
+    MaxSessions     int             `json:",omitempty"`
+    Sign            *SignConfig     `json:",omitempty"`
+    Multisig        *MultisigConfig `json:",omitempty"`
+    Blind           *BlindConfig    `json:",omitempty"`
+    TLS             *TLSConfig      `json:",omitempty"`
+    Policy          string          `json:",omitempty"`
+    Audit           string          `json:",omitempty"`
+    Log             *LogConfig      `json:",omitempty"`
+}
+
+// As sigserv1. Scheme defaults to schnorr.
+type SignConfig struct {
+    Scheme      string  `json:",omitempty"`
+    Suite       string  `json:",omitempty"`
+    KeyFile     string
+}
+
+// As sigserv2. Suite defaults to the group's.
+type MultisigConfig struct {
+    Suite       string  `json:",omitempty"`
+    KeyFile     string
+    Group       string  `json:",omitempty"`
+    DKGShare    string  `json:",omitempty"`
+}
+
+// As sigserv3.
+type BlindConfig struct {
+    Suite       string  `json:",omitempty"`
+    KeyFile     string
+    Info        string
+}
+
+// As the -tlscert, -tlskey and -tlsca flags of the sigservN.
+type TLSConfig struct {
+    CertFile        string
+    KeyFile         string
+    ClientCAFile    string  `json:",omitempty"`
+}
+
+// As the -logformat and -loglevel flags of the sigservN.
+type LogConfig struct {
+    Format      string  `json:",omitempty"`
+    Level       string  `json:",omitempty"`
+}
+
+// Loads the configuration at path, filling in the defaults.
+func LoadConfig(path string) (Config, error) {
+    var config Config
+
+    fcontents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return config, err
+    }
+    err = json.Unmarshal(fcontents, &config)
+    if err != nil {
+        return config, err
+    }
+    if config.Sign == nil && config.Multisig == nil && config.Blind == nil {
+        return config, errors.New("no protocols enabled, give at least one of Sign, Multisig or Blind")
+    }
+    if config.Port == 0 {
+        config.Port = 1111
+    }
+    if config.MaxMessageSize == 0 {
+        config.MaxMessageSize = transport.DefaultMaxMessageSize
+    }
+    if config.Log == nil {
+        config.Log = &LogConfig{}
+    }
+    if config.Log.Format == "" {
+        config.Log.Format = "text"
+    }
+    if config.Log.Level == "" {
+        config.Log.Level = "info"
+    }
+
+    dir := filepath.Dir(path)
+    resolve := func(p *string) {
+        if *p != "" && !filepath.IsAbs(*p) {
+            *p = filepath.Join(dir, *p)
+        }
+    }
+    if config.Sign != nil {
+        resolve(&config.Sign.KeyFile)
+        if config.Sign.Scheme == "" {
+            config.Sign.Scheme = string(crypto.SchemeSchnorr)
+        }
+    }
+    if config.Multisig != nil {
+        resolve(&config.Multisig.KeyFile)
+        resolve(&config.Multisig.Group)
+        resolve(&config.Multisig.DKGShare)
+    }
+    if config.Blind != nil {
+        resolve(&config.Blind.KeyFile)
+        resolve(&config.Blind.Info)
+    }
+    resolve(&config.Policy)
+    resolve(&config.Audit)
+    if config.TLS != nil {
+        resolve(&config.TLS.CertFile)
+        resolve(&config.TLS.KeyFile)
+        resolve(&config.TLS.ClientCAFile)
+    }
+    return config, nil
+}
+
+// The TLS configuration to serve with, nil for plain TCP.
+func (this * Config) ServerTLSConfig() (*tls.Config, error) {
+    if this.TLS == nil {
+        return nil, nil
+    }
+    config, err := transport.ServerTLSConfig(this.TLS.CertFile, this.TLS.KeyFile, this.TLS.ClientCAFile)
+    if err == nil && config == nil {
+        err = errors.New("TLS needs a CertFile and KeyFile")
+    }
+    if err != nil {
+        return nil, errors.New("TLS: " + err.Error())
+    }
+    return config, nil
+}
+
+// How to serve: the TLS configuration, message size, timeouts and
+// session limit.
+func (this * Config) ServerOptions() (signer.ServerOptions, error) {
+    options := signer.ServerOptions{MaxMessageSize: this.MaxMessageSize, MaxSessions: this.MaxSessions}
+    if this.Timeout != "" {
+        timeout, err := time.ParseDuration(this.Timeout)
+        if err != nil {
+            return options, errors.New("Timeout: " + err.Error())
+        }
+        options.Timeout = timeout
+    }
+    if this.SessionTimeout != "" {
+        timeout, err := time.ParseDuration(this.SessionTimeout)
+        if err != nil {
+            return options, errors.New("SessionTimeout: " + err.Error())
+        }
+        options.SessionTimeout = timeout
+    }
+    tlsConfig, err := this.ServerTLSConfig()
+    if err != nil {
+        return options, err
+    }
+    options.TLSConfig = tlsConfig
+    return options, nil
+}
+
+// Loads the keys for every enabled protocol and the policy, opens
+// the audit log and returns the router that serves them. The audit
+// log is opened last, so nothing is left open if anything fails.
+func (this * Config) Router() (*signer.Router, error) {
+    policy, err := signer.LoadPolicy(this.Policy)
+    if err != nil {
+        return nil, errors.New("Policy: " + err.Error())
+    }
+    router := &signer.Router{Services: map[string]signer.Service{}, Policy: policy}
+
+    if this.Sign != nil {
+        scheme, err := crypto.ParseSignatureScheme(this.Sign.Scheme)
+        if err != nil {
+            return nil, err
+        }
+        service, err := signer.NewSignService(scheme, this.Sign.Suite, this.Sign.KeyFile)
+        if err != nil {
+            return nil, errors.New("Sign: " + err.Error())
+        }
+        router.Services[transport.ProtocolSign] = service
+    }
+    if this.Multisig != nil {
+        service, err := signer.NewCosignService(this.Multisig.Suite, this.Multisig.KeyFile,
+                                                 this.Multisig.Group, this.Multisig.DKGShare)
+        if err != nil {
+            return nil, errors.New("Multisig: " + err.Error())
+        }
+        router.Services[transport.ProtocolMultisig] = service
+    }
+    if this.Blind != nil {
+        service, err := signer.NewBlindService(this.Blind.Suite, this.Blind.KeyFile, this.Blind.Info)
+        if err != nil {
+            return nil, errors.New("Blind: " + err.Error())
+        }
+        router.Services[transport.ProtocolBlind] = service
+    }
+
+    router.Audit, err = signer.OpenAuditLog(this.Audit)
+    if err != nil {
+        return nil, errors.New("Audit: " + err.Error())
+    }
+    return router, nil
+}
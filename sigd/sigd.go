@@ -0,0 +1,73 @@
+package main
+
+/* sigd serves any of the three signing protocols from one process
+   and one port, as set out in its configuration file (see config.go).
+   Clients say which protocol they want in the first message of each
+   connection, so sigcli1, sigcli2, sigcli3 and keytool dkg all work
+   against it as they do against sigserv1, sigserv2 and sigserv3.
+   SIGTERM or SIGINT stops it once the clients it is serving are done. */
+
+import (
+    "fmt"
+    "flag"
+    "os"
+    "sort"
+    "vennard.ch/signer"
+)
+
+func main() {
+	var configpath string
+	var port int
+
+	flag.StringVar(&configpath, "config", "sigd.json", "Configuration file to load")
+	flag.IntVar(&port, "port", 0, "Listen on given port instead of the one in the configuration")
+
+	flag.Parse()
+
+    err := run(configpath, port)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	os.Exit(1)
+    }
+    fmt.Println("Sigd - stopped.")
+}
+
+// Serves until we are signalled to stop. Any error means we never
+// started or stopped serving because of it, and sigd exits with
+// status 1 so whatever supervises it can tell.
+func run(configpath string, port int) error {
+    config, err := LoadConfig(configpath)
+    if err != nil {
+    	return err
+    }
+    if port != 0 {
+        config.Port = port
+    }
+    logger, err := signer.NewLogger(os.Stdout, config.Log.Format, config.Log.Level)
+    if err != nil {
+    	return fmt.Errorf("Log: %s", err.Error())
+    }
+    signer.SetLogger(logger)
+
+    options, err := config.ServerOptions()
+    if err != nil {
+    	return err
+    }
+    router, err := config.Router()
+    if err != nil {
+    	return err
+    }
+    defer router.Audit.Close()
+    var protocols []string
+    for protocol := range router.Services {
+        protocols = append(protocols, protocol)
+    }
+    sort.Strings(protocols)
+    if options.TLSConfig != nil {
+        fmt.Printf("Sigd - listening on port %d for %v over TLS.\n", config.Port, protocols)
+    } else {
+        fmt.Printf("Sigd - listening on port %d for %v.\n", config.Port, protocols)
+    }
+
+    return signer.Serve(signer.ShutdownContext(), config.Port, options, router.Handle)
+}
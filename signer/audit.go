@@ -0,0 +1,100 @@
+package signer
+
+/* The audit log: one JSON object per line for every signature a server
+   issues or refuses to issue, appended to a file and synced to disk
+   before the signature goes out, so that what each key signed can be
+   reconstructed from it afterwards:
+
+       {"Time":"2026-10-17T09:12:44.5Z","Client":"10.0.0.7:51544",
+        "ClientKey":"sha3:5xqv-...","Protocol":"sign","Scheme":"schnorr",
+        "Key":"sha3:ab3d-...","MessageHash":"sha256:9f86d0...",
+        "Outcome":"signed"}
+
+   MessageHash is the SHA-256 of exactly the bytes the key signed. For
+   a Schnorr key that is the tagged message (see crypto.PlainMessage),
+   or for a file or digest the prehashed message (see
+   crypto.PrehashMessage); for an RFC 8032 key the message as it came,
+   or the SHA-512 digest Ed25519ph signs. A blind signer never sees
+   the message, so for the blind protocol it is the hash of the
+   blinded challenge it answered. ClientKey is only there when a policy made the client
+   authenticate. The file is only ever appended to; rotating it is up
+   to whoever runs the server. */
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "os"
+    "sync"
+    "time"
+)
+
+// Outcomes of a signing request.
+const (
+    AuditSigned     = "signed"
+    AuditRefused    = "refused"
+)
+
+type AuditEvent struct {
+    Time        time.Time
+    Client      string                      // the client's address
+    ClientKey   string  `json:",omitempty"` // fingerprint it authenticated with
+    Protocol    string
+    Scheme      string
+    Key         string                      // fingerprint of the signing key
+    MessageHash string  `json:",omitempty"`
+    Outcome     string
+    Reason      string  `json:",omitempty"` // why it was refused
+}
+
+type AuditLog struct {
+    lock    sync.Mutex
+    file    *os.File
+}
+
+// The hash of message as it goes in MessageHash.
+func AuditHash(message []byte) string {
+    h := sha256.Sum256(message)
+    return "sha256:" + hex.EncodeToString(h[:])
+}
+
+// Opens the audit log at path for appending, creating it if need be.
+// An empty path means no audit log, and gives nil, which records
+// nothing.
+func OpenAuditLog(path string) (*AuditLog, error) {
+    if path == "" {
+        return nil, nil
+    }
+    file, err := os.OpenFile(path, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0600)
+    if err != nil {
+        return nil, err
+    }
+    return &AuditLog{file: file}, nil
+}
+
+// Appends event and waits for it to reach the disk.
+func (this * AuditLog) Record(event AuditEvent) error {
+    if this == nil {
+        return nil
+    }
+    line, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+    line = append(line, '\n')
+
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    _, err = this.file.Write(line)
+    if err != nil {
+        return err
+    }
+    return this.file.Sync()
+}
+
+func (this * AuditLog) Close() error {
+    if this == nil {
+        return nil
+    }
+    return this.file.Close()
+}
@@ -0,0 +1,185 @@
+package signer
+
+/* Client authentication, for servers with a policy. Clients prove who
+   they are with a Schnorr key of their own:
+
+     1. the client sends its HELLO,
+     2. instead of a HELLO back, the server sends an AUTH_CHALLENGE
+        holding a fresh random nonce and the fingerprint of the key it
+        serves,
+            nonce  fingerprint
+     3. the client checks that is the key it meant to use, signs the
+        protocol name, the fingerprint, the nonce and, over TLS, keying
+        material exported from the TLS session, and sends an
+        AUTH_RESPONSE holding
+            [len(suite)] suite  public key  signature
+     4. the server checks the signature against its own fingerprint and
+        TLS session, looks the key's fingerprint up in the policy and
+        answers with its HELLO, or an ERROR.
+
+   The signature is made under its own context (see transcript.go in
+   the crypto package), so a server can't pass off some message it
+   would like signed as a challenge and get the client's key to sign
+   it; and the nonce means an old response is no use to anyone. Since
+   it names the server's key and, over TLS, the session, a server the
+   client talks to can't relay the challenge of another server and
+   use the response to authenticate there as the client. Without TLS
+   only the key protects it, which is worth little against anyone who
+   can tamper with the connection. The client's key can be in any
+   suite we have. */
+
+import (
+    "bytes"
+    "crypto/rand"
+    "errors"
+    "fmt"
+    "github.com/dedis/crypto/abstract"
+    "vennard.ch/crypto"
+    "vennard.ch/transport"
+)
+
+const authNonceSize = 32
+
+var authContext = []byte("vennard.ch/signer client authentication v2")
+
+// The label for the keying material exported from a TLS session.
+const authExportLabel = "EXPORTER-vennard.ch/signer client authentication"
+const authExportSize = 32
+
+// What the client signs: the protocol, the server's fingerprint and
+// the TLS session's keying material, which is empty without TLS, and
+// then the nonce.
+func authMessage(protocol string, serverKey string, binding []byte, nonce []byte) []byte {
+    msg := []byte{byte(len(protocol))}
+    msg = append(msg, protocol...)
+    msg = append(msg, byte(len(serverKey)))
+    msg = append(msg, serverKey...)
+    msg = append(msg, byte(len(binding)))
+    msg = append(msg, binding...)
+    return append(msg, nonce...)
+}
+
+// The key a client authenticates with.
+type ClientKey struct {
+    Suite   abstract.Suite
+    Keyset  crypto.SchnorrKeyset
+}
+
+// Loads the key a client authenticates with from path, in the suite
+// called suiteName. No path gives a nil key, for Authenticate.
+func LoadClientKey(path string, suiteName string) (*ClientKey, error) {
+    if path == "" {
+        return nil, nil
+    }
+    suite, err := crypto.SuiteByName(suiteName)
+    if err != nil {
+        return nil, err
+    }
+    kv, err := crypto.SchnorrLoadKeypair(path, suite)
+    if err != nil {
+        return nil, err
+    }
+    logger.Info("Authenticating with key", "fingerprint", crypto.SchnorrFingerprint(crypto.SchnorrExtractPubkey(kv)))
+    return &ClientKey{suite, kv}, nil
+}
+
+/* The client side, to be called straight after transport.DialProtocol.
+   If the server didn't ask us to authenticate this does nothing, so
+   clients can always call it; key may be nil if the client has none,
+   in which case servers that want one are an error. serverKey is the
+   fingerprint of the key we expect the server to use, and we won't
+   authenticate to one serving any other. */
+func Authenticate(conn *transport.Conn, key *ClientKey, serverKey string) error {
+    challenge := conn.AuthChallenge()
+    if challenge == nil {
+        return nil
+    }
+    if key == nil {
+        return errors.New("the server wants clients to authenticate, give a key to do it with")
+    }
+    if len(challenge) < authNonceSize {
+        return errors.New("malformed authentication challenge")
+    }
+    nonce := challenge[:authNonceSize]
+    if string(challenge[authNonceSize:]) != serverKey {
+        return fmt.Errorf("the server uses key %s, not %s", challenge[authNonceSize:], serverKey)
+    }
+    binding, err := conn.ExportKeyingMaterial(authExportLabel, authExportSize)
+    if err != nil {
+        return err
+    }
+
+    suite := key.Suite
+    msg := authMessage(conn.Protocol(), serverKey, binding, nonce)
+    sig, err := crypto.SchnorrSign(suite, key.Keyset, msg, authContext)
+    if err != nil {
+        return err
+    }
+    pk := crypto.SchnorrExtractPubkey(key.Keyset)
+    name := suite.String()
+    buf := bytes.Buffer{}
+    buf.WriteByte(byte(len(name)))
+    buf.WriteString(name)
+    err = abstract.Write(&buf, &pk, suite)
+    if err != nil {
+        return err
+    }
+    buf.Write(sig)
+
+    err = conn.Send(transport.MessageAuthResponse, buf.Bytes())
+    if err != nil {
+        return err
+    }
+    // the server goes ahead or tells us why not
+    _, err = conn.Expect(transport.MessageHello)
+    return err
+}
+
+// The server side, for the service with key serverKey. Returns the
+// fingerprint of the key the client proved it holds.
+func authenticateClient(conn *transport.Conn, serverKey string) (string, error) {
+    nonce := make([]byte, authNonceSize)
+    _, err := rand.Read(nonce)
+    if err != nil {
+        return "", err
+    }
+    binding, err := conn.ExportKeyingMaterial(authExportLabel, authExportSize)
+    if err != nil {
+        return "", err
+    }
+    challenge := append(nonce, serverKey...)
+    err = conn.Send(transport.MessageAuthChallenge, challenge)
+    if err != nil {
+        return "", err
+    }
+
+    payload, err := conn.Expect(transport.MessageAuthResponse)
+    if err != nil {
+        return "", err
+    }
+    if len(payload) < 1 || len(payload) < 1 + int(payload[0]) {
+        return "", errors.New("malformed response")
+    }
+    n := 1 + int(payload[0])
+    suite, err := crypto.SuiteByName(string(payload[1:n]))
+    if err != nil {
+        return "", err
+    }
+    r := bytes.NewReader(payload[n:])
+    var pk crypto.SchnorrPublicKey
+    err = abstract.Read(r, &pk, suite)
+    if err != nil {
+        return "", err
+    }
+    sig := payload[len(payload) - r.Len():]
+
+    msg := authMessage(conn.Protocol(), serverKey, binding, nonce)
+    v, err := crypto.SchnorrVerifyWithContext(suite, pk, msg, sig, authContext)
+    if err != nil {
+        return "", err
+    }
+    if !v {
+        return "", errors.New("signature does not verify")
+    }
+    return crypto.SchnorrFingerprint(pk), nil
+}
@@ -0,0 +1,142 @@
+package signer
+
+import (
+    "bytes"
+    "io"
+    "io/ioutil"
+    "github.com/dedis/crypto/abstract"
+    "vennard.ch/crypto"
+    "vennard.ch/transport"
+)
+
+// Message types: we send our public parameters, the client
+// answers with its challenge e and we send back the response.
+const (
+        PARAMS    byte = 1
+        CHALLENGE byte = 2
+        RESPONSE  byte = 3
+)
+
+/* Loads the key and the shared info (see keytool raninf) for the 
+   blind protocol and returns the service, whose scheme for the
+   policy is blind. */
+func NewBlindService(suiteName string, keyfile string, infofile string) (Service, error) {
+    suite, err := crypto.SuiteByName(suiteName)
+    if err != nil {
+        return Service{}, err
+    }
+    kv, err := crypto.SchnorrLoadKeypair(keyfile, suite)
+    if err != nil {
+        return Service{}, err
+    }
+    fingerprint := crypto.SchnorrFingerprint(crypto.SchnorrExtractPubkey(kv))
+    logger.Info("Loaded key", "scheme", PolicySchemeBlind, "suite", crypto.SuiteName(suite), "fingerprint", fingerprint)
+
+    info, err := ioutil.ReadFile(infofile)
+    if err != nil {
+        return Service{}, err
+    }
+
+    return Service{func(session *Session) {
+        signBlindlySchnorr(session, suite, kv, info)
+    }, PolicySchemeBlind, fingerprint}, nil
+}
+
+
+/* This function implements the signer protocol from the blind signature paper 
+   and can be bound via closure given a specific set of parameters and 
+   send to the serve() function
+
+   This is not the best accept() handler ever written,  but it's better than the client side code */
+func signBlindlySchnorr (session *Session, suite abstract.Suite, kv crypto.SchnorrKeyset, sharedinfo []byte) {
+    
+    conn := session.Conn
+    defer conn.Close()
+
+    session.Log.Debug("Sending initial parameters")
+
+    signerParams, err := crypto.NewPrivateParams(suite, sharedinfo)
+    if err != nil {
+        session.Log.Error("Error creating new private parameters", "err", err)
+        return
+    }
+
+    // "send" these to the user.
+    userPublicParams := signerParams.DerivePubParams()
+    buffer := bytes.Buffer{} 
+    abstract.Write(&buffer, &userPublicParams, suite)
+    conn.Send(PARAMS, buffer.Bytes())
+
+    // now we need to wait for the client to send us "e"
+    ch := make(chan transport.Message)
+    errorCh := make(chan error)
+
+    // this neat little routine for wrapping read connections
+    // in a class unashamedly stolen from stackoverflow:
+    // http://stackoverflow.com/a/9764191
+    // It gives up once we return, which closes the connection, and
+    // the connection's timeout (see Serve) ends any wait for a client
+    // that has gone quiet.
+    quit := make(chan struct{})
+    defer close(quit)
+    go func(ch chan transport.Message, eCh chan error) {
+      for {
+        // try to read the data
+        session.Log.Debug("Read goroutine off and going")
+        msg, err := conn.Receive()
+        if err != nil {
+          // send an error if it's encountered
+          select {
+          case eCh <- err:
+          case <-quit:
+          }
+          return
+        }
+        // send data if we read some.
+        select {
+        case ch <- msg:
+        case <-quit:
+          return
+        }
+      }
+    }(ch, errorCh)
+
+    for {
+        select {
+        case data := <-ch:
+            session.Log.Debug("Received message")
+
+            if data.Type != CHALLENGE {
+                session.Log.Warn("Expected a challenge", "type", data.Type)
+                return
+            }
+            var challenge crypto.WISchnorrChallengeMessage
+            buffer := bytes.NewBuffer(data.Payload)
+            err = abstract.Read(buffer, &challenge, suite)
+            if err != nil {
+                session.Log.Warn("Error decoding challenge", "err", err)
+                return
+            }
+
+            // the challenge is all of the message we ever see
+            response := crypto.ServerGenerateResponse(suite, challenge, signerParams, kv)
+            if session.Signed(data.Payload) != nil {
+                return
+            }
+            respbuffer := bytes.Buffer{} 
+            abstract.Write(&respbuffer, &response, suite)
+            conn.Send(RESPONSE, respbuffer.Bytes())
+
+            session.Log.Debug("We're done")
+            return
+
+        case err := <- errorCh:
+            if err == io.EOF {
+                return
+            }
+            session.Log.Warn("Encountered error serving client", "err", err)
+            return
+        }       
+    }
+
+}
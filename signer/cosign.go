@@ -0,0 +1,278 @@
+package signer
+
+import (
+    "fmt"
+    "bytes"
+    "errors"
+    "io"
+    "github.com/dedis/crypto/abstract"
+    "vennard.ch/crypto"
+    "vennard.ch/transport"
+)
+
+// The protocol runs in three rounds, each a framed message of 
+// that type which we answer with a message of the same type:
+//  COSIGN_MESSAGE    - client sends the message, we reply with H(T)
+//  COSIGN_REVEAL     - client sends every party's H(T), we reply with T
+//  COSIGN_COMMITMENT - client sends every party's T, we check each against 
+//               its hash and only then reply with our response.
+const (
+        COSIGN_INIT       byte = 0
+        COSIGN_MESSAGE    byte = 1
+        COSIGN_REVEAL     byte = 2
+        COSIGN_COMMITMENT byte = 3
+)
+
+// Distributed key generation runs over the same connection handler,
+// see dkg.go. These start far enough up not to clash with the 
+// signing rounds.
+const (
+        DKG_SETUP   byte = 16
+        DKG_SHARES  byte = 17
+        DKG_CONFIRM byte = 18
+)
+
+/* Loads the key and group for the multisig protocol and returns the
+   service, whose scheme for the policy is multisig. We need the whole group to know which coefficient to apply
+   to our key when responding; without one (groupfile empty) we can only
+   take part in DKG runs, writing our share to dkgSharePath, encrypted
+   under the same passphrase as our key if that is. Running a DKG
+   needs the dkg scheme rather than multisig. An empty suiteName
+   means the group's suite. */
+func NewCosignService(suiteName string, keyfile string, groupfile string, dkgSharePath string) (Service, error) {
+    var group crypto.SchnorrMGroupConfig
+    var err error
+    if groupfile != "" {
+        group, err = crypto.SchnorrMLoadGroupConfig(groupfile)
+        if err != nil {
+            return Service{}, err
+        }
+        if suiteName == "" {
+            suiteName = group.Suite
+        }
+    }
+
+    suite, err := crypto.SuiteByName(suiteName)
+    if err != nil {
+        return Service{}, err
+    }
+    kv, passphrase, err := crypto.SchnorrLoadKeypairAndPassphrase(keyfile, suite)
+    if err != nil {
+        return Service{}, err
+    }
+    fingerprint := crypto.SchnorrFingerprint(crypto.SchnorrExtractPubkey(kv))
+    logger.Info("Loaded key", "scheme", PolicySchemeMultisig, "suite", crypto.SuiteName(suite), "fingerprint", fingerprint)
+
+    self := -1
+    if groupfile != "" {
+        groupSuite, _ := group.GetSuite()
+        if crypto.SuiteName(groupSuite) != crypto.SuiteName(suite) {
+            return Service{}, fmt.Errorf("the group is in suite %s not %s", crypto.SuiteName(groupSuite), crypto.SuiteName(suite))
+        }
+        self = group.MemberIndex(crypto.SchnorrExtractPubkey(kv))
+        if self < 0 {
+            return Service{}, errors.New("our key is not a member of the group")
+        }
+    } else if dkgSharePath == "" {
+        return Service{}, errors.New("need a group to sign for or a DKG share path to generate one")
+    }
+
+    return Service{func(session *Session) {
+        signOneKBMSchnorr(session, suite, kv, group, self, dkgShare{dkgSharePath, passphrase})
+    }, PolicySchemeMultisig, fingerprint}, nil
+}
+
+func signOneKBMSchnorr(session *Session, suite abstract.Suite, kv crypto.SchnorrKeyset, group crypto.SchnorrMGroupConfig, self int, share dkgShare) {
+
+    conn := session.Conn
+    defer conn.Close()
+
+    ch := make(chan transport.Message)
+    errorCh := make(chan error)
+
+    // this neat little routine for wrapping read connections
+    // in a class unashamedly stolen from stackoverflow:
+    // http://stackoverflow.com/a/9764191
+    // It gives up once we return, which closes the connection, and
+    // the connection's timeout (see Serve) ends any wait for a client
+    // that has gone quiet.
+    quit := make(chan struct{})
+    defer close(quit)
+    go func(ch chan transport.Message, eCh chan error) {
+      for {
+        // try to read the data
+        session.Log.Debug("Read goroutine off and going")
+        msg, err := conn.Receive()
+        if err != nil {
+          // send an error if it's encountered
+          select {
+          case eCh <- err:
+          case <-quit:
+          }
+          return
+        }
+        // send data if we read some.
+        select {
+        case ch <- msg:
+        case <-quit:
+          return
+        }
+      }
+    }(ch, errorCh)
+
+    var internalState byte = COSIGN_INIT
+    var message []byte
+    var privateCommit crypto.SchnorrMPrivateCommitment
+    var commitmentHashes []crypto.SchnorrMCommitmentHash
+    var coefficient abstract.Secret
+
+    for {
+        select {
+        case data := <-ch:
+            
+            // validate state transition - we can only 
+            // transfer to the next state in the protocol.
+            // Anything else ends the session, or a client could
+            // hold it open for ever with frames we ignore.
+            newState := data.Type
+
+            session.Log.Debug("Selected data channel", "state", newState, "internal_state", internalState)
+            if internalState == COSIGN_INIT && newState == DKG_SETUP {
+                if !session.Allows(PolicySchemeDKG) {
+                    reason := fmt.Sprintf("client %s may not run a DKG with key %s", session.ClientKey, session.service.Key)
+                    session.Refused(nil, reason)
+                    conn.SendError(reason)
+                    return
+                }
+                runDKGSession(session, suite, kv, share, data.Payload, ch, errorCh)
+                return
+            }
+            if newState != (internalState+1) {
+                session.Log.Warn("Unexpected message", "type", newState, "state", internalState)
+                conn.SendError(fmt.Sprintf("unexpected message type %d in state %d", newState, internalState))
+                return
+            }
+            internalState = newState
+
+            payload := data.Payload
+
+            switch newState {
+            case COSIGN_MESSAGE:
+
+                session.Log.Debug("Received message")
+
+                if !session.Allows(PolicySchemeMultisig) {
+                    reason := fmt.Sprintf("client %s may not cosign with key %s", session.ClientKey, session.service.Key)
+                    session.Refused(payload, reason)
+                    conn.SendError(reason)
+                    return
+                }
+                if self < 0 {
+                    session.Refused(payload, "not a member of any group")
+                    return
+                }
+
+                message = payload
+
+                privateCommitment, err := crypto.SchnorrMGenerateCommitment(suite)
+                if err != nil {
+                    session.Log.Error("Error generating commitment", "err", err)
+                    return
+                }
+                privateCommit = privateCommitment
+
+                // only the hash goes out in this round.
+                commitmentHash := crypto.SchnorrMHashCommitment(suite, privateCommitment.PublicCommitment())
+
+                buf := bytes.Buffer{} 
+                abstract.Write(&buf, &commitmentHash, suite)
+                conn.Send(COSIGN_MESSAGE, buf.Bytes())
+
+            case COSIGN_REVEAL:
+
+                session.Log.Debug("Received commitment hashes")
+
+                signers, hashes, err := crypto.SchnorrMDecodeCommitmentHashes(suite, payload)
+                if err != nil {
+                    session.Log.Warn("Error binary decode of commitment hashes", "err", err)
+                    return
+                }
+
+                // we must be in the signing set with our own hash, or we 
+                // are being asked to reveal for some other session.
+                ownHash := crypto.SchnorrMHashCommitment(suite, privateCommit.PublicCommitment())
+                found := false
+                for k, i := range signers {
+                    if i == self && hashes[k] == ownHash {
+                        found = true
+                    }
+                }
+                if !found {
+                    session.Refused(message, "our commitment hash is missing")
+                    return
+                }
+
+                // the signing set decides our coefficient in threshold 
+                // groups, and must be everyone otherwise.
+                coefficient, err = group.Coefficient(suite, self, signers)
+                if err != nil {
+                    session.Refused(message, err.Error())
+                    return
+                }
+                commitmentHashes = hashes
+
+                publicCommitment := privateCommit.PublicCommitment()
+
+                buf := bytes.Buffer{} 
+                abstract.Write(&buf, &publicCommitment, suite)
+                conn.Send(COSIGN_REVEAL, buf.Bytes())
+
+            case COSIGN_COMMITMENT:
+
+                session.Log.Debug("Received commitments")
+
+                commitments, err := crypto.SchnorrMDecodePublicCommitments(suite, payload)
+                if err != nil {
+                    session.Log.Warn("Error binary decode of commitments", "err", err)
+                    return
+                }
+
+                // refuse to answer the challenge if anyone changed
+                // their commitment after seeing the others.
+                bad, err := crypto.SchnorrMVerifyRevealedCommitments(suite, commitments, commitmentHashes)
+                if err != nil {
+                    session.Refused(message, err.Error())
+                    return
+                }
+                if bad >= 0 {
+                    session.Refused(message, fmt.Sprintf("commitment %d does not match its hash", bad))
+                    return
+                }
+
+                aggregateCommitment := crypto.SchnorrMComputeAggregateCommitment(suite, commitments)
+                collectiveChallenge := crypto.SchnorrMComputeCollectiveChallenge(suite, group.JointKey, message, nil, aggregateCommitment)
+                response := crypto.SchnorrMUnmarshallCCComputeResponse(suite, kv, coefficient, privateCommit, collectiveChallenge)
+                if session.Signed(message) != nil {
+                    return
+                }
+
+                outBuf := bytes.Buffer{} 
+                abstract.Write(&outBuf, &response, suite)
+                conn.Send(COSIGN_COMMITMENT, outBuf.Bytes())
+
+                // we're now at the end, we can close the connection
+                return
+            default:
+                session.Log.Warn("Didn't understand message", "type", data.Type)
+            }
+
+        case err := <-errorCh:
+            if err == io.EOF {
+                return
+            }
+            // the reader has given up, so there is nothing more to wait for.
+            session.Log.Warn("Encountered error serving client", "err", err)
+            return
+        }
+    }
+}
@@ -0,0 +1,170 @@
+package signer
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "github.com/dedis/crypto/abstract"
+    "vennard.ch/crypto"
+    "vennard.ch/transport"
+)
+
+/* Runs our side of a distributed key generation driven by keytool dkg.
+   The setup message has already been read by signOneKBMSchnorr; 
+   we answer it with our deal, wait for the dealings addressed to us
+   and answer those with our report (see crypto/dkg.go): the group
+   key, our verification share and our echo. Last comes everyone's
+   echo, and only if they all agree with ours does our share of the
+   group key go in share.path, to be used as the -keyfile of a server
+   signing for the new group, and we confirm. 
+
+   The share is written to a file of its own next to share.path 
+   first, so that running out of disk doesn't leave us agreeing to
+   a key we don't hold, and linked into place at the end. We never 
+   replace a file that is already there: it may well be the share
+   of a group that is in use. */
+func runDKGSession (session *Session, suite abstract.Suite, kv crypto.SchnorrKeyset, share dkgShare,
+                    setupPayload []byte, ch chan transport.Message, errorCh chan error) {
+
+    conn := session.Conn
+
+    if share.path == "" {
+        session.Log.Warn("DKG requested but no -dkgshare path given, refusing")
+        conn.SendError("not taking part in DKG runs")
+        return
+    }
+    _, err := os.Lstat(share.path)
+    if err == nil {
+        session.Log.Warn("DKG requested but the share file already exists, refusing", "path", share.path)
+        conn.SendError("already holding a DKG share, not replacing it")
+        return
+    }
+
+    setup, err := crypto.SchnorrDKGDecodeSetup(suite, setupPayload)
+    if err != nil {
+        session.Log.Warn("Error decoding DKG setup", "err", err)
+        return
+    }
+
+    dkg, err := crypto.NewSchnorrDKG(suite, kv, setup)
+    if err != nil {
+        session.Log.Warn("Refusing DKG", "err", err)
+        return
+    }
+
+    deal, err := dkg.Deal()
+    if err != nil {
+        session.Log.Error("Error dealing", "err", err)
+        return
+    }
+    conn.Send(DKG_SETUP, crypto.SchnorrDKGEncodeDeal(suite, deal))
+    session.Log.Info("DKG deal sent", "member", setup.Self)
+
+    data, ok := expectDKGMessage(session, DKG_SHARES, ch, errorCh)
+    if !ok {
+        return
+    }
+
+    dealings, err := crypto.SchnorrDKGDecodeDealings(suite, data)
+    if err != nil {
+        session.Log.Warn("Error decoding dealings", "err", err)
+        return
+    }
+
+    result, err := dkg.Finish(dealings)
+    if err != nil {
+        session.Log.Warn("DKG failed", "err", err)
+        conn.SendError(err.Error())
+        return
+    }
+
+    // the coordinator checks this against what it computes
+    // from the commitments, and against the other members. 
+    // The proof of possession for our share goes in the group file.
+    report, err := dkg.Report(result)
+    if err != nil {
+        session.Log.Error("Unable to report on our share", "err", err)
+        return
+    }
+    tmpPath, err := share.writeTemp(suite, result.Share)
+    if err != nil {
+        session.Log.Error("Unable to write share", "path", share.path, "err", err)
+        conn.SendError("unable to write our share")
+        return
+    }
+    defer os.Remove(tmpPath)
+    conn.Send(DKG_SHARES, crypto.SchnorrDKGEncodeReport(suite, report))
+
+    data, ok = expectDKGMessage(session, DKG_CONFIRM, ch, errorCh)
+    if !ok {
+        return
+    }
+    echoes, err := crypto.SchnorrDKGDecodeEchoes(data)
+    if err != nil {
+        session.Log.Warn("Error decoding echoes", "err", err)
+        return
+    }
+    err = dkg.CheckEchoes(result, echoes)
+    if err != nil {
+        session.Log.Warn("DKG members disagree, not keeping our share", "err", err)
+        conn.SendError(err.Error())
+        return
+    }
+
+    // a link, unlike a rename, fails if share.path has turned up
+    // in the meantime; the deferred remove takes away the temporary name.
+    err = os.Link(tmpPath, share.path)
+    if err != nil {
+        session.Log.Error("Unable to write share", "path", share.path, "err", err)
+        conn.SendError("unable to write our share")
+        return
+    }
+    session.Log.Info("DKG complete, share written", "path", share.path)
+    conn.Send(DKG_CONFIRM, nil)
+}
+
+// Where our share goes and the passphrase to encrypt it under, nil
+// to leave it unencrypted.
+type dkgShare struct {
+    path        string
+    passphrase  []byte
+}
+
+// Writes kv to a new file beside this.path and returns its name.
+func (this dkgShare) writeTemp (suite abstract.Suite, kv crypto.SchnorrKeyset) (string, error) {
+    f, err := os.CreateTemp(filepath.Dir(this.path), "." + filepath.Base(this.path) + ".*")
+    if err != nil {
+        return "", err
+    }
+    tmpPath := f.Name()
+    f.Close()
+
+    if this.passphrase != nil {
+        err = crypto.SchnorrSaveEncryptedKeypair(tmpPath, suite, kv, this.passphrase, crypto.DefaultKeyEncryptionParams)
+    } else {
+        err = crypto.SchnorrSaveKeypair(tmpPath, suite, kv)
+    }
+    if err != nil {
+        os.Remove(tmpPath)
+        return "", err
+    }
+    return tmpPath, nil
+}
+
+// Waits for the coordinator's next message, which must be of type
+// msgType, and returns its payload.
+func expectDKGMessage (session *Session, msgType byte, ch chan transport.Message, errorCh chan error) ([]byte, bool) {
+    var data transport.Message
+    select {
+    case data = <-ch:
+    case err := <-errorCh:
+        session.Log.Warn("DKG aborted", "err", err)
+        return nil, false
+    }
+    if data.Type != msgType {
+        session.Log.Warn("Unexpected message during DKG", "type", data.Type)
+        session.Conn.SendError(fmt.Sprintf("unexpected message type %d during DKG", data.Type))
+        return nil, false
+    }
+    return data.Payload, true
+}
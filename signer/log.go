@@ -0,0 +1,41 @@
+package signer
+
+/* Server logging goes through log/slog, so it has levels and can be
+   written as JSON lines for a log collector as well as text for a
+   terminal. What each session logs carries the client's address and
+   the protocol (see Session). Every signature we issue, or refuse to,
+   also goes in the audit log if there is one (audit.go); the log here
+   is for running the server, not for reconstructing what it signed. */
+
+import (
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+// Sets where the servers in this package log to. Call it before
+// serving anything.
+func SetLogger(l *slog.Logger) {
+    logger = l
+}
+
+// A logger writing to w in format, text or json, and dropping
+// anything below level: debug, info, warn or error.
+func NewLogger(w io.Writer, format string, level string) (*slog.Logger, error) {
+    var l slog.Level
+    err := l.UnmarshalText([]byte(level))
+    if err != nil {
+        return nil, fmt.Errorf("unknown log level %s, use debug, info, warn or error", level)
+    }
+    options := &slog.HandlerOptions{Level: l}
+    switch format {
+    case "text":
+        return slog.New(slog.NewTextHandler(w, options)), nil
+    case "json":
+        return slog.New(slog.NewJSONHandler(w, options)), nil
+    }
+    return nil, fmt.Errorf("unknown log format %s, use text or json", format)
+}
@@ -0,0 +1,152 @@
+package signer
+
+/* Who may use which signing keys. A policy file lists the clients by
+   the fingerprint of the key they authenticate with (see auth.go and
+   keytool fingerprint) and, for each, the keys it may have sign and
+   with which schemes:
+
+       {
+           "Clients": [
+               {
+                   "Name": "ci",
+                   "Fingerprint": "sha3:5xqv-ogjm-2kdz-3b7n-yq4t-xldk-cafw-mhuz",
+                   "Allow": [
+                       { "Key": "sha3:ab3d-...", "Schemes": ["schnorr", "ed25519"] },
+                       { "Key": "*", "Schemes": ["blind"] }
+                   ]
+               }
+           ]
+       }
+
+   A Key of "*" is any key, and an empty Schemes list is any scheme.
+   The schemes are those of the sign protocol (schnorr and ed25519)
+   plus multisig for cosigning, dkg for running a distributed key
+   generation with a cosigning key and blind for partially blind
+   signatures. A client with either multisig or dkg gets through to
+   the cosigning service, which checks which of the two it needs
+   once it knows what the client wants (see Session.Allows). Clients
+   that aren't listed may use nothing. */
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "vennard.ch/crypto"
+)
+
+// The schemes of the multisig and blind protocols.
+const (
+    PolicySchemeMultisig    = "multisig"
+    PolicySchemeDKG         = "dkg"
+    PolicySchemeBlind       = "blind"
+)
+
+// Matches any key.
+const PolicyAnyKey = "*"
+
+// One key a client may use, and how.
+type PolicyGrant struct {
+    Key         string
+    Schemes     []string    `json:",omitempty"`
+}
+
+type PolicyClient struct {
+    Name        string      `json:",omitempty"`
+    Fingerprint string
+    Allow       []PolicyGrant
+}
+
+type Policy struct {
+    Clients     []PolicyClient
+}
+
+func knownPolicyScheme(scheme string) bool {
+    switch scheme {
+    case string(crypto.SchemeSchnorr), string(crypto.SchemeEd25519), PolicySchemeMultisig, PolicySchemeDKG, PolicySchemeBlind:
+        return true
+    }
+    return false
+}
+
+// Loads a policy from disk, refusing ones that name schemes we don't
+// have or clients without a fingerprint, so that a typo can't quietly
+// lock somebody out or let them in. An empty path means no policy,
+// and gives nil.
+func LoadPolicy(path string) (*Policy, error) {
+    var policy Policy
+
+    if path == "" {
+        return nil, nil
+    }
+    fcontents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    err = json.Unmarshal(fcontents, &policy)
+    if err != nil {
+        return nil, err
+    }
+    for i, client := range policy.Clients {
+        if client.Fingerprint == "" {
+            return nil, fmt.Errorf("%s: client %d has no fingerprint", path, i)
+        }
+        for _, grant := range client.Allow {
+            if grant.Key == "" {
+                return nil, fmt.Errorf("%s: client %s has a grant without a key", path, client.Fingerprint)
+            }
+            for _, scheme := range grant.Schemes {
+                if !knownPolicyScheme(scheme) {
+                    return nil, fmt.Errorf("%s: unknown scheme %s", path, scheme)
+                }
+            }
+        }
+    }
+    return &policy, nil
+}
+
+// The client with the given key fingerprint, nil if it isn't listed.
+func (this * Policy) Client(fingerprint string) *PolicyClient {
+    for i := range this.Clients {
+        if this.Clients[i].Fingerprint == fingerprint {
+            return &this.Clients[i]
+        }
+    }
+    return nil
+}
+
+// The schemes that get a client through to a service with the given
+// scheme; the multisig service also runs DKG.
+func serviceSchemes(scheme string) []string {
+    if scheme == PolicySchemeMultisig {
+        return []string{PolicySchemeMultisig, PolicySchemeDKG}
+    }
+    return []string{scheme}
+}
+
+// Whether the client may use service for anything.
+func (this * PolicyClient) Allows(service Service) bool {
+    for _, scheme := range serviceSchemes(service.Scheme) {
+        if this.AllowsScheme(service.Key, scheme) {
+            return true
+        }
+    }
+    return false
+}
+
+// Whether the client may use key with scheme.
+func (this * PolicyClient) AllowsScheme(key string, scheme string) bool {
+    for _, grant := range this.Allow {
+        if grant.Key != PolicyAnyKey && grant.Key != key {
+            continue
+        }
+        if len(grant.Schemes) == 0 {
+            return true
+        }
+        for _, s := range grant.Schemes {
+            if s == scheme {
+                return true
+            }
+        }
+    }
+    return false
+}
@@ -0,0 +1,176 @@
+package signer
+
+/* Running a Handler for every connection on a port. Each message of
+   every protocol has the Timeout in ServerOptions to arrive or to be
+   sent (see transport.Conn.SetTimeout), so a client that stalls at any
+   point is disconnected instead of holding a goroutine open for ever.
+   A client that keeps each message just in time still only gets the
+   SessionTimeout for the whole session, and at most MaxSessions clients
+   are served at once. Any more are turned away with an ERROR once they
+   have sent their HELLO, which they get refusalTimeout for, and while
+   maxRefusals of them are waiting for theirs the rest are hung up on
+   straight away, so stalled connections can't pile up either. When the
+   context is cancelled, which ShutdownContext does on SIGINT or SIGTERM,
+   we stop accepting and wait for the sessions in progress to finish
+   before returning, which the SessionTimeout also bounds. */
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "net"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+    "time"
+    "vennard.ch/transport"
+)
+
+// How long a session may last if ServerOptions doesn't say. A
+// cosigning or DKG session waits on the other servers, so it is
+// generous.
+const DefaultSessionTimeout = 10 * time.Minute
+
+// How many clients over MaxSessions we wait for to say HELLO so we
+// can tell them why they are refused, and for how long.
+const (
+    maxRefusals     = 16
+    refusalTimeout  = 5 * time.Second
+)
+
+// How Serve treats its clients. The zero value is plain TCP with the
+// default message size and timeouts, and no limit on sessions.
+type ServerOptions struct {
+    MaxMessageSize  uint32          // 0 is transport.DefaultMaxMessageSize
+    TLSConfig       *tls.Config     // see transport.ServerTLSConfig
+    Timeout         time.Duration   // for each message, 0 is transport.DefaultTimeout
+    SessionTimeout  time.Duration   // for the whole session, 0 is DefaultSessionTimeout
+    MaxSessions     int             // clients served at once, 0 is any number
+}
+
+// A context that is cancelled on SIGINT or SIGTERM, for Serve. A
+// second signal kills us as usual, for when draining takes too long.
+func ShutdownContext() context.Context {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    go func() {
+        <-ctx.Done()
+        logger.Info("Signalled to stop, finishing the sessions in progress")
+        stop()
+    }()
+    return ctx
+}
+
+/* Serves handler on port "port" from this machine, on every address
+   and over tcp, with each connection in its own goroutine. Returns nil
+   once ctx is cancelled and every session has finished, or an error if
+   we can't listen or accept any more. */
+func Serve(ctx context.Context, port int, options ServerOptions, handler Handler) error {
+
+    sock, err := transport.Listen(port, options.TLSConfig)
+    if err != nil {
+        return err
+    }
+    return serveListener(ctx, sock, options, handler)
+}
+
+func serveListener(ctx context.Context, sock net.Listener, options ServerOptions, handler Handler) error {
+    maxMessageSize := options.MaxMessageSize
+    if maxMessageSize == 0 {
+        maxMessageSize = transport.DefaultMaxMessageSize
+    }
+    timeout := options.Timeout
+    if timeout == 0 {
+        timeout = transport.DefaultTimeout
+    }
+    sessionTimeout := options.SessionTimeout
+    if sessionTimeout == 0 {
+        sessionTimeout = DefaultSessionTimeout
+    }
+    var slots, refusals chan struct{}
+    if options.MaxSessions > 0 {
+        slots = make(chan struct{}, options.MaxSessions)
+        refusals = make(chan struct{}, maxRefusals)
+    }
+    var sessions sync.WaitGroup
+    defer sessions.Wait()
+
+    // closing the listener is the only way out of Accept
+    done := make(chan struct{})
+    defer close(done)
+    go func() {
+        select {
+        case <-ctx.Done():
+        case <-done:
+        }
+        sock.Close()
+    }()
+
+    var delay time.Duration
+    for {
+        conn, err := sock.Accept()
+        if err != nil {
+            if ctx.Err() != nil {
+                return nil
+            }
+            if errors.Is(err, net.ErrClosed) {
+                return err
+            }
+            // out of file descriptors and the like: back off and
+            // try again, as net/http does.
+            if delay == 0 {
+                delay = 5 * time.Millisecond
+            } else if delay < time.Second {
+                delay *= 2
+            }
+            logger.Error("Error accepting connection", "err", err, "retry_in", delay)
+            time.Sleep(delay)
+            continue
+        }
+        delay = 0
+
+        tconn := transport.NewConn(conn)
+        tconn.SetMaxMessageSize(maxMessageSize)
+        tconn.SetTimeout(timeout)
+        busy := false
+        if slots != nil {
+            select {
+            case slots <- struct{}{}:
+            default:
+                busy = true
+            }
+        }
+        if busy {
+            select {
+            case refusals <- struct{}{}:
+            default:
+                logger.Warn("Too many clients, hanging up", "client", conn.RemoteAddr().String())
+                conn.Close()
+                continue
+            }
+            if timeout > refusalTimeout {
+                tconn.SetTimeout(refusalTimeout)
+            }
+        }
+        // refusing a client is a session too, as it can stall as well
+        sessions.Add(1)
+        go func() {
+            defer sessions.Done()
+            timer := time.AfterFunc(sessionTimeout, func() {
+                logger.Warn("Session took too long, disconnecting", "client", conn.RemoteAddr().String(), "timeout", sessionTimeout)
+                tconn.Close()
+            })
+            defer timer.Stop()
+            if busy {
+                tconn.ExpectHello()
+                refuse(tconn, "too many clients, try again later")
+                <-refusals
+                return
+            }
+            handler(tconn)
+            if slots != nil {
+                <-slots
+            }
+        }()
+    }
+}
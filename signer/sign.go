@@ -0,0 +1,177 @@
+package signer
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "github.com/dedis/crypto/abstract"
+    "vennard.ch/crypto"
+    "vennard.ch/transport"
+)
+
+// Message types. The client asks for a signature in one of 
+// three ways and we always answer with SIGNATURE:
+//  MESSAGE    - the message itself
+//  HASH       - a digest of a file, as [len(alg)] alg digest
+//  FILE_BEGIN - the name of a hash algorithm, followed by the file
+//               in any number of FILE_CHUNKs and then a FILE_END.
+//               We hash it as it arrives.
+// A file gets the same signature whichever way it is sent, and never
+// the same as any MESSAGE. Schnorr keys sign a message via
+// crypto.PlainMessage and a digest via crypto.PrehashMessage; RFC 8032
+// keys sign a message as it is and a digest as Ed25519ph, which only
+// takes SHA-512, so that anybody's Ed25519 code can check them.
+const (
+        MESSAGE    byte = 1
+        SIGNATURE  byte = 2
+        HASH       byte = 3
+        FILE_BEGIN byte = 4
+        FILE_CHUNK byte = 5
+        FILE_END   byte = 6
+)
+
+// signs a request with whichever scheme the key is for. algorithm is
+// empty for a MESSAGE, which msg is as it came, and otherwise the 
+// hash algorithm of the digest msg is. Returns the exact bytes the
+// key signed, for the audit log, and the signature.
+type signFunc func(msg []byte, algorithm string) ([]byte, []byte, error)
+
+/* Loads the key for the sign protocol and returns the service. suiteName
+   is only used for schnorr keys. Its scheme for the policy is scheme. */
+func NewSignService(scheme crypto.SignatureScheme, suiteName string, keyfile string) (Service, error) {
+    if scheme == crypto.SchemeEd25519 {
+        kv, err := crypto.Ed25519LoadKeypair(keyfile)
+        if err != nil {
+            return Service{}, err
+        }
+        fingerprint := crypto.Ed25519Fingerprint(kv.Public)
+        logger.Info("Loaded key", "scheme", scheme, "fingerprint", fingerprint)
+        return Service{func(session *Session) {
+            signOneKBEd25519(session, kv)
+        }, string(scheme), fingerprint}, nil
+    }
+
+    suite, err := crypto.SuiteByName(suiteName)
+    if err != nil {
+        return Service{}, err
+    }
+    kv, err := crypto.SchnorrLoadKeypair(keyfile, suite)
+    if err != nil {
+        return Service{}, err
+    }
+    fingerprint := crypto.SchnorrFingerprint(crypto.SchnorrExtractPubkey(kv))
+    logger.Info("Loaded key", "scheme", scheme, "suite", crypto.SuiteName(suite), "fingerprint", fingerprint)
+    return Service{func(session *Session) {
+        signOneKBSchnorr(session, suite, kv)
+    }, string(scheme), fingerprint}, nil
+}
+
+func signOneKBSchnorr(session *Session, suite abstract.Suite, kv crypto.SchnorrKeyset) {
+    signRequest(session, func(msg []byte, algorithm string) ([]byte, []byte, error) {
+        signed := crypto.PlainMessage(msg)
+        if algorithm != "" {
+            var err error
+            signed, err = crypto.PrehashMessage(algorithm, msg)
+            if err != nil {
+                return nil, nil, err
+            }
+        }
+        sig, err := crypto.SchnorrSign(suite, kv, signed, nil)
+        return signed, sig, err
+    })
+}
+
+func signOneKBEd25519(session *Session, kv crypto.Ed25519Keyset) {
+    signRequest(session, func(msg []byte, algorithm string) ([]byte, []byte, error) {
+        if algorithm == "" {
+            return msg, crypto.Ed25519Sign(kv, msg), nil
+        }
+        sig, err := crypto.Ed25519SignPrehash(kv, algorithm, msg)
+        return msg, sig, err
+    })
+}
+
+// Splits a HASH payload into algorithm and digest.
+func decodeHashRequest(payload []byte) (string, []byte, error) {
+    if len(payload) < 1 || len(payload) < 1 + int(payload[0]) {
+        return "", nil, errors.New("malformed hash request")
+    }
+    n := 1 + int(payload[0])
+    return string(payload[1:n]), payload[n:], nil
+}
+
+// Hashes a file sent as FILE_CHUNKs up to the FILE_END.
+func receiveFile(conn *transport.Conn, algorithm string) ([]byte, error) {
+    h, err := crypto.NewPrehash(algorithm)
+    if err != nil {
+        return nil, err
+    }
+    for {
+        msg, err := conn.Receive()
+        if err != nil {
+            return nil, err
+        }
+        switch msg.Type {
+        case FILE_CHUNK:
+            h.Write(msg.Payload)
+        case FILE_END:
+            return h.Sum(nil), nil
+        default:
+            return nil, fmt.Errorf("unexpected message type %d in file", msg.Type)
+        }
+    }
+}
+
+// Reads one request, signs it and answers. The message used to be
+// exactly 1KB (hence the handler names); now it can be anything up
+// to the maximum message size, or a file of any size.
+func signRequest(session *Session, sign signFunc) {
+    
+    conn := session.Conn
+    defer conn.Close()
+
+    request, err := conn.Receive()
+    if err != nil {
+        session.Log.Warn("Error reading message", "err", err)
+        return
+    }
+
+    var message []byte
+    var algorithm string
+    switch request.Type {
+    case MESSAGE:
+        message = request.Payload
+    case HASH:
+        algorithm, message, err = decodeHashRequest(request.Payload)
+        if err != nil {
+            session.Log.Warn("Error in hash request", "err", err)
+            return
+        }
+        session.Log.Debug("Signing digest", "algorithm", algorithm, "digest", hex.EncodeToString(message))
+    case FILE_BEGIN:
+        algorithm = string(request.Payload)
+        message, err = receiveFile(conn, algorithm)
+        if err != nil {
+            session.Log.Warn("Error receiving file", "err", err)
+            return
+        }
+        session.Log.Debug("Signing file", "algorithm", algorithm, "digest", hex.EncodeToString(message))
+    default:
+        session.Log.Warn("Unexpected message type", "type", request.Type)
+        return
+    }
+
+    message, signature, err := sign(message, algorithm)
+    if err != nil {
+        session.Refused(message, err.Error())
+        return
+    }
+    if session.Signed(message) != nil {
+        return
+    }
+
+    err = conn.Send(SIGNATURE, signature)
+    if err != nil {
+        session.Log.Warn("Error sending signature", "err", err)
+    }
+}
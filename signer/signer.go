@@ -0,0 +1,163 @@
+package signer
+
+/* The signing side of the three protocols: one signature (sign.go),
+   multisignature cosigning and distributed key generation (cosign.go,
+   dkg.go) and partially blind signatures (blind.go). sigserv1,
+   sigserv2 and sigserv3 each serve one of them; sigd serves any of
+   them from one process and one port.
+
+   Every connection starts with a HELLO naming the protocol (see the
+   transport package), which a Router uses to hand the connection to
+   the right handler. The single-protocol servers use a Router too,
+   so every client speaks to all of them the same way. A Router with
+   a Policy also makes the client prove who it is (auth.go) and only
+   lets it use the keys the policy allows (policy.go). Serve (serve.go)
+   runs a handler for each connection on a port until it is told to
+   stop. What the services sign goes in the Router's audit log
+   (audit.go), and everything else in the log (log.go). */
+
+import (
+    "fmt"
+    "log/slog"
+    "time"
+    "vennard.ch/transport"
+)
+
+// Serves one connection, closing it when done.
+type Handler func(conn *transport.Conn)
+
+// Serves one session, closing its connection when done.
+type SessionHandler func(session *Session)
+
+// A protocol as a server offers it: the handler, and what a Policy 
+// needs to know to decide who may use it.
+type Service struct {
+    Handle  SessionHandler
+    Scheme  string      // see policy.go
+    Key     string      // fingerprint of the key we sign with
+}
+
+// The service for each protocol a server speaks, who may use them
+// and where to record what they sign. Without a Policy anyone may
+// use any of them, and without an Audit log nothing is recorded.
+type Router struct {
+    Services    map[string]Service
+    Policy      *Policy
+    Audit       *AuditLog
+}
+
+// A client's connection to a service, as the Router hands it over.
+type Session struct {
+    Conn        *transport.Conn
+    Log         *slog.Logger    // with the client and protocol attached
+    Protocol    string
+    ClientKey   string          // fingerprint the client authenticated with, if it did
+    service     Service
+    client      *PolicyClient   // what the policy says of the client, nil without one
+    audit       *AuditLog
+}
+
+// Whether the client may use our key with scheme, for services that
+// offer more than one. Without a policy it may.
+func (this * Session) Allows(scheme string) bool {
+    return this.client == nil || this.client.AllowsScheme(this.service.Key, scheme)
+}
+
+func (this * Session) auditEvent(message []byte, outcome string) AuditEvent {
+    event := AuditEvent{
+        Time:       time.Now().UTC(),
+        Client:     this.Conn.RemoteAddr().String(),
+        ClientKey:  this.ClientKey,
+        Protocol:   this.Protocol,
+        Scheme:     this.service.Scheme,
+        Key:        this.service.Key,
+        Outcome:    outcome,
+    }
+    if message != nil {
+        event.MessageHash = AuditHash(message)
+    }
+    return event
+}
+
+// Records that we signed message. Call it before sending the
+// signature, and if it fails don't: the audit log wouldn't know of it.
+func (this * Session) Signed(message []byte) error {
+    event := this.auditEvent(message, AuditSigned)
+    err := this.audit.Record(event)
+    if err != nil {
+        this.Log.Error("Not signing, unable to write the audit log", "err", err)
+        return err
+    }
+    this.Log.Info("Signed", "key", event.Key, "scheme", event.Scheme, "message", event.MessageHash)
+    return nil
+}
+
+// Records that we won't sign message, nil if we never got that far,
+// and why.
+func (this * Session) Refused(message []byte, reason string) {
+    event := this.auditEvent(message, AuditRefused)
+    event.Reason = reason
+    this.Log.Warn("Refused to sign", "key", event.Key, "scheme", event.Scheme, "reason", reason)
+    err := this.audit.Record(event)
+    if err != nil {
+        this.Log.Error("Unable to write the audit log", "err", err)
+    }
+}
+
+// Refuses the client with reason and hangs up.
+func refuse(conn *transport.Conn, reason string) {
+    logger.Warn("Refusing client", "client", conn.RemoteAddr().String(), "reason", reason)
+    conn.SendError(reason)
+    conn.Close()
+}
+
+// Reads the HELLO and hands the connection to the service for the
+// protocol it names, once the client has authenticated and the 
+// policy allows it. Anything else gets an ERROR and is closed.
+func (this * Router) Handle(conn *transport.Conn) {
+    protocol, err := conn.ExpectHello()
+    if err != nil {
+        refuse(conn, "expected a hello: " + err.Error())
+        return
+    }
+    service, ok := this.Services[protocol]
+    if !ok {
+        refuse(conn, "protocol " + protocol + " is not served here")
+        return
+    }
+
+    session := &Session{
+        Conn:       conn,
+        Log:        logger.With("client", conn.RemoteAddr().String(), "protocol", protocol),
+        Protocol:   protocol,
+        service:    service,
+        audit:      this.Audit,
+    }
+
+    if this.Policy != nil {
+        fingerprint, err := authenticateClient(conn, service.Key)
+        if err != nil {
+            refuse(conn, "authentication failed: " + err.Error())
+            return
+        }
+        session.ClientKey = fingerprint
+        session.Log = session.Log.With("client_key", fingerprint)
+        client := this.Policy.Client(fingerprint)
+        if client == nil || !client.Allows(service) {
+            reason := fmt.Sprintf("client %s may not use %s key %s", fingerprint, service.Scheme, service.Key)
+            session.Refused(nil, reason)
+            conn.SendError(reason)
+            conn.Close()
+            return
+        }
+        session.client = client
+        session.Log.Info("Client authorized", "name", client.Name)
+    }
+
+    err = conn.AcceptHello()
+    if err != nil {
+        conn.Close()
+        return
+    }
+    service.Handle(session)
+}
@@ -0,0 +1,578 @@
+package signer
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    stdcrypto "crypto"
+    "crypto/ed25519"
+    "crypto/sha512"
+    "encoding/json"
+    "os"
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+    "time"
+    "vennard.ch/crypto"
+    "vennard.ch/transport"
+)
+
+// Serves router on a local port until the test is over and returns
+// the address to dial.
+func serveRouter(t *testing.T, router *Router) string {
+    sock, err := transport.Listen(0, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    t.Cleanup(func() { sock.Close() })
+    go func() {
+        for {
+            conn, err := sock.Accept()
+            if err != nil {
+                return
+            }
+            go router.Handle(transport.NewConn(conn))
+        }
+    }()
+    return sock.Addr().String()
+}
+
+func TestRouter(t *testing.T) {
+    served := make(chan string, 2)
+    router := &Router{Services: map[string]Service{
+        transport.ProtocolSign:  {func(session *Session) { served <- transport.ProtocolSign; session.Conn.Close() }, "schnorr", ""},
+        transport.ProtocolBlind: {func(session *Session) { served <- transport.ProtocolBlind; session.Conn.Close() }, PolicySchemeBlind, ""},
+    }}
+
+    addr := serveRouter(t, router)
+
+    for _, protocol := range []string{transport.ProtocolBlind, transport.ProtocolSign} {
+        conn, err := transport.DialProtocol(addr, protocol, nil)
+        if err != nil { t.Fatal(err.Error()) }
+        if got := <-served; got != protocol {
+            t.Error("Asked for", protocol, "got", got)
+        }
+        conn.Close()
+    }
+
+    // a protocol we don't serve, or no hello at all, gets an error
+    _, err := transport.DialProtocol(addr, transport.ProtocolMultisig, nil)
+    if _, ok := err.(transport.RemoteError); !ok {
+        t.Error("Unknown protocol was not refused, got", err)
+    }
+
+    conn, err := transport.Dial(addr, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    conn.Send(MESSAGE, []byte("sign me"))
+    _, err = conn.Expect(SIGNATURE)
+    if _, ok := err.(transport.RemoteError); !ok {
+        t.Error("Connection without a hello was not refused, got", err)
+    }
+    conn.Close()
+}
+
+func TestRouterSign(t *testing.T) {
+    suite := crypto.DefaultSuite()
+    kv, err := crypto.SchnorrGenerateKeypair(suite)
+    if err != nil { t.Fatal(err.Error()) }
+
+    router := &Router{Services: map[string]Service{transport.ProtocolSign: {func(session *Session) {
+        signOneKBSchnorr(session, suite, kv)
+    }, "schnorr", ""}}}
+    conn, err := transport.DialProtocol(serveRouter(t, router), transport.ProtocolSign, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    defer conn.Close()
+
+    msg := []byte("routed to the sign protocol")
+    err = conn.Send(MESSAGE, msg)
+    if err != nil { t.Fatal(err.Error()) }
+    sig, err := conn.Expect(SIGNATURE)
+    if err != nil { t.Fatal(err.Error()) }
+
+    v, err := crypto.SchnorrVerify(suite, crypto.SchnorrExtractPubkey(kv), crypto.PlainMessage(msg), sig)
+    if err != nil || !v {
+        t.Error("Signature from the routed connection does not verify")
+    }
+}
+
+// What an RFC 8032 key signs has to verify with the standard library
+// over exactly what the client sent: the message as it is, and a
+// digest as Ed25519ph, whether sent as a HASH or as the whole file.
+func TestSignEd25519Standard(t *testing.T) {
+    kv, err := crypto.Ed25519GenerateKeypair()
+    if err != nil { t.Fatal(err.Error()) }
+    public := ed25519.PublicKey(kv.Public)
+    router := &Router{Services: map[string]Service{transport.ProtocolSign: {func(session *Session) {
+        signOneKBEd25519(session, kv)
+    }, "ed25519", ""}}}
+    addr := serveRouter(t, router)
+
+    request := func(requests ...transport.Message) []byte {
+        conn, err := transport.DialProtocol(addr, transport.ProtocolSign, nil)
+        if err != nil { t.Fatal(err.Error()) }
+        defer conn.Close()
+        for _, r := range requests {
+            err = conn.Send(r.Type, r.Payload)
+            if err != nil { t.Fatal(err.Error()) }
+        }
+        sig, err := conn.Expect(SIGNATURE)
+        if err != nil { t.Fatal(err.Error()) }
+        return sig
+    }
+
+    msg := []byte("checked by a partner with their own Ed25519 code")
+    sig := request(transport.Message{Type: MESSAGE, Payload: msg})
+    if !ed25519.Verify(public, msg, sig) {
+        t.Error("Signature on a MESSAGE does not verify with crypto/ed25519")
+    }
+
+    file := []byte("release 1.0")
+    digest := sha512.Sum512(file)
+    options := &ed25519.Options{Hash: stdcrypto.SHA512}
+    hashRequest := append([]byte{byte(len(crypto.HashSHA512))}, crypto.HashSHA512...)
+    sig = request(transport.Message{Type: HASH, Payload: append(hashRequest, digest[:]...)})
+    err = ed25519.VerifyWithOptions(public, digest[:], sig, options)
+    if err != nil {
+        t.Error("Signature on a HASH does not verify with crypto/ed25519 as Ed25519ph", err)
+    }
+    if ed25519.Verify(public, digest[:], sig) {
+        t.Error("Signature on a HASH verifies as one on a MESSAGE")
+    }
+
+    sig = request(transport.Message{Type: FILE_BEGIN, Payload: []byte(crypto.HashSHA512)},
+                  transport.Message{Type: FILE_CHUNK, Payload: file},
+                  transport.Message{Type: FILE_END})
+    err = ed25519.VerifyWithOptions(public, digest[:], sig, options)
+    if err != nil {
+        t.Error("Signature on a file does not verify with crypto/ed25519 as Ed25519ph", err)
+    }
+}
+
+func TestPolicy(t *testing.T) {
+    suite := crypto.DefaultSuite()
+    ckv, err := crypto.SchnorrGenerateKeypair(suite)
+    if err != nil { t.Fatal(err.Error()) }
+    client := &ClientKey{suite, ckv}
+    fingerprint := crypto.SchnorrFingerprint(crypto.SchnorrExtractPubkey(ckv))
+
+    served := make(chan string, 1)
+    service := func(session *Session) { served <- session.Protocol; session.Conn.Close() }
+    router := &Router{Services: map[string]Service{
+        transport.ProtocolSign:  {service, "schnorr", "sha3:signing-key"},
+        transport.ProtocolBlind: {service, PolicySchemeBlind, "sha3:blind-key"},
+    }}
+    addr := serveRouter(t, router)
+
+    dialKey := func(protocol string, key *ClientKey, serverKey string) error {
+        conn, err := transport.DialProtocol(addr, protocol, nil)
+        if err != nil {
+            return err
+        }
+        defer conn.Close()
+        err = Authenticate(conn, key, serverKey)
+        if err == nil {
+            <-served
+        }
+        return err
+    }
+    dial := func(protocol string, key *ClientKey) error {
+        return dialKey(protocol, key, router.Services[protocol].Key)
+    }
+
+    // the client may use the signing key for schnorr only
+    router.Policy = &Policy{[]PolicyClient{{"test", fingerprint, []PolicyGrant{{"sha3:signing-key", []string{"schnorr"}}}}}}
+    err = dial(transport.ProtocolSign, client)
+    if err != nil {
+        t.Error("Authorized client was refused", err)
+    }
+    err = dial(transport.ProtocolBlind, client)
+    if _, ok := err.(transport.RemoteError); !ok {
+        t.Error("Client used a key it has no grant for, got", err)
+    }
+    err = dial(transport.ProtocolSign, nil)
+    if err == nil {
+        t.Error("Client without a key was served")
+    }
+
+    // a server using some other key than the client expects
+    err = dialKey(transport.ProtocolSign, client, "sha3:blind-key")
+    if _, ok := err.(transport.RemoteError); ok || err == nil {
+        t.Error("Client authenticated to a server with the wrong key, got", err)
+    }
+
+    // a key that isn't in the policy
+    okv, err := crypto.SchnorrGenerateKeypair(suite)
+    if err != nil { t.Fatal(err.Error()) }
+    err = dial(transport.ProtocolSign, &ClientKey{suite, okv})
+    if _, ok := err.(transport.RemoteError); !ok {
+        t.Error("Unknown client was served, got", err)
+    }
+
+    // a grant for any key, but the wrong scheme
+    router.Policy = &Policy{[]PolicyClient{{"test", fingerprint, []PolicyGrant{{PolicyAnyKey, []string{"ed25519"}}}}}}
+    err = dial(transport.ProtocolSign, client)
+    if _, ok := err.(transport.RemoteError); !ok {
+        t.Error("Client used a scheme it has no grant for, got", err)
+    }
+
+    // and without a policy, a key isn't asked for
+    router.Policy = nil
+    err = dial(transport.ProtocolBlind, nil)
+    if err != nil {
+        t.Error("Client was refused without a policy", err)
+    }
+}
+
+func TestLoadPolicy(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "policy.json")
+
+    ioutil.WriteFile(path, []byte(`{"Clients": [{"Fingerprint": "sha3:client", "Allow": [{"Key": "*", "Schemes": ["blind"]}]}]}`), 0644)
+    policy, err := LoadPolicy(path)
+    if err != nil { t.Fatal(err.Error()) }
+    client := policy.Client("sha3:client")
+    if client == nil || !client.Allows(Service{nil, PolicySchemeBlind, "sha3:key"}) {
+        t.Error("Policy lost its grant")
+    }
+    if policy.Client("sha3:someone-else") != nil {
+        t.Error("Policy has a client it doesn't list")
+    }
+
+    // dkg gets a client through to the cosigning service, but not
+    // the right to cosign
+    ioutil.WriteFile(path, []byte(`{"Clients": [{"Fingerprint": "sha3:client", "Allow": [{"Key": "sha3:key", "Schemes": ["dkg"]}]}]}`), 0644)
+    policy, err = LoadPolicy(path)
+    if err != nil { t.Fatal(err.Error()) }
+    client = policy.Client("sha3:client")
+    if !client.Allows(Service{nil, PolicySchemeMultisig, "sha3:key"}) {
+        t.Error("Client with dkg was kept from the cosigning service")
+    }
+    if client.AllowsScheme("sha3:key", PolicySchemeMultisig) || !client.AllowsScheme("sha3:key", PolicySchemeDKG) {
+        t.Error("dkg and multisig are not told apart")
+    }
+
+    ioutil.WriteFile(path, []byte(`{"Clients": [{"Fingerprint": "sha3:client", "Allow": [{"Key": "*", "Schemes": ["blinded"]}]}]}`), 0644)
+    _, err = LoadPolicy(path)
+    if err == nil {
+        t.Error("Policy with an unknown scheme was loaded")
+    }
+}
+
+// Serves handler with options on a local port until ctx is cancelled.
+// Returns the address to dial and a channel with what Serve returned.
+func serveOptions(t *testing.T, ctx context.Context, options ServerOptions, handler Handler) (string, chan error) {
+    sock, err := transport.Listen(0, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    done := make(chan error, 1)
+    go func() { done <- serveListener(ctx, sock, options, handler) }()
+    return sock.Addr().String(), done
+}
+
+func TestStalledClients(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    suite := crypto.DefaultSuite()
+    kv, err := crypto.SchnorrGenerateKeypair(suite)
+    if err != nil { t.Fatal(err.Error()) }
+    router := &Router{Services: map[string]Service{transport.ProtocolSign: {func(session *Session) {
+        signOneKBSchnorr(session, suite, kv)
+    }, "schnorr", ""}}}
+    addr, _ := serveOptions(t, ctx, ServerOptions{Timeout: 100 * time.Millisecond}, router.Handle)
+
+    // one client never says hello, the other stops half way through
+    // sending a file
+    quiet, err := transport.Dial(addr, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    defer quiet.Close()
+    partway, err := transport.DialProtocol(addr, transport.ProtocolSign, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    defer partway.Close()
+    err = partway.Send(FILE_BEGIN, []byte("sha256"))
+    if err != nil { t.Fatal(err.Error()) }
+    err = partway.Send(FILE_CHUNK, []byte("the start of a file"))
+    if err != nil { t.Fatal(err.Error()) }
+
+    for _, conn := range []*transport.Conn{quiet, partway} {
+        // the server may say why before it hangs up
+        conn.SetTimeout(5 * time.Second)
+        err = nil
+        for err == nil {
+            _, err = conn.Receive()
+        }
+        if transport.IsTimeout(err) {
+            t.Error("Stalled client was not disconnected, got", err)
+        }
+    }
+}
+
+func TestSessionTimeout(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    // reads whatever comes for as long as it comes
+    handler := func(conn *transport.Conn) {
+        defer conn.Close()
+        for {
+            _, err := conn.Receive()
+            if err != nil {
+                return
+            }
+        }
+    }
+    options := ServerOptions{Timeout: time.Second, SessionTimeout: 200 * time.Millisecond}
+    addr, _ := serveOptions(t, ctx, options, handler)
+
+    // each message is in time, but the session goes on too long
+    conn, err := transport.Dial(addr, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    defer conn.Close()
+    start := time.Now()
+    for time.Since(start) < 5 * time.Second {
+        err = conn.Send(MESSAGE, []byte("still here"))
+        if err != nil {
+            break
+        }
+        time.Sleep(20 * time.Millisecond)
+    }
+    if err == nil {
+        t.Error("Client was not disconnected at the end of its session")
+    }
+}
+
+func TestMaxSessions(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    release := make(chan struct{})
+    served := make(chan bool)
+    router := &Router{Services: map[string]Service{transport.ProtocolSign: {func(session *Session) {
+        served <- true
+        <-release
+        session.Conn.Close()
+    }, "schnorr", ""}}}
+    addr, _ := serveOptions(t, ctx, ServerOptions{MaxSessions: 1}, router.Handle)
+
+    first, err := transport.DialProtocol(addr, transport.ProtocolSign, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    defer first.Close()
+    <-served
+
+    _, err = transport.DialProtocol(addr, transport.ProtocolSign, nil)
+    if _, ok := err.(transport.RemoteError); !ok {
+        t.Error("Client beyond the limit was not refused, got", err)
+    }
+
+    // once the first is done there is room again
+    release <- struct{}{}
+    second, err := transport.DialProtocol(addr, transport.ProtocolSign, nil)
+    if err != nil {
+        // the slot is given back just after the handler returns
+        time.Sleep(100 * time.Millisecond)
+        second, err = transport.DialProtocol(addr, transport.ProtocolSign, nil)
+    }
+    if err != nil { t.Fatal(err.Error()) }
+    defer second.Close()
+    <-served
+    close(release)
+}
+
+// Clients over the limit that never say HELLO don't get to keep a
+// connection open each: past maxRefusals we hang up at once.
+func TestRefusalFlood(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    release := make(chan struct{})
+    router := &Router{Services: map[string]Service{transport.ProtocolSign: {func(session *Session) {
+        <-release
+        session.Conn.Close()
+    }, "schnorr", ""}}}
+    addr, _ := serveOptions(t, ctx, ServerOptions{MaxSessions: 1}, router.Handle)
+    defer close(release)
+
+    first, err := transport.DialProtocol(addr, transport.ProtocolSign, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    defer first.Close()
+
+    for i := 0; i < maxRefusals; i++ {
+        stalled, err := transport.Dial(addr, nil)
+        if err != nil { t.Fatal(err.Error()) }
+        defer stalled.Close()
+    }
+    // give the server time to take them all
+    time.Sleep(100 * time.Millisecond)
+
+    conn, err := transport.Dial(addr, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    defer conn.Close()
+    conn.SetTimeout(time.Second)
+    _, err = conn.Receive()
+    if err == nil || transport.IsTimeout(err) {
+        t.Error("Client beyond the refusals was not hung up on, got", err)
+    }
+}
+
+func TestShutdown(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    suite := crypto.DefaultSuite()
+    kv, err := crypto.SchnorrGenerateKeypair(suite)
+    if err != nil { t.Fatal(err.Error()) }
+    router := &Router{Services: map[string]Service{transport.ProtocolSign: {func(session *Session) {
+        signOneKBSchnorr(session, suite, kv)
+    }, "schnorr", ""}}}
+    addr, done := serveOptions(t, ctx, ServerOptions{}, router.Handle)
+
+    conn, err := transport.DialProtocol(addr, transport.ProtocolSign, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    defer conn.Close()
+
+    // the session in progress holds up the shutdown...
+    cancel()
+    select {
+    case err = <-done:
+        t.Fatal("Serve returned with a session in progress", err)
+    case <-time.After(100 * time.Millisecond):
+    }
+    _, err = transport.DialProtocol(addr, transport.ProtocolSign, nil)
+    if err == nil {
+        t.Error("New client was served while shutting down")
+    }
+
+    // ...and still gets its signature
+    msg := []byte("signed while shutting down")
+    err = conn.Send(MESSAGE, msg)
+    if err != nil { t.Fatal(err.Error()) }
+    sig, err := conn.Expect(SIGNATURE)
+    if err != nil { t.Fatal(err.Error()) }
+    v, err := crypto.SchnorrVerify(suite, crypto.SchnorrExtractPubkey(kv), crypto.PlainMessage(msg), sig)
+    if err != nil || !v {
+        t.Error("Signature made while shutting down does not verify")
+    }
+
+    select {
+    case err = <-done:
+        if err != nil {
+            t.Error("Serve failed to shut down cleanly", err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Error("Serve did not return after its last session")
+    }
+}
+
+// Reads back every event in the audit log at path.
+func readAuditLog(t *testing.T, path string) []AuditEvent {
+    f, err := os.Open(path)
+    if err != nil { t.Fatal(err.Error()) }
+    defer f.Close()
+    var events []AuditEvent
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        var event AuditEvent
+        err = json.Unmarshal(scanner.Bytes(), &event)
+        if err != nil { t.Fatal(err.Error()) }
+        events = append(events, event)
+    }
+    return events
+}
+
+func TestAuditLog(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "audit.log")
+    audit, err := OpenAuditLog(path)
+    if err != nil { t.Fatal(err.Error()) }
+    defer audit.Close()
+
+    suite := crypto.DefaultSuite()
+    kv, err := crypto.SchnorrGenerateKeypair(suite)
+    if err != nil { t.Fatal(err.Error()) }
+    key := crypto.SchnorrFingerprint(crypto.SchnorrExtractPubkey(kv))
+    ckv, err := crypto.SchnorrGenerateKeypair(suite)
+    if err != nil { t.Fatal(err.Error()) }
+    client := &ClientKey{suite, ckv}
+    fingerprint := crypto.SchnorrFingerprint(crypto.SchnorrExtractPubkey(ckv))
+
+    router := &Router{
+        Services: map[string]Service{transport.ProtocolSign: {func(session *Session) {
+            signOneKBSchnorr(session, suite, kv)
+        }, "schnorr", key}},
+        Policy: &Policy{[]PolicyClient{{"test", fingerprint, []PolicyGrant{{key, nil}}}}},
+        Audit:  audit,
+    }
+    addr := serveRouter(t, router)
+
+    // one signature, and one client the policy turns away
+    conn, err := transport.DialProtocol(addr, transport.ProtocolSign, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    err = Authenticate(conn, client, key)
+    if err != nil { t.Fatal(err.Error()) }
+    msg := []byte("for the record")
+    err = conn.Send(MESSAGE, msg)
+    if err != nil { t.Fatal(err.Error()) }
+    _, err = conn.Expect(SIGNATURE)
+    if err != nil { t.Fatal(err.Error()) }
+    conn.Close()
+
+    okv, err := crypto.SchnorrGenerateKeypair(suite)
+    if err != nil { t.Fatal(err.Error()) }
+    conn, err = transport.DialProtocol(addr, transport.ProtocolSign, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    err = Authenticate(conn, &ClientKey{suite, okv}, key)
+    if err == nil {
+        t.Error("Client without a grant was served")
+    }
+    conn.Close()
+
+    events := readAuditLog(t, path)
+    if len(events) != 2 {
+        t.Fatal("Expected 2 audit events, got", len(events))
+    }
+    signed, refused := events[0], events[1]
+    if signed.Outcome != AuditSigned || signed.Key != key || signed.ClientKey != fingerprint ||
+       signed.Scheme != "schnorr" || signed.Protocol != transport.ProtocolSign ||
+       signed.MessageHash != AuditHash(crypto.PlainMessage(msg)) || signed.Client == "" || signed.Time.IsZero() {
+        t.Error("Wrong audit event for a signature", signed)
+    }
+    if refused.Outcome != AuditRefused || refused.Key != key || refused.MessageHash != "" || refused.Reason == "" {
+        t.Error("Wrong audit event for a refusal", refused)
+    }
+
+    // reopening appends
+    audit.Close()
+    audit, err = OpenAuditLog(path)
+    if err != nil { t.Fatal(err.Error()) }
+    err = audit.Record(AuditEvent{Outcome: AuditRefused})
+    if err != nil { t.Fatal(err.Error()) }
+    if len(readAuditLog(t, path)) != 3 {
+        t.Error("Reopened audit log was not appended to")
+    }
+
+    // without an audit log nothing is recorded, and nothing fails
+    var none *AuditLog
+    if none.Record(signed) != nil || none.Close() != nil {
+        t.Error("Recording without an audit log failed")
+    }
+}
+
+func TestNewLogger(t *testing.T) {
+    buf := bytes.Buffer{}
+    l, err := NewLogger(&buf, "json", "warn")
+    if err != nil { t.Fatal(err.Error()) }
+    l.Info("dropped")
+    l.Warn("kept", "key", "sha3:abcd")
+
+    var record map[string]interface{}
+    err = json.Unmarshal(buf.Bytes(), &record)
+    if err != nil { t.Fatal(err.Error()) }
+    if record["msg"] != "kept" || record["level"] != "WARN" || record["key"] != "sha3:abcd" {
+        t.Error("Wrong log record", buf.String())
+    }
+
+    _, err = NewLogger(&buf, "xml", "info")
+    if err == nil {
+        t.Error("Logger in an unknown format")
+    }
+    _, err = NewLogger(&buf, "text", "loud")
+    if err == nil {
+        t.Error("Logger at an unknown level")
+    }
+}
@@ -1,37 +1,95 @@
-
 package main
 
 import (
     "fmt"
-    "net"
     "flag"
-	"github.com/dedis/crypto/edwards/ed25519"
+    "os"
+    "time"
 	"vennard.ch/crypto"
+	"vennard.ch/signer"
+	"vennard.ch/transport"
 )
 
 func main() {
 	var port int
 	var kfilepath string
+	var schemename string
+	var suitename string
+	var maxmsg uint
+	var tlscert, tlskey, tlsca string
+	var policypath string
+	var timeout, sessiontimeout time.Duration
+	var maxsessions int
+	var logformat, loglevel string
+	var auditpath string
 
 	flag.IntVar(&port, "port", 1111, "Listen on given port")
 	flag.StringVar(&kfilepath, "keyfile", "", "Use the keyfile specified")
+	flag.StringVar(&schemename, "scheme", "schnorr", "Signature scheme: schnorr or ed25519 (RFC 8032)")
+	flag.StringVar(&suitename, "suite", crypto.DefaultSuiteName, "Suite for schnorr keys: ed25519 or p256")
+	flag.UintVar(&maxmsg, "maxmsg", uint(transport.DefaultMaxMessageSize), "Largest message in bytes we accept to sign")
+	flag.StringVar(&tlscert, "tlscert", "", "Serve over TLS with this certificate (PEM)")
+	flag.StringVar(&tlskey, "tlskey", "", "Private key for -tlscert (PEM)")
+	flag.StringVar(&tlsca, "tlsca", "", "Require client certificates signed by the CAs in this file (mutual TLS)")
+	flag.StringVar(&policypath, "policy", "", "Only serve clients that authenticate and are allowed to by this policy file")
+	flag.DurationVar(&timeout, "timeout", transport.DefaultTimeout, "Disconnect clients that take longer than this over any one message")
+	flag.DurationVar(&sessiontimeout, "sessiontimeout", signer.DefaultSessionTimeout, "Disconnect clients whose session takes longer than this altogether")
+	flag.IntVar(&maxsessions, "maxsessions", 0, "Serve at most this many clients at once, 0 for no limit")
+	flag.StringVar(&logformat, "logformat", "text", "Log as text or json")
+	flag.StringVar(&loglevel, "loglevel", "info", "Log at this level and above: debug, info, warn or error")
+	flag.StringVar(&auditpath, "audit", "", "Append a record of every signature issued or refused to this file")
 
 	flag.Parse()
-    fmt.Printf("Sigserv1 - listening on port %d.\n", port)
 
-    suite := ed25519.NewAES128SHA256Ed25519(true) 
-    kv, err := crypto.SchnorrLoadKeypair(kfilepath, suite)
+    logger, err := signer.NewLogger(os.Stdout, logformat, loglevel)
     if err != nil {
     	fmt.Println("Error " + err.Error())
     	return
     }
+    signer.SetLogger(logger)
 
-    // I don't know if there's a way to 
-    // do std::bind-like behaviour in GO.
-    // for C++ what I'd do is pretty simple: 
-    // newfunc := std::bind(&func, args to bind)
-    var signOneKBImpl connectionhandler = func(conn net.Conn) {
-        signOneKBSchnorr(conn, suite, kv)
-    }
-    serve(port, signOneKBImpl)
+    scheme, err := crypto.ParseSignatureScheme(schemename)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    fmt.Printf("Sigserv1 - listening on port %d (%s).\n", port, scheme)
+
+    tlsConfig, err := transport.ServerTLSConfig(tlscert, tlskey, tlsca)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    service, err := signer.NewSignService(scheme, suitename, kfilepath)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    policy, err := signer.LoadPolicy(policypath)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    audit, err := signer.OpenAuditLog(auditpath)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    defer audit.Close()
+    router := signer.Router{
+        Services: map[string]signer.Service{transport.ProtocolSign: service},
+        Policy:   policy,
+        Audit:    audit,
+    }
+    options := signer.ServerOptions{
+        MaxMessageSize: uint32(maxmsg),
+        TLSConfig:      tlsConfig,
+        Timeout:        timeout,
+        SessionTimeout: sessiontimeout,
+        MaxSessions:    maxsessions,
+    }
+    err = signer.Serve(signer.ShutdownContext(), port, options, router.Handle)
+    if err != nil {
+        fmt.Println("Error " + err.Error())
+    }
 }
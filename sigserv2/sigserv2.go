@@ -3,35 +3,89 @@ package main
 
 import (
     "fmt"
-    "net"
     "flag"
-	"github.com/dedis/crypto/edwards/ed25519"
-	"vennard.ch/crypto"
+    "os"
+    "time"
+	"vennard.ch/signer"
+	"vennard.ch/transport"
 )
 
 func main() {
 	var port int
 	var kfilepath string
+	var groupfilepath string
+	var dkgsharepath string
+	var suitename string
+	var maxmsg uint
+	var tlscert, tlskey, tlsca string
+	var policypath string
+	var timeout, sessiontimeout time.Duration
+	var maxsessions int
+	var logformat, loglevel string
+	var auditpath string
 
 	flag.IntVar(&port, "port", 1111, "Listen on given port")
 	flag.StringVar(&kfilepath, "keyfile", "", "Use the keyfile specified")
+	flag.StringVar(&groupfilepath, "group", "", "Group configuration this server is a member of")
+	flag.StringVar(&dkgsharepath, "dkgshare", "", "Take part in keytool dkg runs, writing our share of the new group key here (it must not exist yet)")
+	flag.StringVar(&suitename, "suite", "", "Suite the key is in: ed25519 or p256. Defaults to the group's, or ed25519")
+	flag.UintVar(&maxmsg, "maxmsg", uint(transport.DefaultMaxMessageSize), "Largest message in bytes we accept")
+	flag.StringVar(&tlscert, "tlscert", "", "Serve over TLS with this certificate (PEM)")
+	flag.StringVar(&tlskey, "tlskey", "", "Private key for -tlscert (PEM)")
+	flag.StringVar(&tlsca, "tlsca", "", "Require client certificates signed by the CAs in this file (mutual TLS)")
+	flag.StringVar(&policypath, "policy", "", "Only serve clients that authenticate and are allowed to by this policy file")
+	flag.DurationVar(&timeout, "timeout", transport.DefaultTimeout, "Disconnect clients that take longer than this over any one message")
+	flag.DurationVar(&sessiontimeout, "sessiontimeout", signer.DefaultSessionTimeout, "Disconnect clients whose session takes longer than this altogether")
+	flag.IntVar(&maxsessions, "maxsessions", 0, "Serve at most this many clients at once, 0 for no limit")
+	flag.StringVar(&logformat, "logformat", "text", "Log as text or json")
+	flag.StringVar(&loglevel, "loglevel", "info", "Log at this level and above: debug, info, warn or error")
+	flag.StringVar(&auditpath, "audit", "", "Append a record of every signature issued or refused to this file")
 
 	flag.Parse()
-    fmt.Printf("Sigserv1 - listening on port %d.\n", port)
 
-    suite := ed25519.NewAES128SHA256Ed25519(true) 
-    kv, err := crypto.SchnorrLoadKeypair(kfilepath, suite)
+    logger, err := signer.NewLogger(os.Stdout, logformat, loglevel)
     if err != nil {
     	fmt.Println("Error " + err.Error())
     	return
     }
+    signer.SetLogger(logger)
+    fmt.Printf("Sigserv2 - listening on port %d.\n", port)
 
-    // I don't know if there's a way to 
-    // do std::bind-like behaviour in GO.
-    // for C++ what I'd do is pretty simple: 
-    // newfunc := std::bind(&func, args to bind)
-    var signOneKBImpl connectionhandler = func(conn net.Conn) {
-        signOneKBMSchnorr(conn, suite, kv)
-    }
-    serve(port, signOneKBImpl)
+    tlsConfig, err := transport.ServerTLSConfig(tlscert, tlskey, tlsca)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    service, err := signer.NewCosignService(suitename, kfilepath, groupfilepath, dkgsharepath)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    policy, err := signer.LoadPolicy(policypath)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    audit, err := signer.OpenAuditLog(auditpath)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    defer audit.Close()
+    router := signer.Router{
+        Services: map[string]signer.Service{transport.ProtocolMultisig: service},
+        Policy:   policy,
+        Audit:    audit,
+    }
+    options := signer.ServerOptions{
+        MaxMessageSize: uint32(maxmsg),
+        TLSConfig:      tlsConfig,
+        Timeout:        timeout,
+        SessionTimeout: sessiontimeout,
+        MaxSessions:    maxsessions,
+    }
+    err = signer.Serve(signer.ShutdownContext(), port, options, router.Handle)
+    if err != nil {
+        fmt.Println("Error " + err.Error())
+    }
 }
@@ -3,11 +3,10 @@ package main
 
 import (
     "fmt"
-    "net"
     "os"
-    "io/ioutil"
-	"github.com/dedis/crypto/edwards/ed25519"
 	"vennard.ch/crypto"
+	"vennard.ch/signer"
+	"vennard.ch/transport"
     kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -21,16 +20,20 @@ var (
     appPrivatekeyfile = app.Arg("privatekey", "Path to schnorr private key").Required().String()
     appInfo = app.Arg("info", "Output file path to write (appends .pub, .pri)").Required().String()
     appPort = app.Arg("port", "Listen on port").Int()
+    appMaxMessage = app.Flag("maxmsg", "Largest message in bytes we accept").Default("1048576").Uint32()
+    appSuite = app.Flag("suite", "Suite the key is in: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
+    appTLSCert = app.Flag("tlscert", "Serve over TLS with this certificate (PEM)").String()
+    appTLSKey = app.Flag("tlskey", "Private key for --tlscert (PEM)").String()
+    appTLSCA = app.Flag("tlsca", "Require client certificates signed by the CAs in this file (mutual TLS)").String()
+    appPolicy = app.Flag("policy", "Only serve clients that authenticate and are allowed to by this policy file").String()
+    appTimeout = app.Flag("timeout", "Disconnect clients that take longer than this over any one message").Default(transport.DefaultTimeout.String()).Duration()
+    appSessionTimeout = app.Flag("sessiontimeout", "Disconnect clients whose session takes longer than this altogether").Default(signer.DefaultSessionTimeout.String()).Duration()
+    appMaxSessions = app.Flag("maxsessions", "Serve at most this many clients at once, 0 for no limit").Default("0").Int()
+    appLogFormat = app.Flag("logformat", "Log as text or json").Default("text").Enum("text", "json")
+    appLogLevel = app.Flag("loglevel", "Log at this level and above: debug, info, warn or error").Default("info").String()
+    appAudit = app.Flag("audit", "Append a record of every signature issued or refused to this file").String()
 )
 
-func LoadInfo (path string) ([]byte, error) {
-    fcontents, err := ioutil.ReadFile(path)
-    if err != nil {
-        return nil, err
-    }
-    return fcontents, nil
-}
-
 /* runs through the process of setting up the server as specified in the args */
 func main() {
 
@@ -42,25 +45,48 @@ func main() {
 
     fmt.Printf("Sigserv3 - listening on port %d.\n", port)
 
-    suite := ed25519.NewAES128SHA256Ed25519(true) 
-    kv, err := crypto.SchnorrLoadKeypair(kfilepath, suite)
+    logger, err := signer.NewLogger(os.Stdout, *appLogFormat, *appLogLevel)
     if err != nil {
     	fmt.Println("Error " + err.Error())
     	return
     }
+    signer.SetLogger(logger)
 
-    info, err := LoadInfo(kinfopath)
+    tlsConfig, err := transport.ServerTLSConfig(*appTLSCert, *appTLSKey, *appTLSCA)
     if err != nil {
-        fmt.Println("Error " + err.Error())
-        return
+    	fmt.Println("Error " + err.Error())
+    	return
     }
-
-    // I don't know if there's a way to 
-    // do std::bind-like behaviour in GO.
-    // for C++ what I'd do is pretty simple: 
-    // newfunc := std::bind(&func, args to bind)
-    var signBlindImpl connectionhandler = func(conn net.Conn) {
-        signBlindlySchnorr(conn, suite, kv, info)
+    service, err := signer.NewBlindService(*appSuite, kfilepath, kinfopath)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    policy, err := signer.LoadPolicy(*appPolicy)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    audit, err := signer.OpenAuditLog(*appAudit)
+    if err != nil {
+    	fmt.Println("Error " + err.Error())
+    	return
+    }
+    defer audit.Close()
+    router := signer.Router{
+        Services: map[string]signer.Service{transport.ProtocolBlind: service},
+        Policy:   policy,
+        Audit:    audit,
+    }
+    options := signer.ServerOptions{
+        MaxMessageSize: *appMaxMessage,
+        TLSConfig:      tlsConfig,
+        Timeout:        *appTimeout,
+        SessionTimeout: *appSessionTimeout,
+        MaxSessions:    *appMaxSessions,
+    }
+    err = signer.Serve(signer.ShutdownContext(), port, options, router.Handle)
+    if err != nil {
+        fmt.Println("Error " + err.Error())
     }
-    serve(port, signBlindImpl)
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"vennard.ch/crypto"
+)
+
+/* Signs the file exactly as sigserv1 would if sent the file's
+   hash, and writes the detached signature to output. An ed25519
+   key signs as Ed25519ph, which only takes sha512. */
+func runSign(keypath string, scheme crypto.SignatureScheme, suiteName string,
+             algorithm string, path string, output string) error {
+
+	digest, err := crypto.PrehashFile(algorithm, path)
+	if err != nil {
+		return err
+	}
+	var signature []byte
+	var suite string
+	if scheme == crypto.SchemeEd25519 {
+		kv, err := crypto.Ed25519LoadKeypair(keypath)
+		if err != nil {
+			return err
+		}
+		signature, err = crypto.Ed25519SignPrehash(kv, algorithm, digest)
+		if err != nil {
+			return err
+		}
+	} else {
+		schnorrSuite, err := crypto.SuiteByName(suiteName)
+		if err != nil {
+			return err
+		}
+		kv, err := crypto.SchnorrLoadKeypair(keypath, schnorrSuite)
+		if err != nil {
+			return err
+		}
+		msg, err := crypto.PrehashMessage(algorithm, digest)
+		if err != nil {
+			return err
+		}
+		signature, err = crypto.SchnorrSign(schnorrSuite, kv, msg, nil)
+		if err != nil {
+			return err
+		}
+		suite = schnorrSuite.String()
+	}
+
+	return crypto.SaveDetachedSignature(output, crypto.DetachedSignature{
+		Scheme:        scheme,
+		Suite:         suite,
+		HashAlgorithm: algorithm,
+		Digest:        digest,
+		Signature:     signature,
+	})
+}
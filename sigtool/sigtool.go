@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"vennard.ch/crypto"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+/* sigtool signs and checks detached signatures locally, without
+   talking to any server. The .sig files are the ones sigcli1 writes,
+   so a CI job can check what sigserv1 or a signing group has signed.
+   Every command exits with status 1 if anything fails, including a
+   signature that doesn't verify. */
+var (
+
+	app = kingpin.New("sigtool", "Sign files and verify detached signatures")
+
+	signCmd = app.Command("sign", "Sign a file with a private key, writing <file>.sig")
+	signCmdKey = signCmd.Flag("key", "Private key file").Required().String()
+	signCmdScheme = signCmd.Flag("scheme", "Signature scheme of the key: schnorr or ed25519 (RFC 8032)").Default("schnorr").Enum("schnorr", "ed25519")
+	signCmdHash = signCmd.Flag("hash", "Hash algorithm: sha256, sha512, sha3-256 or sha3-512. Defaults to sha3-256, or sha512 for ed25519, the only one it takes").Enum("sha256", "sha512", "sha3-256", "sha3-512")
+	signCmdSig = signCmd.Flag("sig", "Write the signature here instead of <file>.sig").String()
+	signCmdSuite = signCmd.Flag("suite", "Suite of a schnorr key: ed25519 or p256").Default(crypto.DefaultSuiteName).String()
+	signCmdFile = signCmd.Arg("file", "File to sign").Required().String()
+
+	verifyCmd = app.Command("verify", "Verify a detached signature on a file against a public key")
+	verifyCmdPub = verifyCmd.Flag("pub", "Public key file").Required().String()
+	verifyCmdSig = verifyCmd.Flag("sig", "Signature file, <file>.sig if not given").String()
+	verifyCmdFile = verifyCmd.Arg("file", "File that was signed").Required().String()
+
+	groupCmd = app.Command("verify-group", "Verify a detached signature on a file against a group's joint key")
+	groupCmdConfig = groupCmd.Flag("config", "Group configuration file").Required().String()
+	groupCmdSig = groupCmd.Flag("sig", "Signature file, <file>.sig if not given").String()
+	groupCmdFile = groupCmd.Arg("file", "File that was signed").Required().String()
+
+	messageCmd = app.Command("verify-message", "Verify a signed message saved by sigcli2 or sigcli3 with --output")
+	messageCmdPub = messageCmd.Flag("pub", "Public key the message must be signed under").Required().String()
+	messageCmdSuite = messageCmd.Flag("suite", "Suite the message must be signed in: ed25519 or p256. Files too old to say are taken to be in this one, or ed25519").String()
+	messageCmdFile = messageCmd.Arg("file", "Signed message file, in any of the output formats").Required().String()
+)
+
+// The signature file to use for path when --sig wasn't given.
+func sigPath(sig string, path string) string {
+	if sig != "" {
+		return sig
+	}
+	return crypto.DetachedSignaturePath(path)
+}
+
+func main() {
+
+	var err error
+
+	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
+	case signCmd.FullCommand():
+		scheme, _ := crypto.ParseSignatureScheme(*signCmdScheme)
+		output := sigPath(*signCmdSig, *signCmdFile)
+		algorithm := *signCmdHash
+		if algorithm == "" {
+			algorithm = crypto.DefaultHashAlgorithmFor(scheme)
+		}
+		err = runSign(*signCmdKey, scheme, *signCmdSuite, algorithm, *signCmdFile, output)
+		if err == nil {
+			fmt.Println("Signature written to", output)
+		}
+	case verifyCmd.FullCommand():
+		err = runVerify(*verifyCmdPub, sigPath(*verifyCmdSig, *verifyCmdFile), *verifyCmdFile)
+	case groupCmd.FullCommand():
+		err = runVerifyGroup(*groupCmdConfig, sigPath(*groupCmdSig, *groupCmdFile), *groupCmdFile)
+	case messageCmd.FullCommand():
+		err = runVerifyMessage(*messageCmdPub, *messageCmdSuite, *messageCmdFile)
+	}
+
+	if err != nil {
+		fmt.Println("Error", err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/dedis/crypto/abstract"
+	"vennard.ch/crypto"
+)
+
+/* Loads the signature and hashes the file again. The digest in the
+   .sig file is only there for information; what counts is the file
+   as it is now, so if the two differ we stop here. Returns the
+   signature and the file's digest. */
+func loadSignedFile(sigpath string, path string) (crypto.DetachedSignature, []byte, error) {
+	sig, err := crypto.LoadDetachedSignature(sigpath)
+	if err != nil {
+		return sig, nil, err
+	}
+	digest, err := crypto.PrehashFile(sig.HashAlgorithm, path)
+	if err != nil {
+		return sig, nil, err
+	}
+	if !bytes.Equal(digest, sig.Digest) {
+		return sig, nil, fmt.Errorf("%s has changed since it was signed", path)
+	}
+	return sig, digest, nil
+}
+
+// Checks a Schnorr signature on a file's digest.
+func verifySchnorrFile(suite abstract.Suite, pk crypto.SchnorrPublicKey, sig crypto.DetachedSignature, digest []byte) (bool, error) {
+	msg, err := crypto.PrehashMessage(sig.HashAlgorithm, digest)
+	if err != nil {
+		return false, err
+	}
+	return crypto.SchnorrVerify(suite, pk, msg, sig.Signature)
+}
+
+func reportVerify(v bool, err error) error {
+	if err != nil {
+		return err
+	}
+	if !v {
+		return errors.New("signature verify FAILED")
+	}
+	fmt.Println("Signature verified OK")
+	return nil
+}
+
+/* Checks the signature against a single public key, in whichever
+   scheme and suite the signature says it was made with. */
+func runVerify(pubpath string, sigpath string, path string) error {
+	sig, digest, err := loadSignedFile(sigpath, path)
+	if err != nil {
+		return err
+	}
+
+	switch sig.Scheme {
+	case crypto.SchemeEd25519:
+		pk, err := crypto.Ed25519LoadPubkey(pubpath)
+		if err != nil {
+			return err
+		}
+		return reportVerify(crypto.Ed25519VerifyPrehash(pk, sig.HashAlgorithm, digest, sig.Signature))
+	case crypto.SchemeSchnorr:
+		suite, err := crypto.SuiteByName(sig.Suite)
+		if err != nil {
+			return err
+		}
+		pk, err := crypto.SchnorrLoadPubkey(pubpath, suite)
+		if err != nil {
+			return err
+		}
+		return reportVerify(verifySchnorrFile(suite, pk, sig, digest))
+	}
+	return fmt.Errorf("unknown signature scheme %s", sig.Scheme)
+}
+
+/* Checks a multi- or threshold signature against the joint key in
+   the group configuration. A group signature is an ordinary Schnorr
+   signature under that key. As in sigcli2 we refuse a group whose
+   members haven't all proved they hold their keys, since the joint
+   key could then be one somebody chose to cancel the others out. */
+func runVerifyGroup(configpath string, sigpath string, path string) error {
+	config, err := crypto.SchnorrMLoadGroupConfig(configpath)
+	if err != nil {
+		return err
+	}
+	suite, err := config.GetSuite()
+	if err != nil {
+		return err
+	}
+	bad := config.VerifyProofs(suite)
+	if bad >= 0 {
+		return fmt.Errorf("member %d has no valid proof of possession for its key", bad)
+	}
+
+	sig, digest, err := loadSignedFile(sigpath, path)
+	if err != nil {
+		return err
+	}
+	if sig.Scheme != crypto.SchemeSchnorr {
+		return fmt.Errorf("group signatures are Schnorr signatures, not %s", sig.Scheme)
+	}
+	if sigSuite, err := crypto.SuiteByName(sig.Suite); err != nil || crypto.SuiteName(sigSuite) != crypto.SuiteName(suite) {
+		return fmt.Errorf("the signature is not in the group's suite %s", crypto.SuiteName(suite))
+	}
+	return reportVerify(verifySchnorrFile(suite, config.JointKey, sig, digest))
+}
+
+/* Checks a signed message file against the public key in pubpath.
+   The key travels with the signature, but on its own that only shows
+   the file is consistent: anybody can sign anything with a key of
+   their own, so the key has to be the one we expected. The file says
+   which suite it is in; if suiteName is given it has to be that one. */
+func runVerifyMessage(pubpath string, suiteName string, path string) error {
+	var suite abstract.Suite
+	if suiteName != "" {
+		var err error
+		suite, err = crypto.SuiteByName(suiteName)
+		if err != nil {
+			return err
+		}
+	}
+
+	signed, err := crypto.LoadSignedMessage(path, suite)
+	if err != nil {
+		return err
+	}
+	suite, err = signed.GetSuite()
+	if err != nil {
+		return err
+	}
+	pk, err := crypto.SchnorrLoadPubkey(pubpath, suite)
+	if err != nil {
+		return err
+	}
+	if !pk.Y.Equal(signed.PublicKey.Y) {
+		return fmt.Errorf("%s is signed by a different key", path)
+	}
+	return reportVerify(signed.Verify(suite))
+}
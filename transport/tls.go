@@ -0,0 +1,100 @@
+package transport
+
+/* TLS for the framed connections. The servers and clients take
+   certificate paths from their flags (or sigd's configuration) and
+   turn them into a tls.Config here; a nil config means plain TCP,
+   as before.
+
+   A server always needs a certificate and key. Giving it a CA file
+   as well turns on mutual TLS: clients must then present a
+   certificate signed by one of those CAs or the handshake fails.
+   A client verifies the server against the CA file it is given, or
+   the system roots without one, and presents its own certificate
+   if it has one. */
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net"
+)
+
+// Reads a file of PEM certificates into a pool.
+func loadCertPool(path string) (*x509.CertPool, error) {
+    pem, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(pem) {
+        return nil, fmt.Errorf("no certificates found in %s", path)
+    }
+    return pool, nil
+}
+
+// The server side. certFile and keyFile are required, clientCAFile
+// turns on client authentication. Returns nil if certFile, keyFile
+// and clientCAFile are all empty, i.e. TLS was not asked for.
+func ServerTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+    if certFile == "" && keyFile == "" && clientCAFile == "" {
+        return nil, nil
+    }
+    if certFile == "" || keyFile == "" {
+        return nil, errors.New("TLS needs both a certificate and a key")
+    }
+    cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        return nil, err
+    }
+    config := &tls.Config{
+        Certificates: []tls.Certificate{cert},
+        MinVersion:   tls.VersionTLS12,
+    }
+    if clientCAFile != "" {
+        config.ClientCAs, err = loadCertPool(clientCAFile)
+        if err != nil {
+            return nil, err
+        }
+        config.ClientAuth = tls.RequireAndVerifyClientCert
+    }
+    return config, nil
+}
+
+// The client side. caFile is who we trust to have signed the server's
+// certificate, certFile and keyFile our own certificate for servers
+// that want one. Returns nil if useTLS is false and no paths are given.
+func ClientTLSConfig(useTLS bool, caFile string, certFile string, keyFile string) (*tls.Config, error) {
+    if !useTLS && caFile == "" && certFile == "" && keyFile == "" {
+        return nil, nil
+    }
+    config := &tls.Config{MinVersion: tls.VersionTLS12}
+    var err error
+    if caFile != "" {
+        config.RootCAs, err = loadCertPool(caFile)
+        if err != nil {
+            return nil, err
+        }
+    }
+    if certFile != "" || keyFile != "" {
+        if certFile == "" || keyFile == "" {
+            return nil, errors.New("a client certificate needs both a certificate and a key")
+        }
+        cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+        if err != nil {
+            return nil, err
+        }
+        config.Certificates = []tls.Certificate{cert}
+    }
+    return config, nil
+}
+
+// Listens on port, over TLS if config is not nil.
+func Listen(port int, config *tls.Config) (net.Listener, error) {
+    portspec := fmt.Sprintf(":%d", port)
+    if config == nil {
+        return net.Listen("tcp", portspec)
+    }
+    return tls.Listen("tcp", portspec, config)
+}
@@ -0,0 +1,251 @@
+package transport
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "io/ioutil"
+    "math/big"
+    "net"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// A certificate and its key, written out as PEM files.
+type testCert struct {
+    cert        *x509.Certificate
+    key         *ecdsa.PrivateKey
+    certFile    string
+    keyFile     string
+}
+
+var testSerial int64
+
+// Makes a certificate for name signed by parent, or a self-signed
+// CA if parent is nil, and writes it to dir.
+func newTestCert(t *testing.T, dir string, name string, parent *testCert) *testCert {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil { t.Fatal(err.Error()) }
+    testSerial++
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(testSerial),
+        Subject:      pkix.Name{CommonName: name},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+        KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+        IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+    }
+    signer, signerKey := template, key
+    if parent == nil {
+        template.IsCA = true
+        template.BasicConstraintsValid = true
+    } else {
+        signer, signerKey = parent.cert, parent.key
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+    if err != nil { t.Fatal(err.Error()) }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil { t.Fatal(err.Error()) }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil { t.Fatal(err.Error()) }
+
+    tc := &testCert{cert, key, filepath.Join(dir, name + ".crt"), filepath.Join(dir, name + ".key")}
+    ioutil.WriteFile(tc.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+    ioutil.WriteFile(tc.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+    return tc
+}
+
+// Serves one connection on a TLS listener, answering a HELLO with
+// the protocol it asked for. Returns the address and a channel with
+// the server's error, if any.
+func tlsEchoServer(t *testing.T, certFile, keyFile, clientCAFile string) (string, chan error) {
+    config, err := ServerTLSConfig(certFile, keyFile, clientCAFile)
+    if err != nil { t.Fatal(err.Error()) }
+    sock, err := Listen(0, config)
+    if err != nil { t.Fatal(err.Error()) }
+
+    done := make(chan error, 1)
+    go func() {
+        defer sock.Close()
+        conn, err := sock.Accept()
+        if err != nil {
+            done <- err
+            return
+        }
+        tconn := NewConn(conn)
+        defer tconn.Close()
+        protocol, err := tconn.ExpectHello()
+        if err == nil {
+            err = tconn.AcceptHello()
+        }
+        if err == nil {
+            err = tconn.Send(1, []byte(protocol))
+        }
+        done <- err
+    }()
+    _, port, _ := net.SplitHostPort(sock.Addr().String())
+    return net.JoinHostPort("127.0.0.1", port), done
+}
+
+func TestTLS(t *testing.T) {
+    dir := t.TempDir()
+    ca := newTestCert(t, dir, "ca", nil)
+    server := newTestCert(t, dir, "server", ca)
+
+    addr, done := tlsEchoServer(t, server.certFile, server.keyFile, "")
+    config, err := ClientTLSConfig(true, ca.certFile, "", "")
+    if err != nil { t.Fatal(err.Error()) }
+    conn, err := DialProtocol(addr, ProtocolSign, config)
+    if err != nil { t.Fatal(err.Error()) }
+    defer conn.Close()
+    reply, err := conn.Expect(1)
+    if err != nil || string(reply) != ProtocolSign {
+        t.Error("No reply over TLS", err)
+    }
+    if err = <-done; err != nil {
+        t.Error("Server failed", err)
+    }
+
+    // a server we have no reason to trust
+    other := newTestCert(t, dir, "other", nil)
+    addr, _ = tlsEchoServer(t, server.certFile, server.keyFile, "")
+    config, err = ClientTLSConfig(true, other.certFile, "", "")
+    if err != nil { t.Fatal(err.Error()) }
+    _, err = Dial(addr, config)
+    if err == nil {
+        t.Error("Connected to a server signed by a CA we don't trust")
+    }
+
+    // and plaintext gets nowhere with a TLS server
+    addr, done = tlsEchoServer(t, server.certFile, server.keyFile, "")
+    conn, err = DialProtocol(addr, ProtocolSign, nil)
+    if err == nil {
+        _, err = conn.Receive()
+        conn.Close()
+    }
+    if err == nil || <-done == nil {
+        t.Error("Plaintext client was served by a TLS server")
+    }
+}
+
+func TestMutualTLS(t *testing.T) {
+    dir := t.TempDir()
+    ca := newTestCert(t, dir, "ca", nil)
+    server := newTestCert(t, dir, "server", ca)
+    client := newTestCert(t, dir, "client", ca)
+
+    addr, done := tlsEchoServer(t, server.certFile, server.keyFile, ca.certFile)
+    config, err := ClientTLSConfig(false, ca.certFile, client.certFile, client.keyFile)
+    if err != nil { t.Fatal(err.Error()) }
+    conn, err := DialProtocol(addr, ProtocolBlind, config)
+    if err != nil { t.Fatal(err.Error()) }
+    reply, err := conn.Expect(1)
+    conn.Close()
+    if err != nil || string(reply) != ProtocolBlind {
+        t.Error("No reply over mutual TLS", err)
+    }
+    if err = <-done; err != nil {
+        t.Error("Server failed", err)
+    }
+
+    // without a certificate, or with one from another CA, the
+    // server must hang up before serving anything
+    rogueCA := newTestCert(t, dir, "rogueca", nil)
+    rogue := newTestCert(t, dir, "rogue", rogueCA)
+    for _, cert := range []*testCert{nil, rogue} {
+        addr, done = tlsEchoServer(t, server.certFile, server.keyFile, ca.certFile)
+        if cert == nil {
+            config, err = ClientTLSConfig(true, ca.certFile, "", "")
+        } else {
+            config, err = ClientTLSConfig(true, ca.certFile, cert.certFile, cert.keyFile)
+        }
+        if err != nil { t.Fatal(err.Error()) }
+        conn, err = DialProtocol(addr, ProtocolBlind, config)
+        if err == nil {
+            _, err = conn.Receive()
+            conn.Close()
+        }
+        if err == nil || <-done == nil {
+            t.Error("Served a client without a valid certificate")
+        }
+    }
+}
+
+func TestTLSConfigs(t *testing.T) {
+    config, err := ServerTLSConfig("", "", "")
+    if config != nil || err != nil {
+        t.Error("TLS turned on without being asked for")
+    }
+    _, err = ServerTLSConfig("server.crt", "", "")
+    if err == nil {
+        t.Error("Server TLS config without a key")
+    }
+    config, err = ClientTLSConfig(false, "", "", "")
+    if config != nil || err != nil {
+        t.Error("Client TLS turned on without being asked for")
+    }
+    _, err = ClientTLSConfig(true, "", "client.crt", "")
+    if err == nil {
+        t.Error("Client certificate without a key")
+    }
+}
+
+// Both ends of a TLS connection export the same keying material,
+// and a plain connection none.
+func TestExportKeyingMaterial(t *testing.T) {
+    dir := t.TempDir()
+    ca := newTestCert(t, dir, "ca", nil)
+    server := newTestCert(t, dir, "server", ca)
+    serverConfig, err := ServerTLSConfig(server.certFile, server.keyFile, "")
+    if err != nil { t.Fatal(err.Error()) }
+    sock, err := Listen(0, serverConfig)
+    if err != nil { t.Fatal(err.Error()) }
+    defer sock.Close()
+
+    exported := make(chan []byte, 1)
+    go func() {
+        conn, err := sock.Accept()
+        if err != nil {
+            exported <- nil
+            return
+        }
+        tconn := NewConn(conn)
+        defer tconn.Close()
+        tconn.ExpectHello()
+        material, _ := tconn.ExportKeyingMaterial("test label", 32)
+        tconn.AcceptHello()
+        exported <- material
+    }()
+
+    _, port, _ := net.SplitHostPort(sock.Addr().String())
+    config, err := ClientTLSConfig(true, ca.certFile, "", "")
+    if err != nil { t.Fatal(err.Error()) }
+    conn, err := DialProtocol(net.JoinHostPort("127.0.0.1", port), ProtocolSign, config)
+    if err != nil { t.Fatal(err.Error()) }
+    defer conn.Close()
+    material, err := conn.ExportKeyingMaterial("test label", 32)
+    if err != nil { t.Fatal(err.Error()) }
+    other, err := conn.ExportKeyingMaterial("other label", 32)
+    if err != nil { t.Fatal(err.Error()) }
+
+    serverMaterial := <-exported
+    if len(material) != 32 || string(material) != string(serverMaterial) {
+        t.Error("The two ends exported different keying material")
+    }
+    if string(material) == string(other) {
+        t.Error("The label made no difference")
+    }
+
+    a, b := net.Pipe()
+    defer a.Close()
+    defer b.Close()
+    material, err = NewConn(a).ExportKeyingMaterial("test label", 32)
+    if err != nil || material != nil {
+        t.Error("Exported keying material without TLS")
+    }
+}
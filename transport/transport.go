@@ -0,0 +1,307 @@
+package transport
+
+/* Framed messages over a stream connection. Every server and client
+   in this repository used to do a single conn.Read into a fixed size
+   buffer and hope the whole message had arrived in one go; over a real
+   network it often hasn't. Instead every message is now sent as
+
+       type     1 byte   what the message is, up to each protocol
+       version  1 byte   Version, so the framing can change later
+       length   4 bytes  big endian length of the payload
+       payload  length bytes
+
+   and read back with io.ReadFull, however the bytes are split up on
+   the way. A length above the receiver's maximum is refused before 
+   anything is allocated for it, so a peer can't make us allocate 4GB
+   by sending a bogus header.
+
+   The first message on every connection is a HELLO from the client
+   naming the protocol it wants to speak, so one server on one port
+   can serve all of them (see the signer package). The server answers
+   with a HELLO of its own to go ahead, an AUTH_CHALLENGE if the client
+   has to authenticate first, or an ERROR if it won't serve us.
+
+   A Conn with a timeout (SetTimeout) gives each message that long to
+   be sent or to arrive, so a peer that stalls part way through a
+   protocol gets an error instead of holding us up for ever. */
+
+import (
+    "crypto/tls"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "time"
+)
+
+// The version of the framing written by this package.
+const Version byte = 1
+
+// type, version and length
+const HeaderSize = 6
+
+// Largest payload accepted unless told otherwise. Plenty for any 
+// of our protocols; file signing sends hashes, not files.
+const DefaultMaxMessageSize uint32 = 1 << 20
+
+// How long servers give a client for each message unless told
+// otherwise. Cosigning clients wait for every other server between
+// messages, so this is generous.
+const DefaultTimeout = time.Minute
+
+// Message types used before and around the protocols, kept clear
+// of the types the protocols themselves use. ERROR carries a reason
+// as text, for a peer that is refusing to go on; the AUTH messages
+// are the client authentication in the signer package.
+const (
+    MessageHello            byte = 0xff
+    MessageError            byte = 0xfe
+    MessageAuthChallenge    byte = 0xfd
+    MessageAuthResponse     byte = 0xfc
+)
+
+// The protocols a HELLO can ask for.
+const (
+    ProtocolSign        = "sign"        // a single Schnorr or Ed25519 signature
+    ProtocolMultisig    = "multisig"    // multisignature cosigning and DKG
+    ProtocolBlind       = "blind"       // partially blind signatures
+)
+
+var (
+    ErrMessageTooLarge = errors.New("transport: message exceeds the maximum size")
+    ErrBadVersion      = errors.New("transport: unsupported framing version")
+)
+
+// What Expect returns when the peer sent an ERROR instead of the
+// message we were waiting for.
+type RemoteError struct {
+    Reason  string
+}
+
+func (e RemoteError) Error() string {
+    return "refused by peer: " + e.Reason
+}
+
+// A single framed message.
+type Message struct {
+    Type    byte
+    Payload []byte
+}
+
+// Writes one message to w.
+func WriteMessage(w io.Writer, msgType byte, payload []byte, maxSize uint32) error {
+    if uint64(len(payload)) > uint64(maxSize) {
+        return ErrMessageTooLarge
+    }
+    frame := make([]byte, HeaderSize + len(payload))
+    frame[0] = msgType
+    frame[1] = Version
+    binary.BigEndian.PutUint32(frame[2:HeaderSize], uint32(len(payload)))
+    copy(frame[HeaderSize:], payload)
+    _, err := w.Write(frame)
+    return err
+}
+
+// Reads one message from r, refusing payloads longer than maxSize.
+// Returns io.EOF only if the stream ended cleanly between messages.
+func ReadMessage(r io.Reader, maxSize uint32) (Message, error) {
+    var header [HeaderSize]byte
+    _, err := io.ReadFull(r, header[:])
+    if err != nil {
+        return Message{}, err
+    }
+    if header[1] != Version {
+        return Message{}, ErrBadVersion
+    }
+    length := binary.BigEndian.Uint32(header[2:])
+    if length > maxSize {
+        return Message{}, ErrMessageTooLarge
+    }
+    payload := make([]byte, length)
+    _, err = io.ReadFull(r, payload)
+    if err == io.EOF {
+        err = io.ErrUnexpectedEOF
+    }
+    if err != nil {
+        return Message{}, err
+    }
+    return Message{Type: header[0], Payload: payload}, nil
+}
+
+// A connection that sends and receives framed messages.
+type Conn struct {
+    conn            net.Conn
+    maxMessageSize  uint32
+    timeout         time.Duration
+    protocol        string      // from the HELLO
+    challenge       []byte      // from the server's AUTH_CHALLENGE
+}
+
+// Wraps an established connection.
+func NewConn(conn net.Conn) *Conn {
+    return &Conn{conn: conn, maxMessageSize: DefaultMaxMessageSize}
+}
+
+// Connects to host:port over TCP, with TLS if config is not nil
+// (see tls.go).
+func Dial(hostspec string, config *tls.Config) (*Conn, error) {
+    var conn net.Conn
+    var err error
+    if config == nil {
+        conn, err = net.Dial("tcp", hostspec)
+    } else {
+        conn, err = tls.Dial("tcp", hostspec, config)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return NewConn(conn), nil
+}
+
+// Connects to host:port as Dial does, asks for protocol and waits
+// for the server to agree. If it wants us to authenticate first,
+// AuthChallenge says so.
+func DialProtocol(hostspec string, protocol string, config *tls.Config) (*Conn, error) {
+    conn, err := Dial(hostspec, config)
+    if err != nil {
+        return nil, err
+    }
+    err = conn.Hello(protocol)
+    if err == nil {
+        err = conn.expectGoAhead()
+    }
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return conn, nil
+}
+
+// Reads the server's answer to our HELLO.
+func (this * Conn) expectGoAhead() error {
+    msg, err := this.Receive()
+    if err != nil {
+        return err
+    }
+    switch msg.Type {
+    case MessageHello:
+        return nil
+    case MessageAuthChallenge:
+        this.challenge = msg.Payload
+        return nil
+    case MessageError:
+        return RemoteError{string(msg.Payload)}
+    }
+    return fmt.Errorf("transport: expected an answer to our hello, got message type %d", msg.Type)
+}
+
+// Sets the largest payload we will send or accept.
+func (this * Conn) SetMaxMessageSize(size uint32) {
+    this.maxMessageSize = size
+}
+
+// Sets how long each Send and Receive may take, or 0 for as long as
+// it takes. A Conn that has timed out should be closed.
+func (this * Conn) SetTimeout(timeout time.Duration) {
+    this.timeout = timeout
+}
+
+func (this * Conn) Send(msgType byte, payload []byte) error {
+    if this.timeout > 0 {
+        this.conn.SetWriteDeadline(time.Now().Add(this.timeout))
+    }
+    return WriteMessage(this.conn, msgType, payload, this.maxMessageSize)
+}
+
+func (this * Conn) Receive() (Message, error) {
+    if this.timeout > 0 {
+        this.conn.SetReadDeadline(time.Now().Add(this.timeout))
+    }
+    return ReadMessage(this.conn, this.maxMessageSize)
+}
+
+// Sends an ERROR giving the reason we won't go on.
+func (this * Conn) SendError(reason string) error {
+    return this.Send(MessageError, []byte(reason))
+}
+
+// Receives a message and checks it has the type we were waiting for.
+// An ERROR from the peer comes back as a RemoteError.
+func (this * Conn) Expect(msgType byte) ([]byte, error) {
+    msg, err := this.Receive()
+    if err != nil {
+        return nil, err
+    }
+    if msg.Type == MessageError && msgType != MessageError {
+        return nil, RemoteError{string(msg.Payload)}
+    }
+    if msg.Type != msgType {
+        return nil, fmt.Errorf("transport: expected message type %d, got %d", msgType, msg.Type)
+    }
+    return msg.Payload, nil
+}
+
+// Sends the HELLO asking for protocol. It must be the first
+// message on the connection.
+func (this * Conn) Hello(protocol string) error {
+    this.protocol = protocol
+    return this.Send(MessageHello, []byte(protocol))
+}
+
+// Reads the HELLO and returns the protocol it asks for.
+func (this * Conn) ExpectHello() (string, error) {
+    payload, err := this.Expect(MessageHello)
+    if err != nil {
+        return "", err
+    }
+    this.protocol = string(payload)
+    return this.protocol, nil
+}
+
+// Tells the client to go ahead with the protocol it asked for.
+func (this * Conn) AcceptHello() error {
+    return this.Send(MessageHello, []byte(this.protocol))
+}
+
+// The protocol named in the HELLO.
+func (this * Conn) Protocol() string {
+    return this.protocol
+}
+
+// The nonce the server sent in answer to our HELLO if it wants us 
+// to authenticate before going on, nil if not.
+func (this * Conn) AuthChallenge() []byte {
+    return this.challenge
+}
+
+// Keying material exported from the TLS session under label, as in
+// RFC 5705: the same at both ends of one session and unrelated in
+// any other, for binding something to this connection. nil if the
+// connection isn't TLS.
+func (this * Conn) ExportKeyingMaterial(label string, length int) ([]byte, error) {
+    tlsConn, ok := this.conn.(*tls.Conn)
+    if !ok {
+        return nil, nil
+    }
+    err := tlsConn.Handshake()
+    if err != nil {
+        return nil, err
+    }
+    state := tlsConn.ConnectionState()
+    return state.ExportKeyingMaterial(label, nil, length)
+}
+
+func (this * Conn) Close() error {
+    return this.conn.Close()
+}
+
+// Whether err is a Send or Receive that ran out of time.
+func IsTimeout(err error) bool {
+    ne, ok := err.(net.Error)
+    return ok && ne.Timeout()
+}
+
+func (this * Conn) RemoteAddr() net.Addr {
+    return this.conn.RemoteAddr()
+}
@@ -0,0 +1,233 @@
+package transport
+
+import (
+    "bytes"
+    "io"
+    "net"
+    "testing"
+    "testing/iotest"
+    "time"
+)
+
+func TestRoundTrip(t *testing.T) {
+    buf := bytes.Buffer{}
+    big := bytes.Repeat([]byte{0xab}, 100000)
+
+    for _, payload := range [][]byte{nil, []byte("hello"), big} {
+        err := WriteMessage(&buf, 7, payload, DefaultMaxMessageSize)
+        if err != nil { t.Fatal(err.Error()) }
+    }
+
+    // hand the bytes over one at a time, as a slow network might
+    r := iotest.OneByteReader(&buf)
+    for _, payload := range [][]byte{nil, []byte("hello"), big} {
+        msg, err := ReadMessage(r, DefaultMaxMessageSize)
+        if err != nil { t.Fatal(err.Error()) }
+        if msg.Type != 7 || !bytes.Equal(msg.Payload, payload) {
+            t.Error("Message changed in transit")
+        }
+    }
+    _, err := ReadMessage(r, DefaultMaxMessageSize)
+    if err != io.EOF {
+        t.Error("Expected a clean EOF after the last message, got", err)
+    }
+}
+
+func TestMaxSize(t *testing.T) {
+    buf := bytes.Buffer{}
+    err := WriteMessage(&buf, 1, make([]byte, 11), 10)
+    if err != ErrMessageTooLarge {
+        t.Error("Oversized message was sent")
+    }
+
+    // a header claiming 4GB must be refused without reading on
+    buf.Reset()
+    buf.Write([]byte{1, Version, 0xff, 0xff, 0xff, 0xff})
+    _, err = ReadMessage(&buf, DefaultMaxMessageSize)
+    if err != ErrMessageTooLarge {
+        t.Error("Oversized header accepted")
+    }
+}
+
+func TestBadFrames(t *testing.T) {
+    buf := bytes.NewBuffer([]byte{1, Version + 1, 0, 0, 0, 0})
+    _, err := ReadMessage(buf, DefaultMaxMessageSize)
+    if err != ErrBadVersion {
+        t.Error("Unknown version accepted")
+    }
+
+    // truncated payload
+    buf = bytes.NewBuffer([]byte{1, Version, 0, 0, 0, 5, 'a', 'b'})
+    _, err = ReadMessage(buf, DefaultMaxMessageSize)
+    if err != io.ErrUnexpectedEOF {
+        t.Error("Truncated message not reported, got", err)
+    }
+
+    // truncated header
+    buf = bytes.NewBuffer([]byte{1, Version, 0})
+    _, err = ReadMessage(buf, DefaultMaxMessageSize)
+    if err != io.ErrUnexpectedEOF {
+        t.Error("Truncated header not reported, got", err)
+    }
+}
+
+func TestConn(t *testing.T) {
+    client, server := net.Pipe()
+    c := NewConn(client)
+    s := NewConn(server)
+    defer c.Close()
+    defer s.Close()
+
+    go func() {
+        payload, err := s.Expect(1)
+        if err != nil {
+            return
+        }
+        s.Send(2, append(payload, '!'))
+    }()
+
+    err := c.Send(1, []byte("ping"))
+    if err != nil { t.Fatal(err.Error()) }
+    _, err = c.Expect(3)
+    if err == nil {
+        t.Error("Wrong message type accepted")
+    }
+}
+
+func TestConnExpect(t *testing.T) {
+    client, server := net.Pipe()
+    c := NewConn(client)
+    s := NewConn(server)
+    defer c.Close()
+    defer s.Close()
+
+    go func() {
+        payload, err := s.Expect(1)
+        if err != nil {
+            return
+        }
+        s.Send(2, append(payload, '!'))
+    }()
+
+    err := c.Send(1, []byte("ping"))
+    if err != nil { t.Fatal(err.Error()) }
+    reply, err := c.Expect(2)
+    if err != nil { t.Fatal(err.Error()) }
+    if string(reply) != "ping!" {
+        t.Error("Wrong reply", string(reply))
+    }
+}
+
+func TestHello(t *testing.T) {
+    client, server := net.Pipe()
+    c := NewConn(client)
+    s := NewConn(server)
+    defer c.Close()
+    defer s.Close()
+
+    go c.Hello(ProtocolBlind)
+    protocol, err := s.ExpectHello()
+    if err != nil { t.Fatal(err.Error()) }
+    if protocol != ProtocolBlind {
+        t.Error("Asked for", ProtocolBlind, "got", protocol)
+    }
+
+    // anything else first is not a hello
+    go c.Send(1, []byte(ProtocolBlind))
+    _, err = s.ExpectHello()
+    if err == nil {
+        t.Error("Accepted a message that isn't a hello")
+    }
+}
+
+func TestRemoteError(t *testing.T) {
+    client, server := net.Pipe()
+    c := NewConn(client)
+    s := NewConn(server)
+    defer c.Close()
+    defer s.Close()
+
+    go s.SendError("not allowed")
+    _, err := c.Expect(2)
+    remote, ok := err.(RemoteError)
+    if !ok || remote.Reason != "not allowed" {
+        t.Error("Expected the peer's reason, got", err)
+    }
+}
+
+func TestTimeout(t *testing.T) {
+    client, server := net.Pipe()
+    c := NewConn(client)
+    s := NewConn(server)
+    defer c.Close()
+    defer s.Close()
+    s.SetTimeout(50 * time.Millisecond)
+
+    // a peer that sends nothing, and one that reads nothing
+    _, err := s.Receive()
+    if !IsTimeout(err) {
+        t.Error("Receive from a stalled peer did not time out, got", err)
+    }
+    err = s.Send(1, []byte("anyone there?"))
+    if !IsTimeout(err) {
+        t.Error("Send to a stalled peer did not time out, got", err)
+    }
+
+    // each message gets the timeout afresh
+    s = NewConn(server)
+    s.SetTimeout(200 * time.Millisecond)
+    go func() {
+        for i := 0; i < 3; i++ {
+            time.Sleep(100 * time.Millisecond)
+            c.Send(1, []byte("slow"))
+        }
+    }()
+    for i := 0; i < 3; i++ {
+        _, err = s.Expect(1)
+        if err != nil { t.Fatal(err.Error()) }
+    }
+}
+
+// The three ways a server can answer a HELLO.
+func TestDialProtocol(t *testing.T) {
+    answers := []Message{
+        {MessageHello, []byte(ProtocolSign)},
+        {MessageAuthChallenge, []byte("nonce")},
+        {MessageError, []byte("not served here")},
+    }
+    sock, err := Listen(0, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    defer sock.Close()
+    go func() {
+        for _, answer := range answers {
+            conn, err := sock.Accept()
+            if err != nil {
+                return
+            }
+            tconn := NewConn(conn)
+            tconn.ExpectHello()
+            tconn.Send(answer.Type, answer.Payload)
+            tconn.Close()
+        }
+    }()
+    addr := sock.Addr().String()
+
+    conn, err := DialProtocol(addr, ProtocolSign, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    if conn.AuthChallenge() != nil || conn.Protocol() != ProtocolSign {
+        t.Error("Go ahead not understood")
+    }
+    conn.Close()
+
+    conn, err = DialProtocol(addr, ProtocolSign, nil)
+    if err != nil { t.Fatal(err.Error()) }
+    if string(conn.AuthChallenge()) != "nonce" {
+        t.Error("Challenge not kept")
+    }
+    conn.Close()
+
+    _, err = DialProtocol(addr, ProtocolSign, nil)
+    if _, ok := err.(RemoteError); !ok {
+        t.Error("Refusal not reported, got", err)
+    }
+}